@@ -0,0 +1,72 @@
+package main
+
+import (
+	"Aethernet/internel/callbacks"
+	"Aethernet/internel/utils"
+	"Aethernet/pkg/async"
+	"Aethernet/pkg/config"
+	"fmt"
+	"time"
+)
+
+// runs the device until the duration elapses, or until enter is pressed if the duration is 0
+func runDevice(flags *config.Flags, duration time.Duration, callback func(in, out []int32)) error {
+	cfg, err := flags.Load()
+	if err != nil {
+		return err
+	}
+	dev, err := config.CreateDevice(cfg)
+	if err != nil {
+		return err
+	}
+
+	dev.Start(callback)
+	defer dev.Stop()
+
+	if duration > 0 {
+		<-time.After(duration)
+	} else {
+		fmt.Println("Press Enter to stop")
+		<-async.EnterKey()
+	}
+	return nil
+}
+
+func runRecord(args []string) error {
+	fs, flags := newFlagSet("record")
+	output := fs.String("o", "recorder.bin", "the file to write the samples to")
+	duration := fs.Duration("t", 0, "the duration to record, 0 to stop on enter")
+	fs.Parse(args)
+
+	recorder := callbacks.Recorder{}
+	err := runDevice(flags, *duration, func(in, out []int32) {
+		recorder.Update([][]int32{in}, [][]int32{out})
+		clear(out)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recorded %d samples\n", len(recorder.Track))
+	return utils.WriteBinary(*output, recorder.Track)
+}
+
+func runPlay(args []string) error {
+	fs, flags := newFlagSet("play")
+	duration := fs.Duration("t", 0, "the duration to play, 0 to stop on enter")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one file to play")
+	}
+
+	track, err := utils.ReadBinary[int32](fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Playing %d samples\n", len(track))
+
+	player := callbacks.Player{Track: track}
+	return runDevice(flags, *duration, func(in, out []int32) {
+		player.Update([][]int32{in}, [][]int32{out})
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"Aethernet/pkg/bench"
+	"Aethernet/pkg/device"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+func runBench(args []string) error {
+	var grid bench.Grid
+	var cfg bench.Config
+
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	targets := fs.String("targets", bench.TargetModem, "comma separated targets among modem, naive and reliable")
+	carrierSize := fs.String("carrier", "2,3,4", "carrier sizes")
+	frameInterval := fs.String("interval", "256", "frame intervals in samples")
+	bytePerFrame := fs.String("frame", "125", "bytes per modem frame")
+	preambleN := fs.String("preamble", "4", "preamble lengths")
+	amplitude := fs.String("amplitude", "0.75", "carrier amplitudes relative to the full scale")
+	snr := fs.String("snr", "0,5,10,15,20", "SNRs in dB")
+	scheme := fs.String("scheme", "", "passband schemes among bpsk, qpsk and fsk, empty for the baseband")
+	equalizer := fs.String("equalizer", "0", "equalizer taps, 0 without the equalizer")
+	echos := fs.String("echo", "", "the multipath of the channel as comma separated delay:gain, e.g. 3:0.5,7:-0.2")
+	constellation := fs.String("constellation", "", "data constellations among bpsk, pam4, pam8, qpsk and qam16, empty for one bit per symbol")
+	fs.IntVar(&cfg.Packets, "packets", 10, "packets per point")
+	fs.IntVar(&cfg.PayloadSize, "size", 200, "bytes per packet")
+	fs.Float64Var(&cfg.Threshold, "threshold", 0, "the power threshold of the preamble detection, 0 for the default")
+	fs.DurationVar(&cfg.Timeout, "timeout", 2*time.Second, "how long the data link layers wait for each packet")
+	fs.Uint64Var(&cfg.Seed, "seed", 1, "the seed of the payloads and the noise")
+	format := fs.String("format", "csv", "the output format, csv or json")
+	output := fs.String("o", "", "the output file, stdout if empty")
+	fs.Parse(args)
+
+	var err error
+	if grid.CarrierSize, err = bench.ParseList[int](*carrierSize); err != nil {
+		return err
+	}
+	if grid.FrameInterval, err = bench.ParseList[int](*frameInterval); err != nil {
+		return err
+	}
+	if grid.BytePerFrame, err = bench.ParseList[int](*bytePerFrame); err != nil {
+		return err
+	}
+	if grid.PreambleN, err = bench.ParseList[int](*preambleN); err != nil {
+		return err
+	}
+	if grid.Amplitude, err = bench.ParseList[float64](*amplitude); err != nil {
+		return err
+	}
+	if grid.SNR, err = bench.ParseList[float64](*snr); err != nil {
+		return err
+	}
+	grid.Scheme = strings.Split(*scheme, ",")
+	grid.Constellation = strings.Split(*constellation, ",")
+	if grid.Equalizer, err = bench.ParseList[int](*equalizer); err != nil {
+		return err
+	}
+	if cfg.Echos, err = parseEchos(*echos); err != nil {
+		return err
+	}
+
+	// the progress goes to stderr so the results can be piped
+	results, err := bench.Sweep(cfg, grid, strings.Split(*targets, ","), func(r bench.Result) {
+		fmt.Fprintln(os.Stderr, r)
+	})
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return bench.Write(w, *format, results)
+}
+
+// parses the echos like "3:0.5,7:-0.2"
+func parseEchos(s string) ([]device.Echo, error) {
+	var echos []device.Echo
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		var echo device.Echo
+		if _, err := fmt.Sscanf(field, "%d:%g", &echo.Delay, &echo.Gain); err != nil || echo.Delay <= 0 {
+			return nil, fmt.Errorf("invalid echo %q, expected delay:gain with a positive delay in samples", field)
+		}
+		echos = append(echos, echo)
+	}
+	return echos, nil
+}
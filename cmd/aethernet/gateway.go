@@ -0,0 +1,208 @@
+package main
+
+import (
+	"Aethernet/pkg/async"
+	"Aethernet/pkg/config"
+	"Aethernet/pkg/dhcp"
+	"Aethernet/pkg/dns"
+	"Aethernet/pkg/iface"
+	aelayers "Aethernet/pkg/layers"
+	"Aethernet/pkg/router"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// used when the config does not list the allowed queries
+var defaultAllowedDNSQueries = []string{"baidu", "example"}
+
+func allow(packet gopacket.Packet, allowedDNSQueries []string) (allow bool) {
+
+	if _, ok := linkGroup(packet, netip.Prefix{}); ok {
+		// the broadcasts and multicasts of the segment, e.g. DHCP and mDNS
+		return true
+	}
+
+	icmpv4 := packet.Layer(layers.LayerTypeICMPv4)
+	dns := packet.Layer(layers.LayerTypeDNS)
+	tcp := packet.Layer(layers.LayerTypeTCP)
+	if icmpv4 != nil {
+		allow = true
+	} else if dns != nil {
+		dnsLayer := dns.(*layers.DNS)
+		for _, query := range dnsLayer.Questions {
+			if query.Type != layers.DNSTypeA {
+				return false
+			}
+			for _, allowQuery := range allowedDNSQueries {
+				if strings.Contains(string(query.Name), allowQuery) {
+					allow = true
+					break
+				}
+			}
+		}
+	} else if tcp != nil {
+		allow = true
+	}
+	return
+}
+
+// sends a packet of the host to the Aethernet, the broadcasts and multicasts to their link group and the others to every node
+// as the naive data link layer does not resolve the IP addresses
+func sendIP(layer *aelayers.NaiveDataLinkLayer, subnet netip.Prefix, packet gopacket.Packet) {
+	if group, ok := linkGroup(packet, subnet); ok {
+		layer.Multicast(group, packet.Data())
+		return
+	}
+	layer.Send(packet.Data())
+}
+
+func linkGroup(packet gopacket.Packet, subnet netip.Prefix) (aelayers.Group, bool) {
+	network := packet.NetworkLayer()
+	if network == nil {
+		return 0, false
+	}
+	dst, _ := netip.AddrFromSlice(network.NetworkFlow().Dst().Raw())
+	return router.LinkGroup(dst, subnet)
+}
+
+func runGateway(args []string) error {
+	fs, flags := newFlagSet("gateway")
+	fs.Parse(args)
+
+	cfg, err := flags.Load()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Config: %+v\n", cfg)
+
+	allowedDNSQueries := defaultAllowedDNSQueries
+	if len(cfg.Gateway.AllowedDNSQueries) > 0 {
+		allowedDNSQueries = cfg.Gateway.AllowedDNSQueries
+	}
+
+	dev, err := config.CreateDevice(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create device: %v", err)
+	}
+	layer, err := config.CreateNaiveDataLinkLayer(cfg, dev)
+	if err != nil {
+		return fmt.Errorf("failed to create data link layer: %v", err)
+	}
+
+	var dhcpServer *dhcp.Server
+	var dhcpClient *dhcp.Client
+	switch strings.ToLower(cfg.Gateway.DHCP.Role) {
+	case "server":
+		dhcpServer, err = config.CreateDHCPServer(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create DHCP server: %v", err)
+		}
+	case "client":
+		dhcpClient = &dhcp.Client{Link: layer}
+		layer.Address = dhcp.Unassigned
+	}
+
+	var dnsForwarder *dns.Forwarder
+	if cfg.Gateway.DNS.Upstream != "" {
+		dnsForwarder, err = config.CreateDNSForwarder(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create DNS forwarder: %v", err)
+		}
+	}
+
+	layer.Open()
+	defer layer.Close()
+
+	ready := make(chan struct{})
+	var handle iface.Interface
+
+	go func() {
+		for data := range layer.ReceiveAsync() {
+			if dhcpServer != nil && dhcpServer.Handle(layer, data) {
+				continue
+			}
+			if dhcpClient != nil && dhcpClient.Handle(data) {
+				continue
+			}
+			select {
+			case <-ready:
+			default:
+				// the interface is not opened yet
+				continue
+			}
+			packet, _ := iface.DecodeIPPacket(data)
+			if packet != nil && allow(packet, allowedDNSQueries) {
+				if dnsForwarder != nil && dns.IsQuery(packet) {
+					// answer on the gateway instead of forwarding the query to the internet
+					go func() {
+						reply, err := dnsForwarder.HandlePacket(packet)
+						if err != nil {
+							fmt.Printf("Error resolving DNS query: %v\n", err)
+							return
+						}
+						layer.Send(reply)
+					}()
+					continue
+				}
+				fmt.Printf("Received packet from Aethernet: %v\n", packet)
+				handle.Write(packet.Data())
+			}
+		}
+	}()
+
+	if dhcpClient != nil {
+		lease, err := dhcpClient.Acquire()
+		if err != nil {
+			return fmt.Errorf("failed to acquire address: %v", err)
+		}
+		fmt.Printf("Acquired MAC %d, IP %v\n", lease.MAC, lease.IP)
+		layer.SetAddress(lease.MAC)
+		cfg.Iface.IP = lease.IP.String()
+	}
+
+	handle, err = config.OpenInterface(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open interface: %v", err)
+	}
+
+	err = handle.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open interface: %v", err)
+	}
+	defer handle.Close()
+	close(ready)
+
+	if dhcpClient != nil {
+		// a lease acquired again after a failed renewal or a conflict moves the node to its new addresses
+		dhcpClient.OnLease = func(lease dhcp.Lease) {
+			layer.SetAddress(lease.MAC)
+			if err := handle.Info().SetIPv4(lease.IP.String()); err != nil {
+				fmt.Printf("Failed to set IP %v: %v\n", lease.IP, err)
+			}
+		}
+		stop := make(chan struct{})
+		defer close(stop)
+		go dhcpClient.Maintain(stop)
+	}
+
+	// the directed broadcast of the subnet of the interface, if it has one
+	subnet, _ := netip.ParsePrefix(cfg.Iface.IP)
+
+	go func() {
+		for packet := range handle.Packets() {
+			if allow(packet, allowedDNSQueries) {
+				fmt.Printf("Received packet from WinTUN: %v\n", packet)
+				sendIP(layer, subnet.Masked(), packet)
+			}
+		}
+	}()
+
+	<-async.Exit()
+	fmt.Println("Exiting...")
+	return nil
+}
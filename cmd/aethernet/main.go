@@ -0,0 +1,67 @@
+package main
+
+import (
+	"Aethernet/pkg/config"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"send":    {"send -to ADDRESS FILE\tsend a file over the reliable MAC", runSend},
+	"recv":    {"recv -o FILE\treceive a file over the reliable MAC", runRecv},
+	"ping":    {"ping -to ADDRESS | ping -serve\tmeasure the round trip time over the link", runPing},
+	"gateway": {"gateway\tbridge the link and the TUN/TAP/PCAP interface", runGateway},
+	"record":  {"record -o FILE\trecord raw samples from the device", runRecord},
+	"play":    {"play FILE\tplay raw samples on the device", runPlay},
+	"bench":   {"bench [-targets modem,naive,reliable] [-snr LIST]...\tsweep the modem parameters over a simulated channel", runBench},
+	"modem":   {"modem encode|decode -i INPUT -o OUTPUT\tconvert between bytes and signal files offline", runModem},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: aethernet COMMAND [-config FILE] [-set key=value]... [ARGS]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n", commands[name].usage)
+	}
+	w.Flush()
+}
+
+// creates the flag set of a command with the config flags registered
+func newFlagSet(name string) (*flag.FlagSet, *config.Flags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var flags config.Flags
+	flags.Register(fs, "config.yml")
+	return fs, &flags
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
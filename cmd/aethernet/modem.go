@@ -0,0 +1,78 @@
+package main
+
+import (
+	"Aethernet/internel/utils"
+	"Aethernet/pkg/config"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// signal files are WAV if they have the .wav extension and raw little endian int32 otherwise
+func readSignal(filename string) ([]int32, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".wav") {
+		signal, _, err := utils.ReadWAV(filename)
+		return signal, err
+	}
+	return utils.ReadBinary[int32](filename)
+}
+
+func writeSignal(filename string, signal []int32, sampleRate int) error {
+	if strings.EqualFold(filepath.Ext(filename), ".wav") {
+		return utils.WriteWAV(filename, signal, sampleRate)
+	}
+	return utils.WriteBinary(filename, signal)
+}
+
+func runModem(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("expected 'encode' or 'decode'")
+	}
+
+	fs, flags := newFlagSet("modem " + args[0])
+	input := fs.String("i", "INPUT.bin", "the input file")
+	output := fs.String("o", "OUTPUT.bin", "the output file, signal files ending with .wav are WAV files")
+	report := fs.Bool("report", false, "print the diagnostics of every frame when decoding")
+	fs.Parse(args[1:])
+
+	cfg, err := flags.Load()
+	if err != nil {
+		return err
+	}
+	// the modem parameters are the same as on the link, the device is never started
+	physical := config.CreatePhysicalLayer(cfg, nil)
+
+	switch args[0] {
+	case "encode":
+		data, err := utils.ReadBinary[byte](*input)
+		if err != nil {
+			return err
+		}
+		signal := physical.Encoder.Modulator.Modulate(data)
+		fmt.Printf("Encoded %d bytes to %d samples\n", len(data), len(signal))
+		return writeSignal(*output, signal, int(cfg.Device.SampleRate))
+
+	case "decode":
+		signal, err := readSignal(*input)
+		if err != nil {
+			return err
+		}
+
+		packets, reports := physical.Decoder.Demodulator.DemodulateAll(signal)
+		if *report {
+			for i, r := range reports {
+				fmt.Printf("[Frame %d] %v\n", i, r)
+			}
+		}
+
+		var data []byte
+		for _, packet := range packets {
+			data = append(data, packet...)
+		}
+		fmt.Printf("Decoded %d packets, %d bytes from %d samples, %d frames found\n", len(packets), len(data), len(signal), len(reports))
+		return utils.WriteBinary(*output, data)
+
+	default:
+		return fmt.Errorf("unknown modem command %s, expected 'encode' or 'decode'", args[0])
+	}
+}
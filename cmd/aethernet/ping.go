@@ -0,0 +1,97 @@
+package main
+
+import (
+	"Aethernet/pkg/async"
+	"Aethernet/pkg/layers"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	echoRequest = 0xe0
+	echoReply   = 0xe1
+)
+
+// Type (8 bit) | Source (16 bit) | Sequence (16 bit) | Payload
+// the source is carried since the reliable MAC does not tell who sent a packet
+func makeEcho(typ byte, source layers.ReliableDataLinkAddress, seq uint16, payload []byte) []byte {
+	message := binary.BigEndian.AppendUint16([]byte{typ}, uint16(source))
+	message = binary.BigEndian.AppendUint16(message, seq)
+	return append(message, payload...)
+}
+
+func runPing(args []string) error {
+	fs, flags := newFlagSet("ping")
+	to := fs.Uint("to", 0, "the address to ping")
+	count := fs.Int("c", 4, "the number of requests")
+	size := fs.Int("s", 32, "the payload size in bytes")
+	timeout := fs.Duration("timeout", 2*time.Second, "how long to wait for each reply")
+	serve := fs.Bool("serve", false, "answer the requests instead of sending them")
+	fs.Parse(args)
+
+	layer, err := openReliableLayer(flags)
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+
+	if *serve {
+		go func() {
+			for data := range layer.ReceiveAsync() {
+				if len(data) < 5 || data[0] != echoRequest {
+					continue
+				}
+				source := layers.ReliableDataLinkAddress(binary.BigEndian.Uint16(data[1:]))
+				fmt.Printf("Request seq=%d from %d\n", binary.BigEndian.Uint16(data[3:]), source)
+				reply := makeEcho(echoReply, layer.Address, binary.BigEndian.Uint16(data[3:]), data[5:])
+				if err := layer.Send(source, reply); err != nil {
+					fmt.Printf("Error sending reply: %v\n", err)
+				}
+			}
+		}()
+		<-async.Exit()
+		return nil
+	}
+
+	payload := make([]byte, *size)
+	var received int
+	var total, minRTT, maxRTT time.Duration
+	for seq := range *count {
+		start := time.Now()
+		if err := layer.Send(layers.ReliableDataLinkAddress(*to), makeEcho(echoRequest, layer.Address, uint16(seq), payload)); err != nil {
+			fmt.Printf("Request seq=%d failed: %v\n", seq, err)
+			continue
+		}
+
+		deadline := time.After(*timeout)
+	wait:
+		for {
+			select {
+			case data := <-layer.ReceiveAsync():
+				if len(data) < 5 || data[0] != echoReply || binary.BigEndian.Uint16(data[3:]) != uint16(seq) {
+					continue
+				}
+				rtt := time.Since(start)
+				fmt.Printf("Reply from %d: seq=%d bytes=%d time=%v\n", binary.BigEndian.Uint16(data[1:]), seq, len(data)-5, rtt)
+				if received == 0 || rtt < minRTT {
+					minRTT = rtt
+				}
+				maxRTT = max(maxRTT, rtt)
+				total += rtt
+				received++
+				break wait
+			case <-deadline:
+				fmt.Printf("Request seq=%d timed out\n", seq)
+				break wait
+			}
+		}
+	}
+
+	fmt.Printf("%d sent, %d received, %.0f%% loss", *count, received, 100*float64(*count-received)/float64(max(*count, 1)))
+	if received > 0 {
+		fmt.Printf(", rtt min/avg/max = %v/%v/%v", minRTT, total/time.Duration(received), maxRTT)
+	}
+	fmt.Println()
+	return nil
+}
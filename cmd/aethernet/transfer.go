@@ -0,0 +1,75 @@
+package main
+
+import (
+	"Aethernet/internel/utils"
+	"Aethernet/pkg/config"
+	"Aethernet/pkg/layers"
+	"fmt"
+	"time"
+)
+
+func openReliableLayer(flags *config.Flags) (*layers.ReliableDataLinkLayer, error) {
+	cfg, err := flags.Load()
+	if err != nil {
+		return nil, err
+	}
+	dev, err := config.CreateDevice(cfg)
+	if err != nil {
+		return nil, err
+	}
+	layer, err := config.CreateReliableDataLinkLayer(cfg, dev)
+	if err != nil {
+		return nil, err
+	}
+	layer.Open()
+	return layer, nil
+}
+
+func runSend(args []string) error {
+	fs, flags := newFlagSet("send")
+	to := fs.Uint("to", 0, "the address of the receiver")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one file to send")
+	}
+
+	data, err := utils.ReadBinary[byte](fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	layer, err := openReliableLayer(flags)
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+
+	start := time.Now()
+	if err := layer.Send(layers.ReliableDataLinkAddress(*to), data); err != nil {
+		return err
+	}
+	fmt.Printf("Sent %d bytes to %d in %v\n", len(data), *to, time.Since(start))
+	return nil
+}
+
+func runRecv(args []string) error {
+	fs, flags := newFlagSet("recv")
+	output := fs.String("o", "OUTPUT.bin", "the file to write the received data to")
+	timeout := fs.Duration("timeout", 0, "give up after this duration, 0 to wait forever")
+	fs.Parse(args)
+
+	layer, err := openReliableLayer(flags)
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+
+	var data []byte
+	if *timeout == 0 {
+		data = layer.Receive()
+	} else if data, err = layer.ReceiveWithTimeout(*timeout); err != nil {
+		return err
+	}
+	fmt.Printf("Received %d bytes at %s\n", len(data), time.Now().Format(time.RFC3339))
+	return utils.WriteBinary(*output, data)
+}
@@ -1,9 +0,0 @@
-package main
-
-import (
-	"Aethernet/cmd/project2/task3/config"
-)
-
-func main() {
-	config.Main(0x1, 0x2)
-}
@@ -14,6 +14,7 @@ require (
 
 require (
 	github.com/mdlayher/arp v0.0.0-20220512170110-6706a2966875
+	golang.org/x/crypto v0.36.0
 	golang.zx2c4.com/wintun v0.0.0-20230126152724-0fa3db229ce2
 )
 
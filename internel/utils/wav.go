@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+type wavFormat struct {
+	AudioFormat   uint16
+	NumChannels   uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+const wavPCM = 1
+
+// writes the samples as a mono 32-bit PCM WAV file
+func WriteWAV(filename string, samples []int32, sampleRate int) error {
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	format := wavFormat{
+		AudioFormat:   wavPCM,
+		NumChannels:   1,
+		SampleRate:    uint32(sampleRate),
+		ByteRate:      uint32(sampleRate) * 4,
+		BlockAlign:    4,
+		BitsPerSample: 32,
+	}
+	dataSize := uint32(len(samples) * 4)
+
+	var header bytes.Buffer
+	header.WriteString("RIFF")
+	binary.Write(&header, binary.LittleEndian, uint32(4+8+binary.Size(format)+8)+dataSize)
+	header.WriteString("WAVE")
+	header.WriteString("fmt ")
+	binary.Write(&header, binary.LittleEndian, uint32(binary.Size(format)))
+	binary.Write(&header, binary.LittleEndian, format)
+	header.WriteString("data")
+	binary.Write(&header, binary.LittleEndian, dataSize)
+
+	if _, err := file.Write(header.Bytes()); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := binary.Write(file, binary.LittleEndian, samples); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	return nil
+}
+
+// reads a 16, 24 or 32-bit PCM WAV file, the samples of the first channel are scaled to the full int32 range
+func ReadWAV(filename string) (samples []int32, sampleRate int, err error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open file: %v", err)
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, fmt.Errorf("%s is not a WAV file", filename)
+	}
+
+	var format *wavFormat
+	r := bytes.NewReader(data[12:])
+	for {
+		var id [4]byte
+		var size uint32
+		if _, err := io.ReadFull(r, id[:]); err != nil {
+			return nil, 0, fmt.Errorf("no data chunk in %s", filename)
+		}
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, 0, fmt.Errorf("failed to read file: %v", err)
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, 0, fmt.Errorf("truncated chunk %q: %v", id, err)
+		}
+		if size%2 == 1 {
+			r.ReadByte() // chunks are padded to an even size
+		}
+
+		switch string(id[:]) {
+		case "fmt ":
+			format = &wavFormat{}
+			if err := binary.Read(bytes.NewReader(chunk), binary.LittleEndian, format); err != nil {
+				return nil, 0, fmt.Errorf("invalid fmt chunk: %v", err)
+			}
+			if format.AudioFormat != wavPCM && format.AudioFormat != 0xfffe {
+				return nil, 0, fmt.Errorf("unsupported audio format %d, expected PCM", format.AudioFormat)
+			}
+			if width := int(format.BitsPerSample) / 8; width < 2 || width > 4 || int(format.BlockAlign) < width {
+				return nil, 0, fmt.Errorf("unsupported %d-bit samples in blocks of %d bytes", format.BitsPerSample, format.BlockAlign)
+			}
+		case "data":
+			if format == nil {
+				return nil, 0, fmt.Errorf("data chunk before fmt chunk")
+			}
+			return decodePCM(chunk, format), int(format.SampleRate), nil
+		}
+	}
+}
+
+func decodePCM(chunk []byte, format *wavFormat) []int32 {
+	width := int(format.BitsPerSample) / 8
+	stride := int(format.BlockAlign)
+	samples := make([]int32, 0, len(chunk)/stride)
+	for i := 0; i+width <= len(chunk); i += stride {
+		var sample int32
+		switch width {
+		case 2:
+			sample = int32(int16(binary.LittleEndian.Uint16(chunk[i:]))) << 16
+		case 3:
+			sample = int32(uint32(chunk[i])<<8 | uint32(chunk[i+1])<<16 | uint32(chunk[i+2])<<24)
+		case 4:
+			sample = int32(binary.LittleEndian.Uint32(chunk[i:]))
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
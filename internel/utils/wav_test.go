@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWAV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "signal.wav")
+	samples := []int32{0, 1, -1, 0x7fffffff, -0x80000000, 12345678}
+
+	if err := WriteWAV(filename, samples, 48000); err != nil {
+		t.Fatal(err)
+	}
+	read, sampleRate, err := ReadWAV(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sampleRate != 48000 {
+		t.Errorf("Sample rate %d, expected 48000", sampleRate)
+	}
+	if !reflect.DeepEqual(read, samples) {
+		t.Errorf("Read %v, expected %v", read, samples)
+	}
+}
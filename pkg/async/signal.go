@@ -24,7 +24,16 @@ func (s *Signal[T]) NotifyValue(value T) {
 	}
 }
 
+// the waiters share the channel until it is notified, so that a new waiter does not strand the previous ones
 func (s *Signal[T]) Signal() <-chan T {
+	if *s != nil {
+		select {
+		case <-*s:
+			// notified
+		default:
+			return *s
+		}
+	}
 	*s = make(chan T)
 	return *s
 }
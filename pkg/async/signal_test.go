@@ -46,3 +46,25 @@ func TestSignal_NotifyAndAwait(t *testing.T) {
 		t.Error("Expected to receive signal within 200ms")
 	}
 }
+
+func TestSignal_Waiters(t *testing.T) {
+	var s Signal[struct{}]
+
+	first := s.Signal()
+	second := s.Signal()
+	s.Notify()
+
+	for i, c := range []<-chan struct{}{first, second} {
+		select {
+		case <-c:
+		default:
+			t.Errorf("Expected waiter %d to be notified", i)
+		}
+	}
+
+	select {
+	case <-s.Signal():
+		t.Error("Expected a new channel after the notification")
+	default:
+	}
+}
@@ -0,0 +1,326 @@
+package bench
+
+import (
+	"Aethernet/pkg/config"
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/fixed"
+	"Aethernet/pkg/layers"
+	"Aethernet/pkg/modem"
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+const (
+	TargetModem    = "modem"
+	TargetNaive    = "naive"
+	TargetReliable = "reliable"
+)
+
+// Params is one point of the parameter grid
+type Params struct {
+	CarrierSize   int     `json:"carrier_size"`
+	FrameInterval int     `json:"frame_interval"`
+	BytePerFrame  int     `json:"byte_per_frame"`
+	PreambleN     int     `json:"preamble_n"`
+	Amplitude     float64 `json:"amplitude"`      // of the carrier relative to the full scale, the preamble is sent at full scale
+	SNR           float64 `json:"snr"`            // in dB
+	Scheme        string  `json:"scheme"`         // the passband scheme, "" for the baseband
+	Constellation string  `json:"constellation"`  // the symbols of the data, "" for one bit per symbol
+	Equalizer     int     `json:"equalizer_taps"` // 0 without the equalizer
+}
+
+type Result struct {
+	Params
+	Target  string  `json:"target"`
+	BER     float64 `json:"ber"`         // wrong or missing payload bits over the payload bits
+	FER     float64 `json:"fer"`         // frames (modem) or packets (data link layers) not received intact
+	Goodput float64 `json:"goodput_bps"` // payload bits received intact per simulated second
+	Latency float64 `json:"latency_ms"`  // mean time from sending to receiving a packet, in samples of the medium
+}
+
+// Config sets how every point is measured, the zero values are replaced by the defaults
+type Config struct {
+	SampleRate  float64
+	PayloadSize int           // bytes per packet
+	Packets     int           // packets per point
+	Threshold   float64       // the power threshold of the preamble detection
+	Timeout     time.Duration // how long the data link layers wait for each packet
+	Seed        uint64
+	Echos       []device.Echo // the multipath of the simulated channel
+}
+
+func (c *Config) init() {
+	if c.SampleRate == 0 {
+		c.SampleRate = 48000
+	}
+	if c.PayloadSize == 0 {
+		c.PayloadSize = 200
+	}
+	if c.Packets == 0 {
+		c.Packets = 10
+	}
+	if c.Threshold == 0 {
+		c.Threshold = config.Default().Modem.Preamble.Threshold
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 2 * time.Second
+	}
+}
+
+// runs the grid point on the target
+func Run(cfg Config, target string, p Params) (Result, error) {
+	cfg.init()
+	switch target {
+	case TargetModem:
+		return runModem(cfg, p), nil
+	case TargetNaive:
+		return runNaive(cfg, p), nil
+	case TargetReliable:
+		return runReliable(cfg, p), nil
+	default:
+		return Result{}, fmt.Errorf("unknown target %s, expected '%s', '%s' or '%s'", target, TargetModem, TargetNaive, TargetReliable)
+	}
+}
+
+// runs every point of the grid on every target, progress is called after each point and may be nil
+func Sweep(cfg Config, grid Grid, targets []string, progress func(Result)) ([]Result, error) {
+	var results []Result
+	for _, p := range grid.Points() {
+		for _, target := range targets {
+			result, err := Run(cfg, target, p)
+			if err != nil {
+				return results, err
+			}
+			if progress != nil {
+				progress(result)
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+func (c Config) channel(p Params) *device.Channel {
+	return &device.Channel{Noise: device.NoiseForSNR(p.SNR, p.Amplitude), Echos: c.Echos, Seed: c.Seed}
+}
+
+func (c Config) payloads() [][]byte {
+	r := rand.New(rand.NewSource(c.Seed))
+	payloads := make([][]byte, c.Packets)
+	for i := range payloads {
+		payloads[i] = make([]byte, c.PayloadSize)
+		r.Read(payloads[i])
+	}
+	return payloads
+}
+
+// the config of the layers at the grid point
+func (c Config) layerConfig(p Params) *config.Config {
+	layerConfig := config.Default()
+	layerConfig.Device.SampleRate = c.SampleRate
+	layerConfig.Modem.Carrier.Size = p.CarrierSize
+	layerConfig.Modem.FrameInterval = p.FrameInterval
+	layerConfig.Modem.BytePerFrame = p.BytePerFrame
+	layerConfig.Modem.Preamble.N = p.PreambleN
+	layerConfig.Modem.Preamble.Threshold = c.Threshold
+	layerConfig.Modem.Carrier.Amplitude = p.Amplitude
+	if p.Scheme != "" {
+		layerConfig.Modem.Passband.Scheme = p.Scheme
+	}
+	layerConfig.Modem.Carrier.Constellation = p.Constellation
+	layerConfig.Modem.Equalizer.Taps = p.Equalizer
+	layerConfig.PhysicalLayer.InputBufferSize = 100000
+	layerConfig.MACLayer.BackoffTimer.MaxBackoff = 100 * time.Millisecond
+	return layerConfig
+}
+
+// accumulates the measurements of the packets
+type counter struct {
+	bits, bitErrors   int
+	frames, lost      int
+	intactBits        int
+	latency, duration time.Duration
+	received          int
+}
+
+func (c *counter) packet(sent, received []byte, ok bool) {
+	c.bits += 8 * len(sent)
+	if ok {
+		c.bitErrors += modem.BitErrors(sent, received)
+		if bytes.Equal(sent, received) {
+			c.intactBits += 8 * len(sent)
+		}
+	} else {
+		c.bitErrors += 8 * len(sent)
+	}
+}
+
+func (c *counter) result(target string, p Params) Result {
+	result := Result{Params: p, Target: target}
+	if c.bits > 0 {
+		result.BER = float64(c.bitErrors) / float64(c.bits)
+	}
+	if c.frames > 0 {
+		result.FER = float64(c.lost) / float64(c.frames)
+	}
+	if c.duration > 0 {
+		result.Goodput = float64(c.intactBits) / c.duration.Seconds()
+	}
+	if c.received > 0 {
+		result.Latency = float64(c.latency) / float64(c.received) / float64(time.Millisecond)
+	}
+	return result
+}
+
+func samplesToDuration(samples int, sampleRate float64) time.Duration {
+	return time.Duration(float64(samples) / sampleRate * float64(time.Second))
+}
+
+// modulates the packets, passes them through the channel and demodulates them offline
+func runModem(cfg Config, p Params) Result {
+	physical := config.CreatePhysicalLayer(cfg.layerConfig(p), nil)
+	channel := cfg.channel(p)
+
+	var c counter
+	for _, payload := range cfg.payloads() {
+		signal := physical.Encoder.Modulator.Modulate(payload)
+		airtime := samplesToDuration(len(signal), cfg.SampleRate)
+		// some silence around the packet for the preamble detection
+		signal = append(append(make([]int32, device.BufferSize), signal...), make([]int32, device.BufferSize)...)
+		channel.Apply(signal)
+
+		demodulator := modem.Demodulator{
+			Preamble:                 physical.Decoder.Demodulator.Preamble,
+			CarrierSize:              p.CarrierSize,
+			DemodulatePowerThreshold: fixed.FromFloat(cfg.Threshold),
+			Passband:                 physical.Decoder.Demodulator.Passband,
+			Constellation:            physical.Decoder.Demodulator.Constellation,
+			Equalizer:                physical.Decoder.Demodulator.Equalizer,
+		}
+		packets, reports := demodulator.DemodulateAll(signal)
+
+		frames := (len(payload) + p.BytePerFrame - 1) / p.BytePerFrame
+		c.frames += frames
+		intact := 0
+		for _, report := range reports {
+			if report.CRCOK {
+				intact++
+			}
+		}
+		c.lost += max(frames-intact, 0)
+
+		var received []byte
+		for _, packet := range packets {
+			received = append(received, packet...)
+		}
+		c.packet(payload, received, len(packets) > 0)
+		c.duration += airtime
+		if len(packets) > 0 {
+			c.latency += airtime
+			c.received++
+		}
+	}
+	return c.result(TargetModem, p)
+}
+
+// a pair of data link layers on a simulated medium, the time is counted in updates of the network
+type link struct {
+	network device.Network[string]
+	ticks   atomic.Int64
+}
+
+func newLink(cfg Config, p Params) *link {
+	l := &link{}
+	l.network = device.Network[string]{
+		Config: device.NetworkConfig[string]{
+			{In: "air", Out: "air"},
+			{In: "air", Out: "air"},
+		},
+		SampleRate: cfg.SampleRate / device.BufferSize, // one update per buffer, so the timers of the layers run in real time
+		Channel:    cfg.channel(p),
+		LateUpdate: func() { l.ticks.Add(1) },
+	}
+	return l
+}
+
+func (l *link) now(cfg Config) time.Duration {
+	return samplesToDuration(int(l.ticks.Load())*device.BufferSize, cfg.SampleRate)
+}
+
+func runNaive(cfg Config, p Params) Result {
+	l := newLink(cfg, p)
+	devices := l.network.Build()
+	var nodes [2]*layers.NaiveDataLinkLayer
+	for i := range nodes {
+		layerConfig := cfg.layerConfig(p)
+		layerConfig.MACLayer.Address = i + 1
+		nodes[i], _ = config.CreateNaiveDataLinkLayer(layerConfig, devices[i])
+		nodes[i].Open()
+		defer nodes[i].Close()
+	}
+
+	var c counter
+	start := l.now(cfg)
+	for _, payload := range cfg.payloads() {
+		sent := l.now(cfg)
+		nodes[0].Send(payload)
+		c.frames++
+		select {
+		case received := <-nodes[1].ReceiveAsync():
+			c.packet(payload, received, true)
+			if !bytes.Equal(received, payload) {
+				c.lost++
+			}
+			c.latency += l.now(cfg) - sent
+			c.received++
+		case <-time.After(cfg.Timeout):
+			c.packet(payload, nil, false)
+			c.lost++
+		}
+	}
+	c.duration = l.now(cfg) - start
+	return c.result(TargetNaive, p)
+}
+
+func runReliable(cfg Config, p Params) Result {
+	l := newLink(cfg, p)
+	devices := l.network.Build()
+	var nodes [2]*layers.ReliableDataLinkLayer
+	for i := range nodes {
+		layerConfig := cfg.layerConfig(p)
+		layerConfig.MACLayer.Address = i + 1
+		nodes[i], _ = config.CreateReliableDataLinkLayer(layerConfig, devices[i])
+		nodes[i].Open()
+		defer nodes[i].Close()
+	}
+
+	var c counter
+	start := l.now(cfg)
+	for _, payload := range cfg.payloads() {
+		sent := l.now(cfg)
+		c.frames++
+		if err := nodes[0].Send(2, payload); err != nil {
+			c.packet(payload, nil, false)
+			c.lost++
+			continue
+		}
+		received, err := nodes[1].ReceiveWithTimeout(cfg.Timeout)
+		if err != nil {
+			c.packet(payload, nil, false)
+			c.lost++
+			continue
+		}
+		c.packet(payload, received, true)
+		if !bytes.Equal(received, payload) {
+			c.lost++
+		}
+		c.latency += l.now(cfg) - sent
+		c.received++
+	}
+	c.duration = l.now(cfg) - start
+	return c.result(TargetReliable, p)
+}
@@ -0,0 +1,186 @@
+package bench
+
+import (
+	"Aethernet/pkg/device"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGridPoints(t *testing.T) {
+	grid := Grid{
+		CarrierSize:   []int{2, 3},
+		FrameInterval: []int{256},
+		BytePerFrame:  []int{50, 100},
+		PreambleN:     []int{4},
+		Amplitude:     []float64{0.5},
+		SNR:           []float64{0, 10, 20},
+	}
+	points := grid.Points()
+	if len(points) != 12 {
+		t.Fatalf("Got %d points, expected 12", len(points))
+	}
+	first := Params{CarrierSize: 2, FrameInterval: 256, BytePerFrame: 50, PreambleN: 4, Amplitude: 0.5, SNR: 0}
+	last := Params{CarrierSize: 3, FrameInterval: 256, BytePerFrame: 100, PreambleN: 4, Amplitude: 0.5, SNR: 20}
+	if points[0] != first || points[11] != last {
+		t.Errorf("Unexpected order %v ... %v", points[0], points[11])
+	}
+
+	if values, err := ParseList[int]("2, 3,4"); err != nil || len(values) != 3 || values[2] != 4 {
+		t.Errorf("ParseList[int] = %v, %v", values, err)
+	}
+	if _, err := ParseList[int]("2.5"); err == nil {
+		t.Errorf("ParseList[int] accepted 2.5")
+	}
+	if values, err := ParseList[float64]("-5,2.5"); err != nil || values[0] != -5 || values[1] != 2.5 {
+		t.Errorf("ParseList[float64] = %v, %v", values, err)
+	}
+}
+
+func TestRunModem(t *testing.T) {
+	cfg := Config{Packets: 3, Seed: 1}
+	p := Params{CarrierSize: 3, FrameInterval: 256, BytePerFrame: 100, PreambleN: 4, Amplitude: 0.5}
+
+	p.SNR = 30
+	clean, err := Run(cfg, TargetModem, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(clean)
+	if clean.BER != 0 || clean.FER != 0 || clean.Goodput <= 0 || clean.Latency <= 0 {
+		t.Errorf("Expected no error at 30 dB: %v", clean)
+	}
+
+	p.SNR = -10
+	noisy, _ := Run(cfg, TargetModem, p)
+	t.Log(noisy)
+	if noisy.BER <= clean.BER || noisy.FER <= clean.FER {
+		t.Errorf("Expected errors at -10 dB: %v", noisy)
+	}
+
+	if _, err := Run(cfg, "unknown", p); err == nil {
+		t.Errorf("Expected an error for an unknown target")
+	}
+}
+
+func TestRunDataLink(t *testing.T) {
+	if testing.Short() {
+		t.Skip("The data link layers run in real time")
+	}
+	cfg := Config{Packets: 2, PayloadSize: 100, Timeout: time.Second, Seed: 1}
+	p := Params{CarrierSize: 2, FrameInterval: 256, BytePerFrame: 125, PreambleN: 4, Amplitude: 0.5, SNR: 30}
+	for _, target := range []string{TargetNaive, TargetReliable} {
+		result, err := Run(cfg, target, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(result)
+		if result.BER != 0 || result.FER != 0 || result.Goodput <= 0 || result.Latency <= 0 {
+			t.Errorf("Expected no error at 30 dB: %v", result)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	results := []Result{
+		{Params: Params{2, 256, 125, 4, 0.75, 10, "", "pam4", 0}, Target: TargetModem, BER: 0.001, FER: 0.5, Goodput: 9000, Latency: 12.5},
+		{Params: Params{3, 256, 125, 4, 0.75, 20, "qpsk", "qam16", 32}, Target: TargetNaive},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "csv", results); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || len(records[1]) != len(csvHeader) || records[1][0] != "modem" || records[1][10] != "0.001" {
+		t.Errorf("Unexpected CSV %v", records)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, "json", results); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Result
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0] != results[0] {
+		t.Errorf("Unexpected JSON %s", buf.String())
+	}
+
+	if err := Write(&buf, "xml", results); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+// each constellation needs more SNR than the one with fewer bits per symbol at the same peak amplitude
+func TestConstellationBreakdown(t *testing.T) {
+	cfg := Config{Packets: 3, PayloadSize: 100, Seed: 1}
+	snrs := []float64{0, 3, 6, 9, 12, 15, 18, 21, 24, 27, 30}
+
+	// the lowest SNR from which every frame is received
+	breakdown := func(p Params) float64 {
+		lowest := math.Inf(1)
+		for i := len(snrs) - 1; i >= 0; i-- {
+			p.SNR = snrs[i]
+			result, err := Run(cfg, TargetModem, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.FER > 0 {
+				break
+			}
+			lowest = snrs[i]
+		}
+		return lowest
+	}
+
+	baseband := Params{CarrierSize: 3, FrameInterval: 256, BytePerFrame: 100, PreambleN: 4, Amplitude: 0.5}
+	passband := Params{CarrierSize: 12, FrameInterval: 256, BytePerFrame: 100, PreambleN: 4, Amplitude: 0.5, Scheme: "qpsk"}
+	for _, ladder := range [][]Params{
+		{baseband, withConstellation(baseband, "pam4"), withConstellation(baseband, "pam8")},
+		{passband, withConstellation(passband, "qam16")},
+	} {
+		previous := math.Inf(-1)
+		for _, p := range ladder {
+			snr := breakdown(p)
+			t.Logf("%s %s: error free from %v dB", p.Scheme, p.Constellation, snr)
+			if math.IsInf(snr, 1) || snr <= previous {
+				t.Errorf("%s %s breaks down at %v dB, expected above %v dB", p.Scheme, p.Constellation, snr, previous)
+			}
+			previous = snr
+		}
+	}
+}
+
+func withConstellation(p Params, constellation string) Params {
+	p.Constellation = constellation
+	return p
+}
+
+// the simulated multipath smears the symbols of one sample, which only the equalized modem receives
+func TestEqualizerMultipath(t *testing.T) {
+	cfg := Config{Packets: 3, Seed: 1, Echos: []device.Echo{{Delay: 1, Gain: 0.6}, {Delay: 3, Gain: -0.5}, {Delay: 6, Gain: 0.3}}}
+	p := Params{CarrierSize: 1, FrameInterval: 256, BytePerFrame: 100, PreambleN: 4, Amplitude: 0.25, SNR: 30}
+
+	plain, err := Run(cfg, TargetModem, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Equalizer = 32
+	equalized, err := Run(cfg, TargetModem, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(plain)
+	t.Log(equalized)
+	if plain.FER == 0 || equalized.FER != 0 {
+		t.Errorf("Expected the equalizer to remove the frame loss")
+	}
+}
@@ -0,0 +1,85 @@
+package bench
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Grid is the list of values of every parameter, the sweep runs over their cartesian product
+type Grid struct {
+	CarrierSize   []int
+	FrameInterval []int
+	BytePerFrame  []int
+	PreambleN     []int
+	Amplitude     []float64
+	SNR           []float64
+	Scheme        []string // empty for the baseband only
+	Constellation []string // empty for one bit per symbol only
+	Equalizer     []int    // the taps, empty without the equalizer only
+}
+
+// the values of an optional parameter, the zero value alone if none
+func orDefault[T any](values []T) []T {
+	if len(values) == 0 {
+		return make([]T, 1)
+	}
+	return values
+}
+
+func (g Grid) Points() []Params {
+	var points []Params
+	for _, carrierSize := range g.CarrierSize {
+		for _, frameInterval := range g.FrameInterval {
+			for _, bytePerFrame := range g.BytePerFrame {
+				for _, preambleN := range g.PreambleN {
+					for _, amplitude := range g.Amplitude {
+						for _, snr := range g.SNR {
+							for _, scheme := range orDefault(g.Scheme) {
+								for _, constellation := range orDefault(g.Constellation) {
+									for _, equalizer := range orDefault(g.Equalizer) {
+										points = append(points, Params{
+											CarrierSize:   carrierSize,
+											FrameInterval: frameInterval,
+											BytePerFrame:  bytePerFrame,
+											PreambleN:     preambleN,
+											Amplitude:     amplitude,
+											SNR:           snr,
+											Scheme:        scheme,
+											Constellation: constellation,
+											Equalizer:     equalizer,
+										})
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return points
+}
+
+// parses a comma separated list like "2,3,4"
+func ParseList[T int | float64](s string) ([]T, error) {
+	var values []T
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q in %q", field, s)
+		}
+		if float64(T(value)) != value {
+			return nil, fmt.Errorf("%q is not an integer", field)
+		}
+		values = append(values, T(value))
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("empty list %q", s)
+	}
+	return values, nil
+}
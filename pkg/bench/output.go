@@ -0,0 +1,78 @@
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var csvHeader = []string{
+	"target", "carrier_size", "frame_interval", "byte_per_frame", "preamble_n", "amplitude", "snr", "scheme", "constellation", "equalizer_taps",
+	"ber", "fer", "goodput_bps", "latency_ms",
+}
+
+func WriteCSV(w io.Writer, results []Result) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	format := func(f float64) string { return strconv.FormatFloat(f, 'g', 6, 64) }
+	for _, r := range results {
+		record := []string{
+			r.Target,
+			strconv.Itoa(r.CarrierSize),
+			strconv.Itoa(r.FrameInterval),
+			strconv.Itoa(r.BytePerFrame),
+			strconv.Itoa(r.PreambleN),
+			format(r.Amplitude),
+			format(r.SNR),
+			r.Scheme,
+			r.Constellation,
+			strconv.Itoa(r.Equalizer),
+			format(r.BER),
+			format(r.FER),
+			format(r.Goodput),
+			format(r.Latency),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func WriteJSON(w io.Writer, results []Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+// writes the results in the format, "csv" or "json"
+func Write(w io.Writer, format string, results []Result) error {
+	switch format {
+	case "csv":
+		return WriteCSV(w, results)
+	case "json":
+		return WriteJSON(w, results)
+	default:
+		return fmt.Errorf("unknown format %s, expected 'csv' or 'json'", format)
+	}
+}
+
+func (r Result) String() string {
+	var symbols string
+	if r.Scheme != "" {
+		symbols += ", " + r.Scheme
+	}
+	if r.Constellation != "" {
+		symbols += ", " + r.Constellation
+	}
+	if r.Equalizer != 0 {
+		symbols += fmt.Sprintf(", equalizer %d", r.Equalizer)
+	}
+	return fmt.Sprintf("%-8s carrier %d, interval %d, %d B/frame, preamble %d, amplitude %.2f%s, SNR %5.1f dB: BER %.2e, FER %.2f, goodput %.0f bps, latency %.1f ms",
+		r.Target, r.CarrierSize, r.FrameInterval, r.BytePerFrame, r.PreambleN, r.Amplitude, symbols, r.SNR, r.BER, r.FER, r.Goodput, r.Latency)
+}
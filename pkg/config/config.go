@@ -0,0 +1,297 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type DeviceConfig struct {
+	Backend    string  `yaml:"backend"` // "asio" or "loopback"
+	DeviceName string  `yaml:"device_name"`
+	SampleRate float64 `yaml:"sample_rate"`
+	InChannel  int     `yaml:"in_channel"`
+	OutChannel int     `yaml:"out_channel"`
+}
+
+type ModemConfig struct {
+	BytePerFrame  int `yaml:"byte_per_frame"`
+	FrameInterval int `yaml:"frame_interval"`
+
+	Preamble struct {
+		Family    string  `yaml:"family"` // "digital_chirp", "chirp", "barker" or "zadoff_chu"
+		Amplitude float64 `yaml:"amplitude"`
+		N         int     `yaml:"n"`         // the digital chirp order
+		Length    int     `yaml:"length"`    // the Barker code or Zadoff-Chu sequence length, the number of samples of the chirp
+		ChipSize  int     `yaml:"chip_size"` // samples per element of the Barker code or the Zadoff-Chu sequence
+		Root      int     `yaml:"root"`      // the Zadoff-Chu root
+		MinFreq   float64 `yaml:"min_freq"`  // the chirp band
+		MaxFreq   float64 `yaml:"max_freq"`
+
+		Detector    string  `yaml:"detector"`    // "power" compares the correlation with threshold, "ncc" the normalised cross-correlation with correlation
+		Threshold   float64 `yaml:"threshold"`   // the power threshold, depends on the gain of the recording
+		Correlation float64 `yaml:"correlation"` // the normalised cross-correlation threshold in (0, 1], independent of the gain
+	} `yaml:"preamble"`
+
+	Carrier struct {
+		Amplitude float64 `yaml:"amplitude"`
+		Size      int     `yaml:"size"` // samples per bit, or per symbol in passband
+		// the symbols of the data, "" for one bit per symbol, "bpsk", "pam4" or "pam8", and "qpsk" or "qam16" in psk passband
+		Constellation string `yaml:"constellation"`
+	} `yaml:"carrier"`
+
+	Passband struct {
+		Scheme    string  `yaml:"scheme"` // "none" for the baseband levels, "bpsk", "qpsk" or "fsk"
+		Freq      float64 `yaml:"freq"`   // the carrier frequency in Hz
+		Shift     float64 `yaml:"shift"`  // the distance to the tone of a 1 in Hz, fsk only
+		PilotSize int     `yaml:"pilot_size"`
+	} `yaml:"passband"`
+
+	// sends a training sequence after the preamble to equalize the multipath of the room, 0 taps disables it
+	Equalizer struct {
+		Taps     int `yaml:"taps"`
+		Delay    int `yaml:"delay"` // in samples, 0 for the middle of the filter
+		Training int `yaml:"training"`
+	} `yaml:"equalizer"`
+}
+
+type PhysicalLayerConfig struct {
+	InputBufferSize   int `yaml:"input_buffer_size"`
+	OutputBufferSize  int `yaml:"output_buffer_size"`
+	ReceiveBufferSize int `yaml:"receive_buffer_size"`
+
+	PowerMonitor struct {
+		Threshold float64 `yaml:"threshold"`
+		Window    int     `yaml:"window"`
+	} `yaml:"power_monitor"`
+
+	// conditions the received samples before the decoder and the power monitor, every stage is disabled by 0
+	FrontEnd struct {
+		DCBlock float64 `yaml:"dc_block"` // the pole of the DC blocker in [0, 1), e.g. 0.995
+		AGC     struct {
+			Target  float64 `yaml:"target"` // the RMS level relative to the full scale
+			Window  int     `yaml:"window"`
+			MaxGain float64 `yaml:"max_gain"`
+		} `yaml:"agc"`
+		BandPass struct {
+			Low  float64 `yaml:"low"` // in Hz
+			High float64 `yaml:"high"`
+		} `yaml:"band_pass"`
+	} `yaml:"front_end"`
+
+	// removes the own output from the input and detects the collisions while sending, disabled without taps
+	Echo struct {
+		Delay              int     `yaml:"delay"` // in samples, about the round trip latency of the device
+		Taps               int     `yaml:"taps"`
+		StepSize           float64 `yaml:"step_size"`           // in (0, 2), 0 means 0.5
+		CollisionThreshold float64 `yaml:"collision_threshold"` // the residual level relative to the full scale, 0 to not detect the collisions
+		Window             int     `yaml:"window"`
+	} `yaml:"echo"`
+
+	// the slotted channel access in samples, 0 slot sends as soon as the power monitor is not busy
+	CSMA struct {
+		DIFS      int `yaml:"difs"`
+		SIFS      int `yaml:"sifs"`
+		Slot      int `yaml:"slot"`
+		MinWindow int `yaml:"min_window"` // in slots
+		MaxWindow int `yaml:"max_window"`
+	} `yaml:"csma"`
+
+	// the classes of the frames queued for the encoder, the control frames and the ACKs, the interactive ones and the bulk ones
+	Queues struct {
+		Scheduling  string           `yaml:"scheduling"` // "strict" by priority or "weighted" in turns of the weights
+		Control     QueueClassConfig `yaml:"control"`
+		Interactive QueueClassConfig `yaml:"interactive"`
+		Bulk        QueueClassConfig `yaml:"bulk"`
+	} `yaml:"queues"`
+}
+
+type QueueClassConfig struct {
+	Limit   int           `yaml:"limit"`   // the frames queued at most, 0 for no limit
+	Policy  string        `yaml:"policy"`  // "block" the sender until there is room or "drop" the frame when the class is full
+	Timeout time.Duration `yaml:"timeout"` // the longest block, 0 to wait as long as it takes
+	Weight  int           `yaml:"weight"`  // the frames sent in a row with the weighted scheduling
+}
+
+type MACLayerConfig struct {
+	Address          int           `yaml:"address"`
+	BytePerFrame     int           `yaml:"byte_per_frame"` // 0 to fit a packet in one modem frame
+	AckTimeout       time.Duration `yaml:"ack_timeout"`
+	AckDelay         time.Duration `yaml:"ack_delay"`        // how long an ACK waits for a data frame to ride on, 0 to send it at once
+	WindowProbe      time.Duration `yaml:"window_probe"`     // how long a sender paused by a full receiver waits before probing it, 0 means the ack_timeout
+	MaxMessageSize   int           `yaml:"max_message_size"` // the largest message sent or received, 0 means the default of the layer
+	MaxRetryAttempts int           `yaml:"max_retry_attempts"`
+	BackoffTimer     struct {
+		MinBackoff time.Duration `yaml:"min_backoff"`
+		MaxBackoff time.Duration `yaml:"max_backoff"`
+		// with a slot, the backoff is drawn from a window of slots doubling on every retry instead of between the min and the max
+		Slot      time.Duration `yaml:"slot"`
+		MinWindow int           `yaml:"min_window"`
+		MaxWindow int           `yaml:"max_window"`
+	} `yaml:"backoff_timer"`
+	ReceiveBufferSize int    `yaml:"receive_buffer_size"`
+	Compression       string `yaml:"compression"`
+	RTSThreshold      int    `yaml:"rts_threshold"` // the payload size from which the frames are sent with RTS/CTS, 0 to disable
+	Groups            []int  `yaml:"groups"`        // the multicast groups joined besides the broadcast to every node
+	Header            string `yaml:"header"`        // the header of the reliable data link layer, "compact", "v1" or "v1_wide" for 16 bit addresses
+	NaiveHeader       string `yaml:"naive_header"`  // the header of the naive data link layer, "legacy" or "addressed" for the destinations and the groups
+
+	// the token passing among the addresses of the ring instead of the contention, disabled without a ring
+	Token struct {
+		Ring        []int         `yaml:"ring"`
+		Hold        time.Duration `yaml:"hold"`
+		PassTimeout time.Duration `yaml:"pass_timeout"`
+		LostTimeout time.Duration `yaml:"lost_timeout"`
+	} `yaml:"token"`
+
+	// the hellos and the table of the nodes heard, disabled without an interval
+	Neighbors struct {
+		Interval time.Duration `yaml:"interval"`
+		Timeout  time.Duration `yaml:"timeout"` // a node not heard for the timeout is down
+		Router   bool          `yaml:"router"`  // advertises that the node forwards IP packets
+	} `yaml:"neighbors"`
+}
+
+type SecurityConfig struct {
+	Enabled bool           `yaml:"enabled"`
+	Keys    map[int]string `yaml:"keys"` // hex encoded 256-bit keys indexed by the address of the peer, 255 for the group key
+}
+
+type IfaceConfig struct {
+	Type   string `yaml:"type"` // "tun", "tap" or "pcap"
+	IP     string `yaml:"ip"`
+	Name   string `yaml:"name"`
+	Filter string `yaml:"filter"`
+}
+
+type GatewayConfig struct {
+	AllowedDNSQueries []string `yaml:"allowed_dns_queries"` // substrings of the names that may be resolved
+
+	DHCP struct {
+		Role      string        `yaml:"role"` // "server", "client" or empty to use the static addresses
+		Pool      string        `yaml:"pool"`
+		LeaseTime time.Duration `yaml:"lease_time"`
+		Reserved  []int         `yaml:"reserved"` // the data link addresses of the statically configured nodes, never handed out
+	} `yaml:"dhcp"`
+
+	DNS struct {
+		Upstream string            `yaml:"upstream"` // empty to disable the forwarder
+		Hosts    map[string]string `yaml:"hosts"`
+	} `yaml:"dns"`
+}
+
+// Config is the configuration of a node, it is loaded from a YAML file like
+//
+//	device:
+//	  device_name: ASIO4ALL v2
+//	modem:
+//	  carrier:
+//	    size: 2
+//	mac_layer:
+//	  address: 1
+//
+// The missing values are taken from Default.
+type Config struct {
+	Device        DeviceConfig        `yaml:"device"`
+	Modem         ModemConfig         `yaml:"modem"`
+	PhysicalLayer PhysicalLayerConfig `yaml:"physical_layer"`
+	MACLayer      MACLayerConfig      `yaml:"mac_layer"`
+	Security      SecurityConfig      `yaml:"security"`
+	Iface         IfaceConfig         `yaml:"iface"`
+	Gateway       GatewayConfig       `yaml:"gateway"`
+}
+
+func Default() *Config {
+	var c Config
+
+	c.Device.Backend = "asio"
+	c.Device.DeviceName = "ASIO4ALL v2"
+	c.Device.SampleRate = 48000
+
+	c.Modem.BytePerFrame = 125
+	c.Modem.FrameInterval = 256
+	c.Modem.Preamble.Family = "digital_chirp"
+	c.Modem.Preamble.Amplitude = 1
+	c.Modem.Preamble.N = 4
+	c.Modem.Preamble.Length = 13
+	c.Modem.Preamble.ChipSize = 4
+	c.Modem.Preamble.Root = 1
+	c.Modem.Preamble.MinFreq = 1000
+	c.Modem.Preamble.MaxFreq = 8000
+	c.Modem.Preamble.Detector = "power"
+	c.Modem.Preamble.Threshold = 20
+	c.Modem.Preamble.Correlation = 0.7
+	c.Modem.Carrier.Amplitude = 0.75
+	c.Modem.Carrier.Size = 2
+	c.Modem.Passband.Scheme = "none"
+	c.Modem.Passband.Freq = 4000
+	c.Modem.Passband.Shift = 4000
+	c.Modem.Passband.PilotSize = 64
+	c.Modem.Equalizer.Training = 256
+
+	c.PhysicalLayer.InputBufferSize = 10000
+	c.PhysicalLayer.OutputBufferSize = 100
+	c.PhysicalLayer.ReceiveBufferSize = 10
+	c.PhysicalLayer.PowerMonitor.Threshold = 0.4
+	c.PhysicalLayer.PowerMonitor.Window = 10
+	c.PhysicalLayer.CSMA.DIFS = 1024
+	c.PhysicalLayer.CSMA.SIFS = 256
+	c.PhysicalLayer.CSMA.MinWindow = 8
+	c.PhysicalLayer.CSMA.MaxWindow = 256
+	c.PhysicalLayer.Queues.Scheduling = "strict"
+	c.PhysicalLayer.Queues.Control.Weight = 4
+	c.PhysicalLayer.Queues.Interactive.Weight = 2
+	c.PhysicalLayer.Queues.Bulk.Weight = 1
+
+	c.MACLayer.AckTimeout = 120 * time.Millisecond
+	c.MACLayer.MaxRetryAttempts = 3
+	c.MACLayer.BackoffTimer.MaxBackoff = 1000 * time.Millisecond
+	c.MACLayer.BackoffTimer.MinWindow = 2
+	c.MACLayer.BackoffTimer.MaxWindow = 64
+	c.MACLayer.ReceiveBufferSize = 10
+	c.MACLayer.Compression = "none"
+	c.MACLayer.Header = "compact"
+	c.MACLayer.NaiveHeader = "legacy"
+	c.MACLayer.Token.Hold = 500 * time.Millisecond
+	c.MACLayer.Token.PassTimeout = 300 * time.Millisecond
+	c.MACLayer.Token.LostTimeout = 2 * time.Second
+	c.MACLayer.Neighbors.Timeout = 3 * time.Second
+
+	c.Iface.Type = "tun"
+
+	c.Gateway.DHCP.LeaseTime = 10 * time.Minute
+
+	return &c
+}
+
+// loads the defaults, then the file if filename is not empty, then the environment variables and the overrides in the form of "section.key=value",
+// and validates the result
+func LoadConfig(filename string, overrides ...string) (*Config, error) {
+	config := Default()
+
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %v", filename, err)
+		}
+	}
+
+	if err := config.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	for _, override := range overrides {
+		if err := config.Override(override); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,422 @@
+package config
+
+import (
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/layers"
+	"Aethernet/pkg/modem"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+device:
+  backend: loopback
+modem:
+  carrier:
+    size: 3
+mac_layer:
+  address: 2
+  ack_timeout: 500ms
+security:
+  enabled: true
+  keys:
+    255: 000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f
+gateway:
+  allowed_dns_queries: [example]
+  dns:
+    hosts:
+      example.com: 1.2.3.4
+`
+
+func writeConfig(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadConfig(t *testing.T) {
+
+	t.Setenv(EnvPrefix+"MODEM_PREAMBLE_N", "8")
+	t.Setenv(EnvPrefix+"MAC_LAYER_ADDRESS", "3")
+
+	config, err := LoadConfig(writeConfig(t, testConfig), "mac_layer.address=4", "security.keys.1=1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	defaults := Default()
+	if config.Modem.Carrier.Size != 3 {
+		t.Errorf("Carrier size %d, expected 3 from the file", config.Modem.Carrier.Size)
+	}
+	if config.Modem.Carrier.Amplitude != defaults.Modem.Carrier.Amplitude {
+		t.Errorf("Carrier amplitude %v, expected the default %v", config.Modem.Carrier.Amplitude, defaults.Modem.Carrier.Amplitude)
+	}
+	if config.MACLayer.AckTimeout != 500*time.Millisecond {
+		t.Errorf("ACK timeout %v, expected 500ms", config.MACLayer.AckTimeout)
+	}
+	if config.Modem.Preamble.N != 8 {
+		t.Errorf("Preamble N %d, expected 8 from the environment", config.Modem.Preamble.N)
+	}
+	if config.MACLayer.Address != 4 {
+		t.Errorf("Address %d, expected the override 4", config.MACLayer.Address)
+	}
+	if len(config.Security.Keys) != 2 {
+		t.Errorf("Got %d keys, expected 2", len(config.Security.Keys))
+	}
+	if len(config.Gateway.AllowedDNSQueries) != 1 || config.Gateway.DNS.Hosts["example.com"] != "1.2.3.4" {
+		t.Errorf("Gateway section not loaded: %+v", config.Gateway)
+	}
+
+	for _, override := range []string{"mac_layer.unknown=1", "mac_layer=1", "modem.carrier.size=abc", "mac_layer.address"} {
+		if err := Default().Override(override); err == nil {
+			t.Errorf("Override %s should fail", override)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("Defaults are invalid: %v", err)
+	}
+
+	_, err := LoadConfig(writeConfig(t, `
+device:
+  backend: speaker
+modem:
+  byte_per_frame: 200
+mac_layer:
+  compression: zip
+security:
+  enabled: true
+  keys:
+    1: 0011
+`))
+	if err == nil {
+		t.Fatal("Invalid config should be rejected")
+	}
+	for _, key := range []string{"device.backend", "modem.byte_per_frame", "mac_layer.compression", "security.keys"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Error does not mention %s: %v", key, err)
+		}
+	}
+
+	_, err = LoadConfig("", "modem.preamble.family=barker", "modem.preamble.length=6", "modem.preamble.detector=ncc", "modem.preamble.correlation=1.5",
+		"physical_layer.front_end.dc_block=1", "physical_layer.front_end.band_pass.low=5000", "physical_layer.front_end.band_pass.high=1000",
+		"modem.passband.scheme=fsk", "modem.passband.freq=20000", "mac_layer.rts_threshold=64", "mac_layer.groups=[1, 256]", "physical_layer.echo.step_size=2")
+	for _, key := range []string{"modem.preamble.length", "modem.preamble.correlation", "physical_layer.front_end.dc_block", "physical_layer.front_end.band_pass", "modem.passband.freq",
+		"mac_layer.rts_threshold", "mac_layer.groups", "physical_layer.echo.step_size"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("Error does not mention %s: %v", key, err)
+		}
+	}
+}
+
+func TestCreatePreamble(t *testing.T) {
+	for family, length := range map[string]int{"digital_chirp": 40, "chirp": 12, "barker": 13 * 4, "zadoff_chu": 13 * 4} {
+		c, err := LoadConfig("", "modem.preamble.family="+family, "modem.preamble.detector=ncc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		preamble := CreatePreamble(c)
+		if len(preamble) != length {
+			t.Errorf("%s preamble has %d samples, expected %d", family, len(preamble), length)
+		}
+		if detector := CreateDetector(c, preamble); detector == nil || detector.Threshold != 0.7 {
+			t.Errorf("%s: unexpected detector %v", family, detector)
+		}
+	}
+	if CreateDetector(Default(), nil) != nil {
+		t.Errorf("The power detector needs no Detector")
+	}
+}
+
+func TestCreatePassband(t *testing.T) {
+	if CreatePassband(Default()) != nil {
+		t.Errorf("The default is the baseband")
+	}
+	c, err := LoadConfig("", "modem.passband.scheme=qpsk", "modem.passband.freq=6000", "modem.carrier.size=8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	physical := CreatePhysicalLayer(c, nil)
+	passband := physical.Encoder.Modulator.Passband
+	if passband == nil || passband.Scheme != modem.QPSK || passband.Freq != 6000 || passband.SampleRate != 48000 {
+		t.Fatalf("Unexpected passband %+v", passband)
+	}
+	if physical.Decoder.Demodulator.Passband != passband {
+		t.Errorf("The demodulator does not use the passband of the modulator")
+	}
+}
+
+func TestCreateConstellation(t *testing.T) {
+	if CreateConstellation(Default()) != nil {
+		t.Errorf("The default is one bit per symbol")
+	}
+	if _, err := LoadConfig("", "modem.carrier.constellation=qam16"); err == nil || !strings.Contains(err.Error(), "modem.carrier.constellation") {
+		t.Errorf("qam16 needs the passband: %v", err)
+	}
+	c, err := LoadConfig("", "modem.carrier.constellation=pam4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	physical := CreatePhysicalLayer(c, nil)
+	constellation := physical.Encoder.Modulator.Constellation
+	if constellation == nil || constellation.BitPerSymbol() != 2 || physical.Decoder.Demodulator.Constellation != constellation {
+		t.Errorf("Unexpected constellation %+v", constellation)
+	}
+}
+
+func TestCreateEqualizer(t *testing.T) {
+	if CreateEqualizer(Default()) != nil {
+		t.Errorf("The default has no equalizer")
+	}
+	if _, err := LoadConfig("", "modem.equalizer.taps=200"); err == nil || !strings.Contains(err.Error(), "modem.equalizer.training") {
+		t.Errorf("The training is shorter than the taps: %v", err)
+	}
+	c, err := LoadConfig("", "modem.equalizer.taps=16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	physical := CreatePhysicalLayer(c, nil)
+	equalizer := physical.Encoder.Modulator.Equalizer
+	if equalizer == nil || equalizer.Taps != 16 || equalizer.Training != 256 || physical.Decoder.Demodulator.Equalizer != equalizer {
+		t.Errorf("Unexpected equalizer %+v", equalizer)
+	}
+}
+
+func TestCreateTokenPassing(t *testing.T) {
+	if CreateTokenPassing(Default()) != nil {
+		t.Errorf("The default contends for the medium")
+	}
+	if _, err := LoadConfig("", "mac_layer.address=3", "mac_layer.token.ring=[0, 1, 1]"); err == nil || !strings.Contains(err.Error(), "mac_layer.token.ring") {
+		t.Errorf("The ring misses the address and repeats one: %v", err)
+	}
+	c, err := LoadConfig("", "mac_layer.address=1", "mac_layer.token.ring=[0, 1, 2]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := CreateReliableDataLinkLayer(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := layer.Token; token == nil || len(token.Ring) != 3 || token.Ring[2] != 2 || token.Hold != 500*time.Millisecond {
+		t.Errorf("Unexpected token passing %+v", token)
+	}
+}
+
+func TestCreateNeighborDiscovery(t *testing.T) {
+	if CreateNeighborDiscovery(Default()) != nil {
+		t.Errorf("The default sends no hellos")
+	}
+	if _, err := LoadConfig("", "mac_layer.neighbors.interval=5s"); err == nil || !strings.Contains(err.Error(), "mac_layer.neighbors") {
+		t.Errorf("The interval is longer than the timeout: %v", err)
+	}
+	c, err := LoadConfig("", "mac_layer.neighbors.interval=1s", "mac_layer.neighbors.router=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := CreateReliableDataLinkLayer(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if neighbors := layer.Neighbors; neighbors == nil || neighbors.Interval != time.Second || neighbors.Timeout != 3*time.Second ||
+		!neighbors.Capabilities.Has(layers.CapabilityRouter) {
+		t.Errorf("Unexpected neighbor discovery %+v", neighbors)
+	}
+}
+
+func TestCreateACKDelay(t *testing.T) {
+	if _, err := LoadConfig("", "mac_layer.ack_delay=50ms"); err == nil || !strings.Contains(err.Error(), "mac_layer.ack_delay") {
+		t.Errorf("The compact header cannot piggyback: %v", err)
+	}
+	if _, err := LoadConfig("", "mac_layer.header=v1", "mac_layer.ack_delay=1s"); err == nil || !strings.Contains(err.Error(), "mac_layer.ack_delay") {
+		t.Errorf("The delay is longer than the timeout: %v", err)
+	}
+	c, err := LoadConfig("", "mac_layer.header=v1", "mac_layer.ack_delay=50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := CreateReliableDataLinkLayer(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer.ACKDelay != 50*time.Millisecond {
+		t.Errorf("ACK delay %v, expected 50ms", layer.ACKDelay)
+	}
+}
+
+func TestCreateReliableDataLinkHeader(t *testing.T) {
+	if _, err := LoadConfig("", "mac_layer.header=v2"); err == nil || !strings.Contains(err.Error(), "mac_layer.header") {
+		t.Errorf("Unknown header is accepted: %v", err)
+	}
+	c, err := LoadConfig("", "mac_layer.header=v1_wide", "mac_layer.address=4660")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := CreateReliableDataLinkLayer(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer.Header != layers.ReliableDataLinkHeaderV1Wide || layer.Address != 0x1234 {
+		t.Errorf("Unexpected header %v and address %x", layer.Header, layer.Address)
+	}
+	if _, err := CreateNaiveDataLinkLayer(c, nil); err == nil {
+		t.Errorf("Address %x does not fit in the naive header", c.MACLayer.Address)
+	}
+	c.MACLayer.Header = "compact"
+	c.MACLayer.Address = 7
+	if _, err := CreateReliableDataLinkLayer(c, nil); err == nil {
+		t.Errorf("The broadcast address of the compact header is taken")
+	}
+	for header, address := range map[string]string{"compact": "7", "v1": "255"} {
+		if _, err := LoadConfig("", "mac_layer.header="+header, "mac_layer.address="+address); err == nil || !strings.Contains(err.Error(), "mac_layer.address") {
+			t.Errorf("Address %s is accepted with the %s header: %v", address, header, err)
+		}
+	}
+}
+
+// the DHCP server hands out the addresses which fit the header
+func TestCreateDHCPServerMaxAddress(t *testing.T) {
+	for header, expected := range map[string]byte{"compact": 0x6, "v1": 0xfe, "v1_wide": 0xfe} {
+		c, err := LoadConfig("", "mac_layer.header="+header, "gateway.dhcp.pool=172.18.1.0/24")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, err := CreateDHCPServer(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server.MaxAddress != expected {
+			t.Errorf("The %s header hands out addresses up to %x, expected %x", header, server.MaxAddress, expected)
+		}
+	}
+
+	if _, err := LoadConfig("", "gateway.dhcp.role=server", "gateway.dhcp.pool=172.18.1.0/24", "gateway.dhcp.reserved=[255]"); err == nil || !strings.Contains(err.Error(), "gateway.dhcp.reserved") {
+		t.Errorf("The unassigned address is reserved: %v", err)
+	}
+	c, err := LoadConfig("", "gateway.dhcp.pool=172.18.1.0/24", "gateway.dhcp.reserved=[2,3]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := CreateDHCPServer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(server.Reserved, []byte{2, 3}) {
+		t.Errorf("The static addresses %v are not reserved", server.Reserved)
+	}
+}
+
+func TestCreateNaiveDataLinkLayer(t *testing.T) {
+
+	network := device.Network[string]{
+		Config: device.NetworkConfig[string]{
+			{In: "w", Out: "w"},
+			{In: "w", Out: "w"},
+		},
+		SampleRate: 48000,
+	}
+	devices := network.Build()
+
+	if _, err := LoadConfig("", "mac_layer.naive_header=v1"); err == nil || !strings.Contains(err.Error(), "mac_layer.naive_header") {
+		t.Errorf("Unknown naive header is accepted: %v", err)
+	}
+
+	var nodes [2]*layers.NaiveDataLinkLayer
+	for i := range nodes {
+		config, err := LoadConfig(writeConfig(t, testConfig), "modem.frame_interval=256", "mac_layer.groups=[251]", "mac_layer.naive_header=addressed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.MACLayer.Address = i + 1
+		nodes[i], err = CreateNaiveDataLinkLayer(config, devices[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !nodes[i].IsMember(251) || nodes[i].Header != layers.NaiveDataLinkHeaderAddressed {
+			t.Errorf("Node %d did not take the groups and the header of the config", i)
+		}
+		nodes[i].Open()
+		defer nodes[i].Close()
+	}
+
+	message := []byte("Hello, Aethernet!")
+	nodes[0].Send(message)
+	select {
+	case received := <-nodes[1].ReceiveAsync():
+		if !bytes.Equal(received, message) {
+			t.Errorf("Received %q, expected %q", received, message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the message")
+	}
+}
+
+func TestCreateQueueClasses(t *testing.T) {
+	if _, err := LoadConfig("", "physical_layer.queues.bulk.policy=discard"); err == nil || !strings.Contains(err.Error(), "physical_layer.queues.bulk.policy") {
+		t.Errorf("Unknown policy is accepted: %v", err)
+	}
+	if _, err := LoadConfig("", "physical_layer.queues.scheduling=fair"); err == nil || !strings.Contains(err.Error(), "physical_layer.queues.scheduling") {
+		t.Errorf("Unknown scheduling is accepted: %v", err)
+	}
+	c, err := LoadConfig("", "physical_layer.queues.scheduling=weighted", "physical_layer.queues.bulk.limit=4", "physical_layer.queues.bulk.policy=drop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	physical := CreatePhysicalLayer(c, nil)
+	bulk := physical.Encoder.Classes[layers.PriorityBulk]
+	if !physical.Encoder.Weighted || bulk.Limit != 4 || bulk.Policy != layers.QueueDrop || bulk.Weight != 1 {
+		t.Errorf("Unexpected encoder %+v", physical.Encoder.Classes)
+	}
+}
+
+func TestCreateWindowProbe(t *testing.T) {
+	if _, err := LoadConfig("", "mac_layer.window_probe=-1s"); err == nil || !strings.Contains(err.Error(), "mac_layer.window_probe") {
+		t.Errorf("Negative probe is accepted: %v", err)
+	}
+	c, err := LoadConfig("", "mac_layer.header=v1", "mac_layer.window_probe=200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := CreateReliableDataLinkLayer(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer.WindowProbe != 200*time.Millisecond {
+		t.Errorf("Window probe %v, expected 200ms", layer.WindowProbe)
+	}
+}
+
+func TestUnbufferedReceive(t *testing.T) {
+	if _, err := LoadConfig("", "mac_layer.receive_buffer_size=-1"); err == nil || !strings.Contains(err.Error(), "mac_layer.receive_buffer_size") {
+		t.Errorf("Negative buffer size is accepted: %v", err)
+	}
+	if _, err := LoadConfig("", "mac_layer.receive_buffer_size=0"); err != nil {
+		t.Errorf("Unbuffered receive is refused: %v", err)
+	}
+}
+
+func TestCreateMaxMessageSize(t *testing.T) {
+	if _, err := LoadConfig("", "mac_layer.max_message_size=-1"); err == nil || !strings.Contains(err.Error(), "mac_layer.max_message_size") {
+		t.Errorf("Negative size is accepted: %v", err)
+	}
+	c, err := LoadConfig("", "mac_layer.max_message_size=1048576")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := CreateReliableDataLinkLayer(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer.MaxMessageSize != 1<<20 {
+		t.Errorf("Max message size %d, expected %d", layer.MaxMessageSize, 1<<20)
+	}
+}
@@ -0,0 +1,373 @@
+package config
+
+import (
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/dhcp"
+	"Aethernet/pkg/dns"
+	"Aethernet/pkg/fixed"
+	"Aethernet/pkg/iface"
+	"Aethernet/pkg/layers"
+	"Aethernet/pkg/modem"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+func CreateDevice(config *Config) (device.Device, error) {
+	switch strings.ToLower(config.Device.Backend) {
+	case "asio":
+		return &device.ASIOMono{
+			DeviceName: config.Device.DeviceName,
+			SampleRate: config.Device.SampleRate,
+			InChannel:  config.Device.InChannel,
+			OutChannel: config.Device.OutChannel,
+		}, nil
+	case "loopback":
+		return &device.Loopback{SampleRate: config.Device.SampleRate}, nil
+	default:
+		return nil, fmt.Errorf("Unknown device backend: %s, expected 'asio' or 'loopback'", config.Device.Backend)
+	}
+}
+
+// the preamble of the configured family, the config must be valid
+func CreatePreamble(config *Config) []int32 {
+	p := config.Modem.Preamble
+	amplitude := int32(p.Amplitude * 0x7fffffff)
+	switch strings.ToLower(p.Family) {
+	case "chirp":
+		preamble := modem.ChripConfig{MinFreq: p.MinFreq, MaxFreq: p.MaxFreq, Length: p.Length, SampleRate: config.Device.SampleRate}.New()
+		for i := range preamble {
+			preamble[i] *= p.Amplitude
+		}
+		return modem.Float64ToInt32(preamble)
+	case "barker":
+		return modem.BarkerConfig{Length: p.Length, ChipSize: p.ChipSize, Amplitude: amplitude}.New()
+	case "zadoff_chu":
+		return modem.ZadoffChuConfig{Root: p.Root, Length: p.Length, ChipSize: p.ChipSize, Amplitude: amplitude}.New()
+	default:
+		return modem.DigitalChripConfig{N: p.N, Amplitude: amplitude}.New()
+	}
+}
+
+// returns nil for the power detector
+func CreateDetector(config *Config, preamble []int32) *modem.Detector {
+	if !strings.EqualFold(config.Modem.Preamble.Detector, "ncc") {
+		return nil
+	}
+	return &modem.Detector{Preamble: preamble, Threshold: config.Modem.Preamble.Correlation}
+}
+
+// returns nil for the baseband levels, the config must be valid
+func CreatePassband(config *Config) *modem.Passband {
+	passband := config.Modem.Passband
+	scheme, err := modem.ParsePassbandScheme(passband.Scheme)
+	if err != nil {
+		return nil
+	}
+	return &modem.Passband{
+		Scheme:     scheme,
+		Freq:       passband.Freq,
+		Shift:      passband.Shift,
+		SampleRate: config.Device.SampleRate,
+		PilotSize:  passband.PilotSize,
+	}
+}
+
+// returns nil for one bit per symbol, the config must be valid
+func CreateConstellation(config *Config) *modem.Constellation {
+	constellation, err := modem.ParseConstellation(config.Modem.Carrier.Constellation)
+	if err != nil {
+		return nil
+	}
+	return constellation
+}
+
+// returns nil without taps
+func CreateEqualizer(config *Config) *modem.Equalizer {
+	equalizer := config.Modem.Equalizer
+	if equalizer.Taps == 0 {
+		return nil
+	}
+	return &modem.Equalizer{Taps: equalizer.Taps, Delay: equalizer.Delay, Training: equalizer.Training}
+}
+
+// returns nil without a slot
+func CreateCSMA(config *Config) *layers.CSMA {
+	csma := config.PhysicalLayer.CSMA
+	if csma.Slot == 0 {
+		return nil
+	}
+	return &layers.CSMA{DIFS: csma.DIFS, SIFS: csma.SIFS, Slot: csma.Slot, MinWindow: csma.MinWindow, MaxWindow: csma.MaxWindow}
+}
+
+// the uniform backoff, or the exponential one with a slot
+func CreateBackoffTimer(config *Config) layers.BackoffTimer {
+	backoff := config.MACLayer.BackoffTimer
+	if backoff.Slot != 0 {
+		return layers.ExponentialBackoffTimer{Slot: backoff.Slot, MinWindow: backoff.MinWindow, MaxWindow: backoff.MaxWindow}
+	}
+	return layers.RandomBackoffTimer{MinDelay: backoff.MinBackoff, MaxDelay: backoff.MaxBackoff}
+}
+
+// returns nil without a ring
+func CreateTokenPassing(config *Config) *layers.TokenPassing {
+	token := config.MACLayer.Token
+	if len(token.Ring) == 0 {
+		return nil
+	}
+	ring := make([]layers.ReliableDataLinkAddress, len(token.Ring))
+	for i, address := range token.Ring {
+		ring[i] = layers.ReliableDataLinkAddress(address)
+	}
+	return &layers.TokenPassing{Ring: ring, Hold: token.Hold, PassTimeout: token.PassTimeout, LostTimeout: token.LostTimeout}
+}
+
+// returns nil without an interval
+func CreateNeighborDiscovery(config *Config) *layers.NeighborDiscovery {
+	neighbors := config.MACLayer.Neighbors
+	if neighbors.Interval == 0 {
+		return nil
+	}
+	discovery := &layers.NeighborDiscovery{Interval: neighbors.Interval, Timeout: neighbors.Timeout}
+	if neighbors.Router {
+		discovery.Capabilities |= layers.CapabilityRouter
+	}
+	return discovery
+}
+
+// builds the physical layer on the given device, which can be any backend, e.g. one of a device.Network
+func CreatePhysicalLayer(config *Config, dev device.Device) layers.PhysicalLayer {
+
+	var Preamble = CreatePreamble(config)
+	var Passband = CreatePassband(config)
+	var Constellation = CreateConstellation(config)
+	var Equalizer = CreateEqualizer(config)
+
+	return layers.PhysicalLayer{
+		Device: dev,
+		Decoder: layers.Decoder{
+			Demodulator: modem.Demodulator{
+				Preamble:                 Preamble,
+				CarrierSize:              config.Modem.Carrier.Size,
+				BufferSize:               config.PhysicalLayer.ReceiveBufferSize,
+				DemodulatePowerThreshold: fixed.FromFloat(config.Modem.Preamble.Threshold),
+				Passband:                 Passband,
+				Constellation:            Constellation,
+				Equalizer:                Equalizer,
+				Detector:                 CreateDetector(config, Preamble),
+			},
+			BufferSize: config.PhysicalLayer.InputBufferSize,
+		},
+		Encoder: layers.Encoder{
+			Modulator: modem.Modulator{
+				Preamble:      Preamble,
+				CarrierSize:   config.Modem.Carrier.Size,
+				BytePerFrame:  config.Modem.BytePerFrame,
+				FrameInterval: config.Modem.FrameInterval,
+				Amplitude:     int32(config.Modem.Carrier.Amplitude * 0x7fffffff),
+				Passband:      Passband,
+				Constellation: Constellation,
+				Equalizer:     Equalizer,
+			},
+			BufferSize: config.PhysicalLayer.OutputBufferSize,
+			Classes:    CreateQueueClasses(config),
+			Weighted:   config.PhysicalLayer.Queues.Scheduling == "weighted",
+		},
+		PowerMonitor: layers.PowerMonitor{
+			Threshold:  fixed.FromFloat(config.PhysicalLayer.PowerMonitor.Threshold),
+			WindowSize: config.PhysicalLayer.PowerMonitor.Window,
+		},
+		FrontEnd: CreateFrontEnd(config),
+		Echo:     CreateEchoCanceller(config),
+		CSMA:     CreateCSMA(config),
+	}
+}
+
+// the configs of the classes indexed by layers.Priority
+func queueClasses(config *Config) [layers.NumPriorities]QueueClassConfig {
+	queues := config.PhysicalLayer.Queues
+	return [layers.NumPriorities]QueueClassConfig{
+		layers.PriorityControl:     queues.Control,
+		layers.PriorityInteractive: queues.Interactive,
+		layers.PriorityBulk:        queues.Bulk,
+	}
+}
+
+// the config must be valid
+func CreateQueueClasses(config *Config) [layers.NumPriorities]layers.QueueClass {
+	classes := [layers.NumPriorities]layers.QueueClass{}
+	for priority, class := range queueClasses(config) {
+		policy, _ := layers.ParseQueuePolicy(class.Policy)
+		classes[priority] = layers.QueueClass{Limit: class.Limit, Policy: policy, Timeout: class.Timeout, Weight: class.Weight}
+	}
+	return classes
+}
+
+func CreateEchoCanceller(config *Config) layers.EchoCanceller {
+	echo := config.PhysicalLayer.Echo
+	return layers.EchoCanceller{
+		Delay:              echo.Delay,
+		Taps:               echo.Taps,
+		StepSize:           echo.StepSize,
+		CollisionThreshold: echo.CollisionThreshold,
+		Window:             echo.Window,
+	}
+}
+
+func CreateFrontEnd(config *Config) layers.FrontEnd {
+	frontEnd := config.PhysicalLayer.FrontEnd
+	return layers.FrontEnd{
+		DCBlock: layers.DCBlocker{Pole: frontEnd.DCBlock},
+		BandPass: layers.BandPass{
+			Low:        frontEnd.BandPass.Low,
+			High:       frontEnd.BandPass.High,
+			SampleRate: config.Device.SampleRate,
+		},
+		AGC: layers.AGC{
+			Target:  frontEnd.AGC.Target,
+			Window:  frontEnd.AGC.Window,
+			MaxGain: frontEnd.AGC.MaxGain,
+		},
+	}
+}
+
+// returns nil if the security is disabled
+func CreateSecure(config *Config) (*layers.Secure, error) {
+	if !config.Security.Enabled {
+		return nil, nil
+	}
+	keys, err := layers.ParseSecureKeys(config.Security.Keys)
+	if err != nil {
+		return nil, err
+	}
+	return layers.NewSecure(keys)
+}
+
+func CreateNaiveDataLinkLayer(config *Config, dev device.Device) (*layers.NaiveDataLinkLayer, error) {
+	header, err := layers.ParseNaiveDataLinkHeaderFormat(config.MACLayer.NaiveHeader)
+	if err != nil {
+		return nil, err
+	}
+	if config.MACLayer.Address >= layers.NaiveDataLinkBroadcast {
+		return nil, fmt.Errorf("Address %d does not fit in the naive header or is the broadcast address", config.MACLayer.Address)
+	}
+	compression, err := layers.ParseCompression(config.MACLayer.Compression)
+	if err != nil {
+		return nil, err
+	}
+	secure, err := CreateSecure(config)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &layers.NaiveDataLinkLayer{
+		PhysicalLayer: CreatePhysicalLayer(config, dev),
+		Header:        header,
+		Address:       byte(config.MACLayer.Address),
+		BufferSize:    config.MACLayer.ReceiveBufferSize,
+		Compression:   compression,
+		Secure:        secure,
+	}
+	joinGroups(config, &layer.Groups)
+	return layer, nil
+}
+
+func joinGroups(config *Config, groups *layers.Groups) {
+	for _, group := range config.MACLayer.Groups {
+		groups.Join(layers.Group(group))
+	}
+}
+
+func CreateReliableDataLinkLayer(config *Config, dev device.Device) (*layers.ReliableDataLinkLayer, error) {
+	header, err := layers.ParseReliableDataLinkHeaderFormat(config.MACLayer.Header)
+	if err != nil {
+		return nil, err
+	}
+	if config.MACLayer.Address > int(header.MaxAddress()) {
+		return nil, fmt.Errorf("Address %d does not fit in the %v header or is the broadcast address", config.MACLayer.Address, header)
+	}
+	compression, err := layers.ParseCompression(config.MACLayer.Compression)
+	if err != nil {
+		return nil, err
+	}
+	secure, err := CreateSecure(config)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &layers.ReliableDataLinkLayer{
+		PhysicalLayer:  CreatePhysicalLayer(config, dev),
+		BytePerFrame:   config.MACLayer.BytePerFrame,
+		Address:        layers.ReliableDataLinkAddress(config.MACLayer.Address),
+		ACKTimeout:     config.MACLayer.AckTimeout,
+		ACKDelay:       config.MACLayer.AckDelay,
+		WindowProbe:    config.MACLayer.WindowProbe,
+		MaxMessageSize: config.MACLayer.MaxMessageSize,
+		MaxRetries:     config.MACLayer.MaxRetryAttempts,
+		BackoffTimer:   CreateBackoffTimer(config),
+		BufferSize:     config.MACLayer.ReceiveBufferSize,
+		Compression:    compression,
+		Secure:         secure,
+		RTSThreshold:   config.MACLayer.RTSThreshold,
+		Token:          CreateTokenPassing(config),
+		Header:         header,
+		Neighbors:      CreateNeighborDiscovery(config),
+	}
+	joinGroups(config, &layer.Groups)
+	return layer, nil
+}
+
+func OpenInterface(config *Config) (iface.Interface, error) {
+	switch strings.ToLower(config.Iface.Type) {
+	case "tun":
+		return iface.OpenTUN(config.Iface.IP, config.Iface.Name)
+	case "tap":
+		return iface.OpenTAP(config.Iface.IP)
+	case "pcap":
+		return iface.OpenPCAP(config.Iface.Name, config.Iface.Filter)
+	default:
+		return nil, fmt.Errorf("Unknown interface type: %s, expected 'tun', 'tap' or 'pcap'", config.Iface.Type)
+	}
+}
+
+func CreateDHCPServer(config *Config) (*dhcp.Server, error) {
+	pool, err := netip.ParsePrefix(config.Gateway.DHCP.Pool)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid DHCP pool %s: %v", config.Gateway.DHCP.Pool, err)
+	}
+
+	header, err := layers.ParseReliableDataLinkHeaderFormat(config.MACLayer.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &dhcp.Server{
+		Address: byte(config.MACLayer.Address),
+		// the addresses handed out fit the header of the segment, below the address of the unassigned nodes
+		MaxAddress: byte(min(int(header.MaxAddress()), dhcp.Unassigned-1)),
+		Pool:       pool,
+		LeaseTime:  config.Gateway.DHCP.LeaseTime,
+	}
+	if ip, err := netip.ParsePrefix(config.Iface.IP); err == nil {
+		server.ReservedIPs = append(server.ReservedIPs, ip.Addr())
+	}
+	for _, address := range config.Gateway.DHCP.Reserved {
+		server.Reserved = append(server.Reserved, byte(address))
+	}
+	return server, nil
+}
+
+func CreateDNSForwarder(config *Config) (*dns.Forwarder, error) {
+	forwarder := &dns.Forwarder{
+		Upstream: config.Gateway.DNS.Upstream,
+		Hosts:    make(map[string]netip.Addr),
+	}
+	for name, ip := range config.Gateway.DNS.Hosts {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid address %s for host %s: %v", ip, name, err)
+		}
+		forwarder.Hosts[strings.TrimSuffix(strings.ToLower(name), ".")] = addr
+	}
+	return forwarder, nil
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the prefix of the environment variables, e.g. AETHERNET_MAC_LAYER_ADDRESS overrides mac_layer.address
+const EnvPrefix = "AETHERNET_"
+
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// sets the value at the dotted path of the yaml names, e.g. "modem.carrier.size" or "security.keys.2"
+func (c *Config) Set(key, value string) error {
+	v := reflect.ValueOf(c).Elem()
+	path := strings.Split(key, ".")
+	for i, name := range path {
+		switch v.Kind() {
+		case reflect.Struct:
+			found := false
+			for j := 0; j < v.NumField(); j++ {
+				if yamlName(v.Type().Field(j)) == name {
+					v = v.Field(j)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("unknown config key %s", key)
+			}
+		case reflect.Map:
+			if i != len(path)-1 {
+				return fmt.Errorf("unknown config key %s", key)
+			}
+			k := reflect.New(v.Type().Key())
+			if err := yaml.Unmarshal([]byte(name), k.Interface()); err != nil {
+				return fmt.Errorf("invalid key %s for %s: %v", name, key, err)
+			}
+			e := reflect.New(v.Type().Elem())
+			if err := yaml.Unmarshal([]byte(value), e.Interface()); err != nil {
+				return fmt.Errorf("invalid value %q for %s: %v", value, key, err)
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			v.SetMapIndex(k.Elem(), e.Elem())
+			return nil
+		default:
+			return fmt.Errorf("unknown config key %s", key)
+		}
+	}
+
+	if v.Kind() == reflect.Struct {
+		return fmt.Errorf("config key %s is a section", key)
+	}
+	e := reflect.New(v.Type())
+	if err := yaml.Unmarshal([]byte(value), e.Interface()); err != nil {
+		return fmt.Errorf("invalid value %q for %s: %v", value, key, err)
+	}
+	v.Set(e.Elem())
+	return nil
+}
+
+// applies an override in the form of "section.key=value"
+func (c *Config) Override(override string) error {
+	key, value, ok := strings.Cut(override, "=")
+	if !ok {
+		return fmt.Errorf("invalid override %s, expected key=value", override)
+	}
+	return c.Set(strings.TrimSpace(key), strings.TrimSpace(value))
+}
+
+// applies the environment variables named after the keys, maps can only be set with the overrides
+func (c *Config) ApplyEnv() error {
+	var walk func(t reflect.Type, path []string) error
+	walk = func(t reflect.Type, path []string) error {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			fieldPath := append(slices.Clone(path), yamlName(field))
+			switch field.Type.Kind() {
+			case reflect.Struct:
+				if err := walk(field.Type, fieldPath); err != nil {
+					return err
+				}
+			case reflect.Map:
+			default:
+				env := EnvPrefix + strings.ToUpper(strings.Join(fieldPath, "_"))
+				if value, ok := os.LookupEnv(env); ok {
+					if err := c.Set(strings.Join(fieldPath, "."), value); err != nil {
+						return fmt.Errorf("%s: %v", env, err)
+					}
+				}
+			}
+		}
+		return nil
+	}
+	return walk(reflect.TypeOf(*c), nil)
+}
+
+// Overrides collects repeated "-set section.key=value" flags
+type Overrides []string
+
+func (o *Overrides) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *Overrides) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
+
+// Flags are the command line flags selecting the configuration file and the overrides
+type Flags struct {
+	File      string
+	Overrides Overrides
+}
+
+// registers -config and -set on the flag set
+func (f *Flags) Register(fs *flag.FlagSet, defaultFile string) {
+	fs.StringVar(&f.File, "config", defaultFile, "the configuration file, empty to use the defaults")
+	fs.Var(&f.Overrides, "set", "override a config value, e.g. -set mac_layer.address=2, can be repeated")
+}
+
+// loads the config selected by the parsed flags
+func (f *Flags) Load() (*Config, error) {
+	return LoadConfig(f.File, f.Overrides...)
+}
+
+// registers the flags on the command line, parses it and loads the config
+func LoadFromFlags(defaultFile string) (*Config, error) {
+	var flags Flags
+	flags.Register(flag.CommandLine, defaultFile)
+	flag.Parse()
+	return flags.Load()
+}
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"Aethernet/pkg/dhcp"
+	"Aethernet/pkg/layers"
+	"Aethernet/pkg/modem"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// the longest payload the modem header can describe
+const maxModemBytePerFrame = 127
+
+// checks the values and returns all the problems found
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	switch strings.ToLower(c.Device.Backend) {
+	case "asio":
+		check(c.Device.DeviceName != "", "device.device_name is required by the asio backend")
+	case "loopback":
+	default:
+		check(false, "device.backend %q is unknown, expected 'asio' or 'loopback'", c.Device.Backend)
+	}
+	check(c.Device.SampleRate > 0, "device.sample_rate must be positive, got %v", c.Device.SampleRate)
+	check(c.Device.InChannel >= 0, "device.in_channel must not be negative, got %d", c.Device.InChannel)
+	check(c.Device.OutChannel >= 0, "device.out_channel must not be negative, got %d", c.Device.OutChannel)
+
+	check(c.Modem.BytePerFrame > 0 && c.Modem.BytePerFrame <= maxModemBytePerFrame,
+		"modem.byte_per_frame must be in [1, %d], got %d", maxModemBytePerFrame, c.Modem.BytePerFrame)
+	check(c.Modem.FrameInterval >= 0, "modem.frame_interval must not be negative, got %d", c.Modem.FrameInterval)
+	check(c.Modem.Preamble.Amplitude > 0 && c.Modem.Preamble.Amplitude <= 1,
+		"modem.preamble.amplitude must be in (0, 1], got %v", c.Modem.Preamble.Amplitude)
+	switch strings.ToLower(c.Modem.Preamble.Family) {
+	case "digital_chirp":
+		check(c.Modem.Preamble.N > 0, "modem.preamble.n must be positive, got %d", c.Modem.Preamble.N)
+	case "chirp":
+		check(c.Modem.Preamble.Length > 1, "modem.preamble.length must be at least 2 for a chirp, got %d", c.Modem.Preamble.Length)
+		check(c.Modem.Preamble.MinFreq > 0 && c.Modem.Preamble.MinFreq < c.Modem.Preamble.MaxFreq && c.Modem.Preamble.MaxFreq <= c.Device.SampleRate/2,
+			"modem.preamble must have 0 < min_freq < max_freq <= sample_rate/2, got %v and %v", c.Modem.Preamble.MinFreq, c.Modem.Preamble.MaxFreq)
+	case "barker":
+		check(slices.Contains([]int{2, 3, 4, 5, 7, 11, 13}, c.Modem.Preamble.Length),
+			"modem.preamble.length must be 2, 3, 4, 5, 7, 11 or 13 for a Barker code, got %d", c.Modem.Preamble.Length)
+		check(c.Modem.Preamble.ChipSize > 0, "modem.preamble.chip_size must be positive, got %d", c.Modem.Preamble.ChipSize)
+	case "zadoff_chu":
+		check(c.Modem.Preamble.Length > 1, "modem.preamble.length must be at least 2 for a Zadoff-Chu sequence, got %d", c.Modem.Preamble.Length)
+		check(c.Modem.Preamble.Root > 0 && c.Modem.Preamble.Length > 1 && gcd(c.Modem.Preamble.Root, c.Modem.Preamble.Length) == 1,
+			"modem.preamble.root must be positive and coprime with the length %d, got %d", c.Modem.Preamble.Length, c.Modem.Preamble.Root)
+		check(c.Modem.Preamble.ChipSize > 0, "modem.preamble.chip_size must be positive, got %d", c.Modem.Preamble.ChipSize)
+	default:
+		check(false, "modem.preamble.family %q is unknown, expected 'digital_chirp', 'chirp', 'barker' or 'zadoff_chu'", c.Modem.Preamble.Family)
+	}
+	switch strings.ToLower(c.Modem.Preamble.Detector) {
+	case "power":
+		check(c.Modem.Preamble.Threshold > 0, "modem.preamble.threshold must be positive, got %v", c.Modem.Preamble.Threshold)
+	case "ncc":
+		check(c.Modem.Preamble.Correlation > 0 && c.Modem.Preamble.Correlation <= 1,
+			"modem.preamble.correlation must be in (0, 1], got %v", c.Modem.Preamble.Correlation)
+	default:
+		check(false, "modem.preamble.detector %q is unknown, expected 'power' or 'ncc'", c.Modem.Preamble.Detector)
+	}
+	check(c.Modem.Carrier.Amplitude > 0 && c.Modem.Carrier.Amplitude <= 1,
+		"modem.carrier.amplitude must be in (0, 1], got %v", c.Modem.Carrier.Amplitude)
+	check(c.Modem.Carrier.Size > 0, "modem.carrier.size must be positive, got %d", c.Modem.Carrier.Size)
+	if passband := c.Modem.Passband; !strings.EqualFold(passband.Scheme, "none") {
+		scheme, err := modem.ParsePassbandScheme(passband.Scheme)
+		check(err == nil, "modem.passband.scheme: %v", err)
+		top := passband.Freq
+		if scheme == modem.FSK {
+			check(passband.Shift > 0, "modem.passband.shift must be positive, got %v", passband.Shift)
+			top += passband.Shift
+		}
+		check(passband.Freq > 0 && top < c.Device.SampleRate/2,
+			"modem.passband.freq must be positive and the tones below sample_rate/2, got %v", passband.Freq)
+		check(passband.PilotSize >= 0, "modem.passband.pilot_size must not be negative, got %d", passband.PilotSize)
+	}
+	if c.Modem.Carrier.Constellation != "" {
+		constellation, err := modem.ParseConstellation(c.Modem.Carrier.Constellation)
+		check(err == nil, "modem.carrier.constellation: %v", err)
+		scheme, passbandErr := modem.ParsePassbandScheme(c.Modem.Passband.Scheme)
+		if err == nil {
+			check(passbandErr == nil || constellation.IsReal(),
+				"modem.carrier.constellation %s needs the psk passband", constellation.Name)
+		}
+		check(passbandErr != nil || scheme != modem.FSK, "modem.carrier.constellation is not supported by the fsk passband")
+	}
+	if equalizer := c.Modem.Equalizer; equalizer.Taps != 0 {
+		check(equalizer.Taps > 0, "modem.equalizer.taps must not be negative, got %d", equalizer.Taps)
+		check(equalizer.Delay >= 0 && equalizer.Delay < equalizer.Taps,
+			"modem.equalizer.delay must be in [0, taps), got %d", equalizer.Delay)
+		check(equalizer.Training >= 2*equalizer.Taps,
+			"modem.equalizer.training must be at least twice the taps, got %d", equalizer.Training)
+		// the equalized samples lag by the delay, which the interval must leave before the next preamble
+		delay := equalizer.Delay
+		if delay == 0 {
+			delay = equalizer.Taps / 2
+		}
+		check(delay <= c.Modem.FrameInterval, "modem.equalizer.delay must not exceed modem.frame_interval, got %d", delay)
+	}
+
+	check(c.PhysicalLayer.InputBufferSize > 0, "physical_layer.input_buffer_size must be positive, got %d", c.PhysicalLayer.InputBufferSize)
+	check(c.PhysicalLayer.OutputBufferSize > 0, "physical_layer.output_buffer_size must be positive, got %d", c.PhysicalLayer.OutputBufferSize)
+	check(c.PhysicalLayer.ReceiveBufferSize >= 0, "physical_layer.receive_buffer_size must not be negative, got %d", c.PhysicalLayer.ReceiveBufferSize)
+	check(c.PhysicalLayer.PowerMonitor.Window > 0, "physical_layer.power_monitor.window must be positive, got %d", c.PhysicalLayer.PowerMonitor.Window)
+	if csma := c.PhysicalLayer.CSMA; csma.Slot != 0 {
+		check(csma.Slot > 0, "physical_layer.csma.slot must not be negative, got %d", csma.Slot)
+		check(csma.SIFS >= 0 && csma.SIFS < csma.DIFS, "physical_layer.csma must have 0 <= sifs < difs, got %d and %d", csma.SIFS, csma.DIFS)
+		check(csma.MinWindow > 0 && csma.MinWindow <= csma.MaxWindow,
+			"physical_layer.csma must have 0 < min_window <= max_window, got %d and %d", csma.MinWindow, csma.MaxWindow)
+	}
+	frontEnd := c.PhysicalLayer.FrontEnd
+	check(frontEnd.DCBlock >= 0 && frontEnd.DCBlock < 1, "physical_layer.front_end.dc_block must be in [0, 1), got %v", frontEnd.DCBlock)
+	check(frontEnd.AGC.Target >= 0 && frontEnd.AGC.Target <= 1, "physical_layer.front_end.agc.target must be in [0, 1], got %v", frontEnd.AGC.Target)
+	check(frontEnd.AGC.Window >= 0, "physical_layer.front_end.agc.window must not be negative, got %d", frontEnd.AGC.Window)
+	check(frontEnd.AGC.MaxGain >= 0, "physical_layer.front_end.agc.max_gain must not be negative, got %v", frontEnd.AGC.MaxGain)
+	check(frontEnd.BandPass.Low >= 0 && frontEnd.BandPass.High >= 0 && frontEnd.BandPass.High < c.Device.SampleRate/2 &&
+		(frontEnd.BandPass.High == 0 || frontEnd.BandPass.Low < frontEnd.BandPass.High),
+		"physical_layer.front_end.band_pass must have 0 <= low < high < sample_rate/2 or be 0, got %v and %v", frontEnd.BandPass.Low, frontEnd.BandPass.High)
+	echo := c.PhysicalLayer.Echo
+	check(echo.Delay >= 0 && echo.Taps >= 0 && echo.Window >= 0, "physical_layer.echo must have a non negative delay, taps and window, got %d, %d and %d",
+		echo.Delay, echo.Taps, echo.Window)
+	check(echo.StepSize >= 0 && echo.StepSize < 2, "physical_layer.echo.step_size must be in [0, 2), got %v", echo.StepSize)
+	check(echo.CollisionThreshold >= 0 && echo.CollisionThreshold <= 1, "physical_layer.echo.collision_threshold must be in [0, 1], got %v", echo.CollisionThreshold)
+	queues := c.PhysicalLayer.Queues
+	check(queues.Scheduling == "strict" || queues.Scheduling == "weighted",
+		"physical_layer.queues.scheduling must be 'strict' or 'weighted', got %s", queues.Scheduling)
+	for priority, class := range queueClasses(c) {
+		name := layers.Priority(priority)
+		if _, err := layers.ParseQueuePolicy(class.Policy); err != nil {
+			check(false, "physical_layer.queues.%s.policy: %v", name, err)
+		}
+		check(class.Limit >= 0 && class.Timeout >= 0 && class.Weight >= 0,
+			"physical_layer.queues.%s must have a non negative limit, timeout and weight, got %d, %v and %d", name, class.Limit, class.Timeout, class.Weight)
+	}
+
+	header, err := layers.ParseReliableDataLinkHeaderFormat(c.MACLayer.Header)
+	if err != nil {
+		check(false, "mac_layer.header: %v", err)
+	}
+	if _, err := layers.ParseNaiveDataLinkHeaderFormat(c.MACLayer.NaiveHeader); err != nil {
+		check(false, "mac_layer.naive_header: %v", err)
+	}
+	// the header also bounds the addresses the DHCP server of the gateway hands out, the naive layer checks its own byte in CreateNaiveDataLinkLayer
+	check(c.MACLayer.Address >= 0 && c.MACLayer.Address <= int(header.MaxAddress()),
+		"mac_layer.address must be in [0, %d] with the %v mac_layer.header, got %d", header.MaxAddress(), header, c.MACLayer.Address)
+	check(c.MACLayer.BytePerFrame >= 0, "mac_layer.byte_per_frame must not be negative, got %d", c.MACLayer.BytePerFrame)
+	check(c.MACLayer.AckTimeout > 0, "mac_layer.ack_timeout must be positive, got %v", c.MACLayer.AckTimeout)
+	check(c.MACLayer.AckDelay >= 0 && c.MACLayer.AckDelay < c.MACLayer.AckTimeout,
+		"mac_layer.ack_delay must be in [0, ack_timeout), got %v", c.MACLayer.AckDelay)
+	check(c.MACLayer.AckDelay == 0 || header != layers.ReliableDataLinkHeaderCompact,
+		"mac_layer.ack_delay needs a versioned mac_layer.header to piggyback the ACKs")
+	check(c.MACLayer.WindowProbe >= 0, "mac_layer.window_probe must not be negative, got %v", c.MACLayer.WindowProbe)
+	check(c.MACLayer.MaxMessageSize >= 0, "mac_layer.max_message_size must not be negative, got %d", c.MACLayer.MaxMessageSize)
+	check(c.MACLayer.MaxRetryAttempts >= 0, "mac_layer.max_retry_attempts must not be negative, got %d", c.MACLayer.MaxRetryAttempts)
+	check(c.MACLayer.BackoffTimer.MinBackoff >= 0 && c.MACLayer.BackoffTimer.MinBackoff < c.MACLayer.BackoffTimer.MaxBackoff,
+		"mac_layer.backoff_timer must have 0 <= min_backoff < max_backoff, got %v and %v", c.MACLayer.BackoffTimer.MinBackoff, c.MACLayer.BackoffTimer.MaxBackoff)
+	if backoff := c.MACLayer.BackoffTimer; backoff.Slot != 0 {
+		check(backoff.Slot > 0, "mac_layer.backoff_timer.slot must not be negative, got %v", backoff.Slot)
+		check(backoff.MinWindow > 0 && backoff.MinWindow <= backoff.MaxWindow,
+			"mac_layer.backoff_timer must have 0 < min_window <= max_window, got %d and %d", backoff.MinWindow, backoff.MaxWindow)
+	}
+	check(c.MACLayer.ReceiveBufferSize >= 0, "mac_layer.receive_buffer_size must not be negative, got %d", c.MACLayer.ReceiveBufferSize)
+	if _, err := layers.ParseCompression(c.MACLayer.Compression); err != nil {
+		check(false, "mac_layer.compression: %v", err)
+	}
+	for _, group := range c.MACLayer.Groups {
+		check(group >= 0 && group <= 0xff, "mac_layer.groups must be in [0, 255], got %d", group)
+	}
+	check(c.MACLayer.RTSThreshold >= 0, "mac_layer.rts_threshold must not be negative, got %d", c.MACLayer.RTSThreshold)
+	check(c.MACLayer.RTSThreshold == 0 || c.PhysicalLayer.CSMA.Slot > 0, "mac_layer.rts_threshold needs physical_layer.csma for the NAV")
+	if token := c.MACLayer.Token; len(token.Ring) > 0 {
+		check(slices.Contains(token.Ring, c.MACLayer.Address), "mac_layer.token.ring must contain mac_layer.address %d, got %v", c.MACLayer.Address, token.Ring)
+		for i, address := range token.Ring {
+			check(address >= 0 && address <= int(header.MaxAddress()) && !slices.Contains(token.Ring[:i], address),
+				"mac_layer.token.ring must have distinct addresses in [0, %d], got %v", header.MaxAddress(), token.Ring)
+		}
+		check(token.Hold > 0, "mac_layer.token.hold must be positive, got %v", token.Hold)
+		check(token.PassTimeout > 0 && token.PassTimeout < token.LostTimeout,
+			"mac_layer.token must have 0 < pass_timeout < lost_timeout, got %v and %v", token.PassTimeout, token.LostTimeout)
+	}
+	if neighbors := c.MACLayer.Neighbors; neighbors.Interval != 0 {
+		check(neighbors.Interval > 0 && neighbors.Interval < neighbors.Timeout,
+			"mac_layer.neighbors must have 0 < interval < timeout, got %v and %v", neighbors.Interval, neighbors.Timeout)
+	}
+
+	if c.Security.Enabled {
+		check(c.MACLayer.Address <= 0xff, "security needs mac_layer.address in [0, 255], got %d", c.MACLayer.Address)
+		check(len(c.Security.Keys) > 0, "security.keys is required when the security is enabled")
+		if keys, err := layers.ParseSecureKeys(c.Security.Keys); err != nil {
+			check(false, "security.keys: %v", err)
+		} else if _, err := layers.NewSecure(keys); err != nil {
+			check(false, "security.keys: %v", err)
+		}
+	}
+
+	switch strings.ToLower(c.Iface.Type) {
+	case "tun", "tap", "pcap":
+	default:
+		check(false, "iface.type %q is unknown, expected 'tun', 'tap' or 'pcap'", c.Iface.Type)
+	}
+
+	switch strings.ToLower(c.Gateway.DHCP.Role) {
+	case "server":
+		_, err := netip.ParsePrefix(c.Gateway.DHCP.Pool)
+		check(err == nil, "gateway.dhcp.pool %q is not a valid prefix", c.Gateway.DHCP.Pool)
+		check(c.Gateway.DHCP.LeaseTime > 0, "gateway.dhcp.lease_time must be positive, got %v", c.Gateway.DHCP.LeaseTime)
+		for _, address := range c.Gateway.DHCP.Reserved {
+			check(address >= 0 && address < dhcp.Unassigned, "gateway.dhcp.reserved must have addresses in [0, %d], got %v", dhcp.Unassigned-1, c.Gateway.DHCP.Reserved)
+		}
+	case "client", "":
+	default:
+		check(false, "gateway.dhcp.role %q is unknown, expected 'server', 'client' or empty", c.Gateway.DHCP.Role)
+	}
+	for name, ip := range c.Gateway.DNS.Hosts {
+		_, err := netip.ParseAddr(ip)
+		check(err == nil, "gateway.dns.hosts: invalid address %q for host %s", ip, name)
+	}
+
+	return errors.Join(errs...)
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
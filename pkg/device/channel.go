@@ -0,0 +1,76 @@
+package device
+
+import (
+	"math"
+
+	"golang.org/x/exp/rand"
+)
+
+const fullScale = 0x7fffffff
+
+type Echo struct {
+	Delay int     // in samples
+	Gain  float64 // relative to the direct path, negative to invert
+}
+
+// Channel simulates the distortions of the acoustic path: multipath echoes, attenuation, DC offset and additive white Gaussian noise.
+// It keeps the state of the echoes between calls to Apply, so one channel must be used for one stream.
+type Channel struct {
+	Gain  float64 // attenuation of the signal, 0 means no attenuation
+	Echos []Echo
+	DC    float64 // the offset relative to the full scale
+	Noise float64 // the standard deviation of the noise relative to the full scale
+	Seed  uint64  // the seed of the noise, the same seed gives the same noise
+
+	rng     *rand.Rand
+	history []float64 // the previous samples for the echoes, the latest at the end
+}
+
+// the standard deviation of the noise giving the SNR in dB for a signal of the amplitude relative to the full scale,
+// the byte modem sends a constant envelope so its power is amplitude^2
+func NoiseForSNR(snr float64, amplitude float64) float64 {
+	return amplitude / math.Pow(10, snr/20)
+}
+
+func (c *Channel) clone() *Channel {
+	copied := *c
+	copied.rng = nil
+	copied.history = nil
+	return &copied
+}
+
+// applies the channel to the samples in place
+func (c *Channel) Apply(samples []int32) {
+	if c.rng == nil {
+		c.rng = rand.New(rand.NewSource(c.Seed))
+	}
+	maxDelay := 0
+	for _, echo := range c.Echos {
+		maxDelay = max(maxDelay, echo.Delay)
+	}
+	gain := c.Gain
+	if gain == 0 {
+		gain = 1
+	}
+
+	for i, sample := range samples {
+		x := float64(sample)
+		y := x
+		if maxDelay > 0 {
+			c.history = append(c.history, x)
+			for _, echo := range c.Echos {
+				if j := len(c.history) - 1 - echo.Delay; j >= 0 {
+					y += echo.Gain * c.history[j]
+				}
+			}
+			if len(c.history) > 4*maxDelay+BufferSize {
+				c.history = append(c.history[:0], c.history[len(c.history)-maxDelay:]...)
+			}
+		}
+		y = y*gain + c.DC*fullScale
+		if c.Noise != 0 {
+			y += c.rng.NormFloat64() * c.Noise * fullScale
+		}
+		samples[i] = int32(max(min(y, math.MaxInt32), math.MinInt32))
+	}
+}
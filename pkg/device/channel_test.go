@@ -0,0 +1,43 @@
+package device
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestChannel(t *testing.T) {
+
+	// an impulse through the echoes, the attenuation and the offset
+	channel := Channel{Gain: 0.5, Echos: []Echo{{Delay: 3, Gain: -0.5}, {Delay: 700, Gain: 0.25}}, DC: 0.01}
+	impulse := make([]int32, 1000)
+	impulse[0] = 1 << 30
+	for i := 0; i < len(impulse); i += BufferSize {
+		channel.Apply(impulse[i:min(i+BufferSize, len(impulse))])
+	}
+	dc := int32(fullScale / 100)
+	expected := map[int]int32{0: 1<<29 + dc, 3: -1<<28 + dc, 700: 1<<27 + dc, 1: dc, 999: dc}
+	for i, value := range expected {
+		if diff := impulse[i] - value; diff < -1 || diff > 1 {
+			t.Errorf("Sample %d is %d, expected %d", i, impulse[i], value)
+		}
+	}
+
+	// the noise has the expected deviation and is reproducible
+	const n = 100000
+	noise := make([]int32, n)
+	(&Channel{Noise: NoiseForSNR(20, 0.5), Seed: 1}).Apply(noise)
+	var power float64
+	for _, sample := range noise {
+		power += float64(sample) * float64(sample)
+	}
+	std := math.Sqrt(power/n) / fullScale
+	if math.Abs(std-0.05) > 0.001 {
+		t.Errorf("Noise deviation %.4f, expected 0.05", std)
+	}
+	again := make([]int32, n)
+	(&Channel{Noise: NoiseForSNR(20, 0.5), Seed: 1}).Apply(again)
+	if !reflect.DeepEqual(noise, again) {
+		t.Errorf("Noise with the same seed differs")
+	}
+}
@@ -22,11 +22,13 @@ type Network[BufferIDType comparable] struct {
 	SampleRate float64                     // the fake sample rate, 0 means no limit
 	Config     NetworkConfig[BufferIDType] // the topology of the network
 	LateUpdate func()                      // the post process function
+	Channel    *Channel                    // the distortions applied to every buffer, nil for an ideal medium
 
-	once    sync.Once
-	buffers map[BufferIDType][]int32
-	devices []*networkNode[BufferIDType]
-	done    chan struct{}
+	once     sync.Once
+	buffers  map[BufferIDType][]int32
+	channels map[BufferIDType]*Channel
+	devices  []*networkNode[BufferIDType]
+	done     chan struct{}
 }
 
 func (n *Network[BufferIDType]) Stop() {
@@ -45,12 +47,19 @@ func (n *Network[BufferIDType]) GetBuffer(name BufferIDType) []int32 {
 	if !ok {
 		buf = alloci32(BufferSize)
 		n.buffers[name] = buf
+		if n.Channel != nil {
+			// every buffer is a separate medium with its own echoes and noise
+			channel := n.Channel.clone()
+			channel.Seed += uint64(len(n.channels))
+			n.channels[name] = channel
+		}
 	}
 	return buf
 }
 
 func (n *Network[BufferIDType]) Build() []*networkNode[BufferIDType] {
 	n.buffers = make(map[BufferIDType][]int32)
+	n.channels = make(map[BufferIDType]*Channel)
 	n.done = make(chan struct{})
 	for _, deviceConfig := range n.Config {
 		n.devices = append(n.devices, &networkNode[BufferIDType]{
@@ -78,10 +87,18 @@ func (n *Network[BufferIDType]) update() {
 	// sum up the output of all the devices to the input buffer
 	for i, deviceConfig := range n.Config {
 		device := n.devices[i]
+		if device.callback == nil {
+			// a stopped device is silent instead of repeating its last output
+			continue
+		}
 		buf := n.buffers[deviceConfig.Out]
 		sumi32(buf, device.output, buf)
 	}
 
+	for name, channel := range n.channels {
+		channel.Apply(n.buffers[name])
+	}
+
 	if n.LateUpdate != nil {
 		n.LateUpdate()
 	}
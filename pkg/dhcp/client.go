@@ -0,0 +1,271 @@
+package dhcp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRetryTimeout = 2 * time.Second
+	DefaultMaxRetries   = 5
+	DefaultProbeTimeout = 500 * time.Millisecond
+)
+
+// Client obtains a data link address and an IPv4 lease from the coordinator.
+// Before a lease is bound and after each renewal, the client probes whether another node uses its addresses,
+// and declines the lease if one defends them.
+type Client struct {
+	ID           ClientID // random if not set
+	Link         Link
+	RetryTimeout time.Duration
+	MaxRetries   int
+	ProbeTimeout time.Duration // how long the client waits for a node to defend the probed addresses
+
+	OnLease func(lease Lease) // called whenever a lease is acquired or renewed, may be nil
+
+	once    sync.Once
+	mu      sync.Mutex
+	lease   Lease
+	replies chan Message
+}
+
+func (c *Client) init() {
+	c.once.Do(func() {
+		if c.ID == (ClientID{}) {
+			rand.Read(c.ID[:])
+		}
+		if c.RetryTimeout == 0 {
+			c.RetryTimeout = DefaultRetryTimeout
+		}
+		if c.MaxRetries == 0 {
+			c.MaxRetries = DefaultMaxRetries
+		}
+		if c.ProbeTimeout == 0 {
+			c.ProbeTimeout = DefaultProbeTimeout
+		}
+		c.replies = make(chan Message, 1)
+	})
+}
+
+// handles a frame from the data link layer, returns false if it is not an address assignment message
+func (c *Client) Handle(frame []byte) bool {
+	if !IsMessage(frame) {
+		return false
+	}
+	c.init()
+	var m Message
+	if err := m.FromBytes(frame); err != nil {
+		fmt.Printf("[DHCP] Invalid message: %v\n", err)
+		return true
+	}
+	if m.Op == OpProbe {
+		c.defend(m)
+		return true
+	}
+	if m.Client != c.ID {
+		// the reply is for another client
+		return true
+	}
+	switch m.Op {
+	case OpOffer, OpAck, OpNak, OpDefend:
+		select {
+		case c.replies <- m:
+		default:
+			fmt.Printf("[DHCP] Unexpected %v dropped\n", m.Op)
+		}
+	}
+	return true
+}
+
+// sends the message until one of the expected replies arrives
+func (c *Client) exchange(m Message, expected ...Op) (Message, error) {
+	c.init()
+
+	// drop the stale replies
+	select {
+	case <-c.replies:
+	default:
+	}
+
+	for retries := 0; retries < c.MaxRetries; retries++ {
+		c.Link.Send(m.ToBytes())
+		timeout := time.After(c.RetryTimeout)
+	wait:
+		for {
+			select {
+			case reply := <-c.replies:
+				for _, op := range expected {
+					if reply.Op == op {
+						return reply, nil
+					}
+				}
+				fmt.Printf("[DHCP] Unexpected %v while waiting for %v\n", reply.Op, expected)
+			case <-timeout:
+				fmt.Printf("[DHCP] %v timeout, retry %d\n", m.Op, retries)
+				break wait
+			}
+		}
+	}
+	return Message{}, fmt.Errorf("no reply to %v after %d retries", m.Op, c.MaxRetries)
+}
+
+// answers a probe of another client for the addresses of the current lease
+func (c *Client) defend(probe Message) {
+	current := c.Lease()
+	if probe.Client == c.ID || !current.IP.IsValid() || probe.MAC != current.MAC && probe.IP.Addr() != current.IP.Addr() {
+		return
+	}
+	fmt.Printf("[DHCP] Defending MAC %d, IP %v against %x\n", current.MAC, current.IP, probe.Client)
+	c.Link.Send(Message{Op: OpDefend, Client: probe.Client, MAC: current.MAC, IP: current.IP}.ToBytes())
+}
+
+// reports whether another node defends the addresses of the lease
+func (c *Client) conflict(lease Lease) bool {
+	// drop the stale replies
+	select {
+	case <-c.replies:
+	default:
+	}
+
+	c.Link.Send(Message{Op: OpProbe, Client: c.ID, MAC: lease.MAC, IP: lease.IP}.ToBytes())
+	timeout := time.After(c.ProbeTimeout)
+	for {
+		select {
+		case reply := <-c.replies:
+			if reply.Op == OpDefend {
+				fmt.Printf("[DHCP] MAC %d, IP %v is used by another node\n", reply.MAC, reply.IP)
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func (c *Client) request(mac byte, offer Message) (Lease, error) {
+	reply, err := c.exchange(Message{Op: OpRequest, Client: c.ID, MAC: mac, IP: offer.IP}, OpAck, OpNak)
+	if err != nil {
+		return Lease{}, err
+	}
+	if reply.Op == OpNak {
+		return Lease{}, fmt.Errorf("request for MAC %d, IP %v rejected", mac, offer.IP)
+	}
+
+	lease := Lease{
+		Client: c.ID,
+		MAC:    reply.MAC,
+		IP:     reply.IP,
+		Expiry: time.Now().Add(reply.Lease),
+	}
+	return lease, nil
+}
+
+// binds the lease and notifies OnLease
+func (c *Client) bind(lease Lease) {
+	c.mu.Lock()
+	c.lease = lease
+	c.mu.Unlock()
+
+	if c.OnLease != nil {
+		c.OnLease(lease)
+	}
+}
+
+// obtains a new lease, declining the ones another node defends
+func (c *Client) Acquire() (Lease, error) {
+	c.init()
+	for retries := 0; ; retries++ {
+		offer, err := c.exchange(Message{Op: OpDiscover, Client: c.ID}, OpOffer)
+		if err != nil {
+			return Lease{}, err
+		}
+		fmt.Printf("[DHCP] Offered MAC %d, IP %v\n", offer.MAC, offer.IP)
+		lease, err := c.request(offer.MAC, offer)
+		if err != nil {
+			return Lease{}, err
+		}
+		if !c.conflict(lease) {
+			c.bind(lease)
+			return lease, nil
+		}
+		c.decline(lease)
+		if retries+1 >= c.MaxRetries {
+			return Lease{}, fmt.Errorf("every lease offered is used by another node after %d retries", c.MaxRetries)
+		}
+	}
+}
+
+// extends the current lease, which is declined if another node defends its addresses
+func (c *Client) Renew() (Lease, error) {
+	current := c.Lease()
+	if !current.IP.IsValid() {
+		return Lease{}, fmt.Errorf("no lease to renew")
+	}
+	lease, err := c.request(current.MAC, Message{IP: current.IP})
+	if err != nil {
+		return Lease{}, err
+	}
+	if c.conflict(lease) {
+		c.decline(lease)
+		return Lease{}, fmt.Errorf("MAC %d, IP %v is used by another node, declined", lease.MAC, lease.IP)
+	}
+	c.bind(lease)
+	return lease, nil
+}
+
+// gives the current lease back to the coordinator
+func (c *Client) Release() {
+	c.init()
+	current := c.Lease()
+	c.Link.Send(Message{Op: OpRelease, Client: c.ID, MAC: current.MAC, IP: current.IP}.ToBytes())
+	c.mu.Lock()
+	c.lease = Lease{}
+	c.mu.Unlock()
+}
+
+// reports that another node uses the leased addresses and obtains a new lease
+func (c *Client) Decline() (Lease, error) {
+	c.init()
+	c.decline(c.Lease())
+	return c.Acquire()
+}
+
+func (c *Client) decline(lease Lease) {
+	c.Link.Send(Message{Op: OpDecline, Client: c.ID, MAC: lease.MAC, IP: lease.IP}.ToBytes())
+	c.mu.Lock()
+	c.lease = Lease{}
+	c.mu.Unlock()
+}
+
+func (c *Client) Lease() Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lease
+}
+
+// renews the lease when half of it has elapsed and acquires a new one if the renewal fails,
+// or if another node turns out to use the same addresses
+func (c *Client) Maintain(done <-chan struct{}) {
+	c.init()
+	for {
+		wait := c.RetryTimeout
+		if lease := c.Lease(); lease.IP.IsValid() {
+			wait = max(time.Until(lease.Expiry)/2, c.RetryTimeout)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(wait):
+		}
+
+		if _, err := c.Renew(); err != nil {
+			fmt.Printf("[DHCP] Renew failed: %v, acquiring a new lease\n", err)
+			if _, err := c.Acquire(); err != nil {
+				fmt.Printf("[DHCP] Acquire failed: %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package dhcp
+
+import (
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a shared medium which delivers every frame to every handler in order
+type medium struct {
+	handlers []func(frame []byte)
+	frames   chan []byte
+}
+
+func (m *medium) run() {
+	for frame := range m.frames {
+		for _, handle := range m.handlers {
+			handle(frame)
+		}
+	}
+}
+
+type station struct {
+	*medium
+}
+
+func (s station) Send(data []byte) {
+	s.frames <- append([]byte{}, data...)
+}
+
+func TestClientAcquire(t *testing.T) {
+
+	m := &medium{frames: make(chan []byte, 10)}
+	link := station{m}
+	defer close(m.frames)
+
+	server := Server{
+		Address: 0,
+		Pool:    netip.MustParsePrefix("172.18.1.0/24"),
+	}
+	clients := []*Client{
+		{Link: link, RetryTimeout: 100 * time.Millisecond, ProbeTimeout: 100 * time.Millisecond},
+		{Link: link, RetryTimeout: 100 * time.Millisecond, ProbeTimeout: 100 * time.Millisecond},
+	}
+
+	m.handlers = append(m.handlers, func(frame []byte) { server.Handle(link, frame) })
+	for _, c := range clients {
+		m.handlers = append(m.handlers, func(frame []byte) { c.Handle(frame) })
+	}
+
+	go m.run()
+
+	var leases [2]Lease
+	for i, c := range clients {
+		lease, err := c.Acquire()
+		if err != nil {
+			t.Fatalf("Client %d failed to acquire a lease: %v", i, err)
+		}
+		leases[i] = lease
+	}
+	if leases[0].MAC == leases[1].MAC || leases[0].IP == leases[1].IP {
+		t.Errorf("Clients got conflicting leases %v and %v", leases[0], leases[1])
+	}
+
+	renewed, err := clients[0].Renew()
+	if err != nil || renewed.MAC != leases[0].MAC || renewed.IP != leases[0].IP {
+		t.Errorf("Renewal changed the lease: %v, %v", renewed, err)
+	}
+
+	replaced, err := clients[1].Decline()
+	if err != nil {
+		t.Fatalf("Failed to acquire a lease after declining: %v", err)
+	}
+	if replaced.MAC == leases[1].MAC || replaced.IP == leases[1].IP {
+		t.Errorf("Declined lease handed out again: %v", replaced)
+	}
+}
+
+// a restarted coordinator offers the addresses of a node it does not know, which defends them
+func TestClientConflict(t *testing.T) {
+
+	m := &medium{frames: make(chan []byte, 10)}
+	link := station{m}
+	defer close(m.frames)
+
+	pool := netip.MustParsePrefix("172.18.1.0/24")
+	servers := []*Server{{Address: 0, Pool: pool}, {Address: 0, Pool: pool}, {Address: 0, Pool: pool}}
+	var current atomic.Int32
+	clients := []*Client{
+		{Link: link, RetryTimeout: 100 * time.Millisecond, ProbeTimeout: 100 * time.Millisecond},
+		{Link: link, RetryTimeout: 100 * time.Millisecond, ProbeTimeout: 100 * time.Millisecond},
+	}
+
+	m.handlers = append(m.handlers, func(frame []byte) { servers[current.Load()].Handle(link, frame) })
+	for _, c := range clients {
+		m.handlers = append(m.handlers, func(frame []byte) { c.Handle(frame) })
+	}
+
+	go m.run()
+
+	first, err := clients[0].Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Store(1)
+	second, err := clients[1].Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.MAC == second.MAC || first.IP == second.IP {
+		t.Errorf("Clients bound conflicting leases %v and %v", first, second)
+	}
+	if clients[0].Lease() != first {
+		t.Errorf("The defended lease changed to %v", clients[0].Lease())
+	}
+
+	// a renewal of addresses the other node defends, by a coordinator restarted again, is declined before it is bound
+	current.Store(2)
+	bound := 0
+	clients[1].OnLease = func(Lease) { bound++ }
+	clients[1].mu.Lock()
+	clients[1].lease = Lease{Client: clients[1].ID, MAC: first.MAC, IP: first.IP}
+	clients[1].mu.Unlock()
+	if _, err := clients[1].Renew(); err == nil || bound != 0 || clients[1].Lease().IP.IsValid() {
+		t.Errorf("The defended renewal is bound: %v, %d leases bound", err, bound)
+	}
+}
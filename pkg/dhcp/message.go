@@ -0,0 +1,109 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+const (
+	magic = 0xDC
+
+	MessageSize = 20
+
+	// the MAC address used by a node before it gets one from the coordinator
+	Unassigned = 0xff
+)
+
+type Op uint8
+
+const (
+	OpDiscover Op = iota + 1
+	OpOffer
+	OpRequest
+	OpAck
+	OpNak
+	OpRelease
+	OpDecline
+	OpProbe  // a client asks whether another node uses the addresses it is about to bind
+	OpDefend // a node holding the probed addresses answers the probing client
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpDiscover:
+		return "DISCOVER"
+	case OpOffer:
+		return "OFFER"
+	case OpRequest:
+		return "REQUEST"
+	case OpAck:
+		return "ACK"
+	case OpNak:
+		return "NAK"
+	case OpRelease:
+		return "RELEASE"
+	case OpDecline:
+		return "DECLINE"
+	case OpProbe:
+		return "PROBE"
+	case OpDefend:
+		return "DEFEND"
+	default:
+		return fmt.Sprintf("Op(%d)", uint8(o))
+	}
+}
+
+// ClientID identifies a node before it has an address
+type ClientID [8]byte
+
+// Magic (8 bit) | Op (8 bit) | Client (64 bit) | MAC (8 bit) | IP (32 bit) | PrefixLen (8 bit) | Lease in seconds (32 bit)
+type Message struct {
+	Op     Op
+	Client ClientID
+	MAC    byte
+	IP     netip.Prefix
+	Lease  time.Duration
+}
+
+func (m Message) ToBytes() []byte {
+	bytes := make([]byte, MessageSize)
+	bytes[0] = magic
+	bytes[1] = byte(m.Op)
+	copy(bytes[2:10], m.Client[:])
+	bytes[10] = m.MAC
+	if m.IP.IsValid() {
+		ip := m.IP.Addr().As4()
+		copy(bytes[11:15], ip[:])
+		bytes[15] = byte(m.IP.Bits())
+	}
+	binary.BigEndian.PutUint32(bytes[16:20], uint32(m.Lease/time.Second))
+	return bytes
+}
+
+func (m *Message) FromBytes(data []byte) error {
+	if !IsMessage(data) {
+		return fmt.Errorf("not an address assignment message")
+	}
+	m.Op = Op(data[1])
+	if m.Op < OpDiscover || m.Op > OpDefend {
+		return fmt.Errorf("invalid op %d", data[1])
+	}
+	copy(m.Client[:], data[2:10])
+	m.MAC = data[10]
+	m.IP = netip.Prefix{}
+	if ip := netip.AddrFrom4([4]byte(data[11:15])); !ip.IsUnspecified() {
+		if data[15] > 32 {
+			return fmt.Errorf("invalid prefix length %d", data[15])
+		}
+		m.IP = netip.PrefixFrom(ip, int(data[15]))
+	}
+	m.Lease = time.Duration(binary.BigEndian.Uint32(data[16:20])) * time.Second
+	return nil
+}
+
+// reports whether a frame received from the data link layer carries an address assignment message
+func IsMessage(data []byte) bool {
+	return len(data) == MessageSize && data[0] == magic
+}
@@ -0,0 +1,263 @@
+package dhcp
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxAddress = 0x6 // the compact reliable data link addresses are 3 bits long and 0x7 is the broadcast address
+
+	DefaultLeaseTime    = 10 * time.Minute
+	DefaultOfferTimeout = 10 * time.Second
+	DefaultConflictHold = 10 * time.Minute
+)
+
+type Lease struct {
+	Client ClientID
+	MAC    byte
+	IP     netip.Prefix
+	Expiry time.Time
+}
+
+// Link is the part of the data link layer used to send the messages
+type Link interface {
+	Send(data []byte)
+}
+
+// Server is the coordinator which hands out data link addresses and IPv4 leases
+type Server struct {
+	Address      byte         // the data link address of the coordinator itself, never handed out
+	MaxAddress   byte         // the largest data link address handed out, DefaultMaxAddress if 0
+	Reserved     []byte       // data link addresses of statically configured nodes
+	Pool         netip.Prefix // the IPv4 leases are taken from the hosts of this prefix
+	ReservedIPs  []netip.Addr // addresses of statically configured nodes, e.g. the gateway
+	LeaseTime    time.Duration
+	OfferTimeout time.Duration // how long an offered but not yet requested lease is kept
+	ConflictHold time.Duration // how long a declined address is not handed out
+
+	Now func() time.Time // the clock, time.Now if nil
+
+	mu       sync.Mutex
+	leases   map[ClientID]*Lease
+	declined map[any]time.Time // declined MAC addresses (byte) and IP addresses (netip.Addr)
+}
+
+func (s *Server) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+func (s *Server) init() {
+	if s.leases == nil {
+		s.leases = make(map[ClientID]*Lease)
+		s.declined = make(map[any]time.Time)
+	}
+	if s.LeaseTime == 0 {
+		s.LeaseTime = DefaultLeaseTime
+	}
+	if s.OfferTimeout == 0 {
+		s.OfferTimeout = DefaultOfferTimeout
+	}
+	if s.ConflictHold == 0 {
+		s.ConflictHold = DefaultConflictHold
+	}
+	if s.MaxAddress == 0 {
+		s.MaxAddress = DefaultMaxAddress
+	}
+}
+
+// removes the expired leases and conflict records
+func (s *Server) expire(now time.Time) {
+	for id, lease := range s.leases {
+		if now.After(lease.Expiry) {
+			fmt.Printf("[DHCP] Lease of %x expired (MAC %d, IP %v)\n", id, lease.MAC, lease.IP)
+			delete(s.leases, id)
+		}
+	}
+	for key, until := range s.declined {
+		if now.After(until) {
+			delete(s.declined, key)
+		}
+	}
+}
+
+// returns the client holding the MAC address or the IP address other than the given client
+func (s *Server) holder(client ClientID, mac byte, ip netip.Addr) (ClientID, bool) {
+	for id, lease := range s.leases {
+		if id != client && (lease.MAC == mac || lease.IP.Addr() == ip) {
+			return id, true
+		}
+	}
+	return ClientID{}, false
+}
+
+func (s *Server) macAvailable(client ClientID, mac byte) bool {
+	if mac > s.MaxAddress || mac == Unassigned || mac == s.Address || slices.Contains(s.Reserved, mac) {
+		return false
+	}
+	if _, ok := s.declined[mac]; ok {
+		return false
+	}
+	for id, lease := range s.leases {
+		if id != client && lease.MAC == mac {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) ipAvailable(client ClientID, ip netip.Addr) bool {
+	if !s.Pool.Contains(ip) || ip == s.Pool.Masked().Addr() || ip == broadcast(s.Pool) || slices.Contains(s.ReservedIPs, ip) {
+		return false
+	}
+	if _, ok := s.declined[ip]; ok {
+		return false
+	}
+	for id, lease := range s.leases {
+		if id != client && lease.IP.Addr() == ip {
+			return false
+		}
+	}
+	return true
+}
+
+func broadcast(prefix netip.Prefix) netip.Addr {
+	ip := prefix.Masked().Addr().As4()
+	for i := prefix.Bits(); i < 32; i++ {
+		ip[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom4(ip)
+}
+
+func (s *Server) allocate(client ClientID) (mac byte, ip netip.Addr, err error) {
+	found := false
+	for mac = 0; mac <= s.MaxAddress && mac != Unassigned; mac++ {
+		if s.macAvailable(client, mac) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return 0, ip, fmt.Errorf("no data link address available")
+	}
+	for ip = s.Pool.Masked().Addr().Next(); s.Pool.Contains(ip); ip = ip.Next() {
+		if s.ipAvailable(client, ip) {
+			return mac, ip, nil
+		}
+	}
+	return 0, ip, fmt.Errorf("no IP address available in %v", s.Pool)
+}
+
+// handles a message from a client and returns the reply if there is one
+func (s *Server) HandleMessage(m Message) (reply Message, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	now := s.now()
+	s.expire(now)
+
+	reply = Message{Client: m.Client}
+
+	switch m.Op {
+	case OpDiscover:
+		lease, exists := s.leases[m.Client]
+		if !exists {
+			mac, ip, err := s.allocate(m.Client)
+			if err != nil {
+				fmt.Printf("[DHCP] Cannot offer a lease to %x: %v\n", m.Client, err)
+				return reply, false
+			}
+			lease = &Lease{
+				Client: m.Client,
+				MAC:    mac,
+				IP:     netip.PrefixFrom(ip, s.Pool.Bits()),
+				Expiry: now.Add(s.OfferTimeout),
+			}
+			s.leases[m.Client] = lease
+		}
+		reply.Op = OpOffer
+		reply.MAC = lease.MAC
+		reply.IP = lease.IP
+		reply.Lease = s.LeaseTime
+
+	case OpRequest:
+		if !m.IP.IsValid() || !s.macAvailable(m.Client, m.MAC) || !s.ipAvailable(m.Client, m.IP.Addr()) {
+			if id, conflict := s.holder(m.Client, m.MAC, m.IP.Addr()); conflict {
+				fmt.Printf("[DHCP] %x requested MAC %d, IP %v held by %x\n", m.Client, m.MAC, m.IP, id)
+			}
+			reply.Op = OpNak
+			return reply, true
+		}
+		lease := &Lease{
+			Client: m.Client,
+			MAC:    m.MAC,
+			IP:     netip.PrefixFrom(m.IP.Addr(), s.Pool.Bits()),
+			Expiry: now.Add(s.LeaseTime),
+		}
+		s.leases[m.Client] = lease
+		reply.Op = OpAck
+		reply.MAC = lease.MAC
+		reply.IP = lease.IP
+		reply.Lease = s.LeaseTime
+		fmt.Printf("[DHCP] Leased MAC %d, IP %v to %x\n", lease.MAC, lease.IP, m.Client)
+
+	case OpRelease:
+		if lease, exists := s.leases[m.Client]; exists && lease.MAC == m.MAC {
+			delete(s.leases, m.Client)
+			fmt.Printf("[DHCP] %x released MAC %d, IP %v\n", m.Client, lease.MAC, lease.IP)
+		}
+		return reply, false
+
+	case OpDecline:
+		// the client found another node using the address
+		delete(s.leases, m.Client)
+		s.declined[m.MAC] = now.Add(s.ConflictHold)
+		if m.IP.IsValid() {
+			s.declined[m.IP.Addr()] = now.Add(s.ConflictHold)
+		}
+		fmt.Printf("[DHCP] %x declined MAC %d, IP %v due to a conflict\n", m.Client, m.MAC, m.IP)
+		return reply, false
+
+	default:
+		return reply, false
+	}
+
+	return reply, true
+}
+
+// handles a frame from the data link layer, returns false if it is not an address assignment message
+func (s *Server) Handle(link Link, frame []byte) bool {
+	if !IsMessage(frame) {
+		return false
+	}
+	var m Message
+	if err := m.FromBytes(frame); err != nil {
+		fmt.Printf("[DHCP] Invalid message: %v\n", err)
+		return true
+	}
+	if reply, ok := s.HandleMessage(m); ok {
+		link.Send(reply.ToBytes())
+	}
+	return true
+}
+
+// returns a snapshot of the current leases
+func (s *Server) Leases() []Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	s.expire(s.now())
+	leases := make([]Lease, 0, len(s.leases))
+	for _, lease := range s.leases {
+		leases = append(leases, *lease)
+	}
+	return leases
+}
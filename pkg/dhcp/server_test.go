@@ -0,0 +1,88 @@
+package dhcp
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestServerAllocation(t *testing.T) {
+
+	now := time.Unix(0, 0)
+	s := Server{
+		Address:     0,
+		Reserved:    []byte{1},
+		Pool:        netip.MustParsePrefix("172.18.1.0/24"),
+		ReservedIPs: []netip.Addr{netip.MustParseAddr("172.18.1.1")},
+		LeaseTime:   time.Minute,
+		Now:         func() time.Time { return now },
+	}
+
+	lease := func(id byte) Message {
+		offer, ok := s.HandleMessage(Message{Op: OpDiscover, Client: ClientID{id}})
+		if !ok || offer.Op != OpOffer {
+			t.Fatalf("Expected an offer, got %v", offer.Op)
+		}
+		ack, ok := s.HandleMessage(Message{Op: OpRequest, Client: ClientID{id}, MAC: offer.MAC, IP: offer.IP})
+		if !ok || ack.Op != OpAck {
+			t.Fatalf("Expected an ack, got %v", ack.Op)
+		}
+		return ack
+	}
+
+	a := lease(1)
+	b := lease(2)
+	if a.MAC != 2 || a.IP.String() != "172.18.1.2/24" {
+		t.Errorf("Unexpected first lease MAC %d, IP %v", a.MAC, a.IP)
+	}
+	if b.MAC == a.MAC || b.IP == a.IP {
+		t.Errorf("Leases conflict: %v and %v", a, b)
+	}
+
+	// the same client gets the same lease again
+	if again, _ := s.HandleMessage(Message{Op: OpDiscover, Client: ClientID{1}}); again.MAC != a.MAC || again.IP != a.IP {
+		t.Errorf("Expected the existing lease to be offered, got MAC %d, IP %v", again.MAC, again.IP)
+	}
+
+	// requesting addresses held by another client is rejected
+	if nak, _ := s.HandleMessage(Message{Op: OpRequest, Client: ClientID{3}, MAC: a.MAC, IP: a.IP}); nak.Op != OpNak {
+		t.Errorf("Expected a nak for a conflicting request, got %v", nak.Op)
+	}
+
+	// declined addresses are held back
+	s.HandleMessage(Message{Op: OpDecline, Client: ClientID{2}, MAC: b.MAC, IP: b.IP})
+	if c := lease(3); c.MAC == b.MAC || c.IP == b.IP {
+		t.Errorf("Declined address handed out again: MAC %d, IP %v", c.MAC, c.IP)
+	}
+
+	// leases expire unless renewed
+	now = now.Add(45 * time.Second)
+	if renew, _ := s.HandleMessage(Message{Op: OpRequest, Client: ClientID{1}, MAC: a.MAC, IP: a.IP}); renew.Op != OpAck {
+		t.Errorf("Expected the renewal to succeed, got %v", renew.Op)
+	}
+	now = now.Add(45 * time.Second)
+	leases := s.Leases()
+	if len(leases) != 1 || leases[0].Client != (ClientID{1}) {
+		t.Errorf("Expected only the renewed lease to remain, got %v", leases)
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	m := Message{
+		Op:     OpAck,
+		Client: ClientID{1, 2, 3, 4, 5, 6, 7, 8},
+		MAC:    5,
+		IP:     netip.MustParsePrefix("10.0.0.7/24"),
+		Lease:  90 * time.Second,
+	}
+	var decoded Message
+	if err := decoded.FromBytes(m.ToBytes()); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != m {
+		t.Errorf("Expected %v, got %v", m, decoded)
+	}
+	if IsMessage([]byte{0x01, 0x02}) {
+		t.Errorf("Arbitrary data recognised as a message")
+	}
+}
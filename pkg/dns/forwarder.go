@@ -0,0 +1,247 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	DefaultTimeout     = 2 * time.Second
+	DefaultHostTTL     = 60 * time.Second
+	DefaultNegativeTTL = 30 * time.Second
+	DefaultMaxEntries  = 1024
+
+	maxMessageSize = 1500
+)
+
+type question struct {
+	Name  string
+	Type  layers.DNSType
+	Class layers.DNSClass
+}
+
+type entry struct {
+	response []byte // the raw response from the upstream
+	stored   time.Time
+	expiry   time.Time
+}
+
+// Forwarder answers DNS queries from its cache and static hosts, and forwards the misses to the upstream
+type Forwarder struct {
+	Upstream    string                // the address of the upstream server, e.g. "1.1.1.1:53"
+	Hosts       map[string]netip.Addr // static host overrides
+	Timeout     time.Duration
+	HostTTL     time.Duration // the TTL of the answers from Hosts
+	NegativeTTL time.Duration // how long non-existent names are cached
+	MaxEntries  int
+
+	Now func() time.Time // the clock, time.Now if nil
+
+	mu           sync.Mutex
+	cache        map[question]entry
+	hits, misses int
+}
+
+func (f *Forwarder) now() time.Time {
+	if f.Now == nil {
+		return time.Now()
+	}
+	return f.Now()
+}
+
+func (f *Forwarder) init() {
+	if f.cache == nil {
+		f.cache = make(map[question]entry)
+	}
+	if f.Timeout == 0 {
+		f.Timeout = DefaultTimeout
+	}
+	if f.HostTTL == 0 {
+		f.HostTTL = DefaultHostTTL
+	}
+	if f.NegativeTTL == 0 {
+		f.NegativeTTL = DefaultNegativeTTL
+	}
+	if f.MaxEntries == 0 {
+		f.MaxEntries = DefaultMaxEntries
+	}
+}
+
+func normalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// answers a DNS query message and returns the response message
+func (f *Forwarder) Resolve(query []byte) ([]byte, error) {
+
+	var dns layers.DNS
+	if err := dns.DecodeFromBytes(query, gopacket.NilDecodeFeedback); err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+	if dns.QR || len(dns.Questions) != 1 {
+		return nil, fmt.Errorf("expected a query with exactly one question")
+	}
+
+	q := dns.Questions[0]
+	key := question{Name: normalize(string(q.Name)), Type: q.Type, Class: q.Class}
+
+	f.mu.Lock()
+	f.init()
+	if ip, ok := f.Hosts[key.Name]; ok && q.Type == layers.DNSTypeA && ip.Is4() {
+		f.hits++
+		f.mu.Unlock()
+		return f.answerHost(&dns, ip)
+	}
+	now := f.now()
+	cached, ok := f.cache[key]
+	if ok && now.Before(cached.expiry) {
+		f.hits++
+		f.mu.Unlock()
+		return fromCache(cached, dns.ID, now)
+	}
+	delete(f.cache, key)
+	f.misses++
+	f.mu.Unlock()
+
+	response, err := f.forward(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl, ok := f.cacheTTL(response); ok {
+		f.mu.Lock()
+		if len(f.cache) >= f.MaxEntries {
+			f.evict(now)
+		}
+		f.cache[key] = entry{response: response, stored: now, expiry: now.Add(ttl)}
+		f.mu.Unlock()
+	}
+	return response, nil
+}
+
+func (f *Forwarder) forward(query []byte) ([]byte, error) {
+	conn, err := net.Dial("udp", f.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to upstream: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("failed to send query to upstream: %v", err)
+	}
+	buffer := make([]byte, maxMessageSize)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("no response from upstream: %v", err)
+	}
+	return buffer[:n], nil
+}
+
+// determines how long the response can be cached
+func (f *Forwarder) cacheTTL(response []byte) (time.Duration, bool) {
+	var dns layers.DNS
+	if err := dns.DecodeFromBytes(response, gopacket.NilDecodeFeedback); err != nil {
+		return 0, false
+	}
+	switch dns.ResponseCode {
+	case layers.DNSResponseCodeNoErr:
+		if len(dns.Answers) == 0 {
+			return f.NegativeTTL, true
+		}
+		ttl := dns.Answers[0].TTL
+		for _, answer := range dns.Answers {
+			ttl = min(ttl, answer.TTL)
+		}
+		return time.Duration(ttl) * time.Second, ttl > 0
+	case layers.DNSResponseCodeNXDomain:
+		return f.NegativeTTL, true
+	}
+	return 0, false
+}
+
+// removes the expired entries, or the one closest to expiry if none has expired
+func (f *Forwarder) evict(now time.Time) {
+	var oldest question
+	var oldestExpiry time.Time
+	for key, e := range f.cache {
+		if now.After(e.expiry) {
+			delete(f.cache, key)
+		} else if oldestExpiry.IsZero() || e.expiry.Before(oldestExpiry) {
+			oldest, oldestExpiry = key, e.expiry
+		}
+	}
+	if len(f.cache) >= f.MaxEntries {
+		delete(f.cache, oldest)
+	}
+}
+
+func fromCache(cached entry, id uint16, now time.Time) ([]byte, error) {
+	var dns layers.DNS
+	if err := dns.DecodeFromBytes(cached.response, gopacket.NilDecodeFeedback); err != nil {
+		return nil, err
+	}
+	elapsed := uint32(now.Sub(cached.stored) / time.Second)
+	for _, records := range [][]layers.DNSResourceRecord{dns.Answers, dns.Authorities, dns.Additionals} {
+		for i := range records {
+			if records[i].Type == layers.DNSTypeOPT {
+				continue
+			}
+			records[i].TTL -= min(records[i].TTL, elapsed)
+		}
+	}
+	dns.ID = id
+	return serialize(&dns)
+}
+
+func (f *Forwarder) answerHost(query *layers.DNS, ip netip.Addr) ([]byte, error) {
+	q := query.Questions[0]
+	response := layers.DNS{
+		ID:           query.ID,
+		QR:           true,
+		OpCode:       query.OpCode,
+		AA:           true,
+		RD:           query.RD,
+		RA:           true,
+		ResponseCode: layers.DNSResponseCodeNoErr,
+		Questions:    []layers.DNSQuestion{q},
+		Answers: []layers.DNSResourceRecord{{
+			Name:  q.Name,
+			Type:  layers.DNSTypeA,
+			Class: layers.DNSClassIN,
+			TTL:   uint32(f.HostTTL / time.Second),
+			IP:    ip.AsSlice(),
+		}},
+	}
+	return serialize(&response)
+}
+
+func serialize(dns *layers.DNS) ([]byte, error) {
+	buffer := gopacket.NewSerializeBuffer()
+	err := dns.SerializeTo(buffer, gopacket.SerializeOptions{FixLengths: true})
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// clears the cache
+func (f *Forwarder) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cache = nil
+}
+
+// returns the number of queries answered locally and forwarded to the upstream
+func (f *Forwarder) Stats() (hits, misses int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hits, f.misses
+}
@@ -0,0 +1,177 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// starts a stub DNS server which answers every A query with 10.0.0.1 and counts the queries
+func startStub(t *testing.T, ttl uint32) (addr string, queries *atomic.Int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	queries = &atomic.Int32{}
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			queries.Add(1)
+			var query layers.DNS
+			if err := query.DecodeFromBytes(buffer[:n], gopacket.NilDecodeFeedback); err != nil {
+				continue
+			}
+			response := layers.DNS{
+				ID:        query.ID,
+				QR:        true,
+				RD:        query.RD,
+				RA:        true,
+				Questions: query.Questions,
+			}
+			if string(query.Questions[0].Name) == "missing.example" {
+				response.ResponseCode = layers.DNSResponseCodeNXDomain
+			} else {
+				response.Answers = []layers.DNSResourceRecord{{
+					Name:  query.Questions[0].Name,
+					Type:  layers.DNSTypeA,
+					Class: layers.DNSClassIN,
+					TTL:   ttl,
+					IP:    net.IPv4(10, 0, 0, 1),
+				}}
+			}
+			data, _ := serialize(&response)
+			conn.WriteTo(data, from)
+		}
+	}()
+	return conn.LocalAddr().String(), queries
+}
+
+func makeQuery(t *testing.T, id uint16, name string) []byte {
+	data, err := serialize(&layers.DNS{
+		ID: id,
+		RD: true,
+		Questions: []layers.DNSQuestion{{
+			Name:  []byte(name),
+			Type:  layers.DNSTypeA,
+			Class: layers.DNSClassIN,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func parse(t *testing.T, data []byte) *layers.DNS {
+	var dns layers.DNS
+	if err := dns.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	return &dns
+}
+
+func TestForwarderCache(t *testing.T) {
+
+	upstream, queries := startStub(t, 100)
+	now := time.Unix(0, 0)
+	f := Forwarder{
+		Upstream: upstream,
+		Hosts:    map[string]netip.Addr{"gateway.aethernet": netip.MustParseAddr("172.18.1.1")},
+		Now:      func() time.Time { return now },
+	}
+
+	response, err := f.Resolve(makeQuery(t, 1, "example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dns := parse(t, response); len(dns.Answers) != 1 || !dns.Answers[0].IP.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("Unexpected response %v", dns.Answers)
+	}
+
+	// answered from the cache with the new ID and the remaining TTL
+	now = now.Add(40 * time.Second)
+	response, err = f.Resolve(makeQuery(t, 2, "EXAMPLE.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns := parse(t, response)
+	if dns.ID != 2 || dns.Answers[0].TTL != 60 {
+		t.Errorf("Expected ID 2 and TTL 60 from the cache, got ID %d and TTL %d", dns.ID, dns.Answers[0].TTL)
+	}
+	if queries.Load() != 1 {
+		t.Errorf("Expected 1 upstream query, got %d", queries.Load())
+	}
+
+	// expired entries are forwarded again
+	now = now.Add(61 * time.Second)
+	f.Resolve(makeQuery(t, 3, "example.com"))
+	if queries.Load() != 2 {
+		t.Errorf("Expected 2 upstream queries after expiry, got %d", queries.Load())
+	}
+
+	// non-existent names are cached too
+	f.Resolve(makeQuery(t, 4, "missing.example"))
+	response, _ = f.Resolve(makeQuery(t, 5, "missing.example"))
+	if dns := parse(t, response); dns.ResponseCode != layers.DNSResponseCodeNXDomain || queries.Load() != 3 {
+		t.Errorf("Expected a cached NXDOMAIN, got %v after %d queries", dns.ResponseCode, queries.Load())
+	}
+
+	// static hosts never reach the upstream
+	response, _ = f.Resolve(makeQuery(t, 6, "gateway.aethernet"))
+	if dns := parse(t, response); len(dns.Answers) != 1 || !dns.Answers[0].IP.Equal(net.IPv4(172, 18, 1, 1)) {
+		t.Errorf("Unexpected response for a static host %v", dns.Answers)
+	}
+	if hits, misses := f.Stats(); hits != 3 || misses != 3 {
+		t.Errorf("Expected 3 hits and 3 misses, got %d and %d", hits, misses)
+	}
+}
+
+func TestForwarderPacket(t *testing.T) {
+
+	upstream, _ := startStub(t, 100)
+	f := Forwarder{Upstream: upstream}
+
+	buffer := gopacket.NewSerializeBuffer()
+	ipv4 := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IPv4(172, 18, 1, 2),
+		DstIP:    net.IPv4(1, 1, 1, 1),
+	}
+	udp := &layers.UDP{SrcPort: 5353, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ipv4)
+	gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		ipv4, udp, gopacket.Payload(makeQuery(t, 7, "example.com")))
+
+	packet := gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	if !IsQuery(packet) {
+		t.Fatal("Expected the packet to be recognised as a DNS query")
+	}
+	reply, err := f.HandlePacket(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replyPacket := gopacket.NewPacket(reply, layers.LayerTypeIPv4, gopacket.Default)
+	replyIPv4 := replyPacket.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	replyUDP := replyPacket.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !replyIPv4.DstIP.Equal(ipv4.SrcIP) || replyUDP.DstPort != 5353 || replyUDP.SrcPort != 53 {
+		t.Errorf("Reply is not addressed to the client: %v", replyPacket)
+	}
+	if dns := parse(t, replyUDP.Payload); dns.ID != 7 || len(dns.Answers) != 1 {
+		t.Errorf("Unexpected DNS reply %v", dns)
+	}
+}
@@ -0,0 +1,53 @@
+package dns
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// IsQuery reports whether the IP packet carries a DNS query to a server on port 53
+func IsQuery(packet gopacket.Packet) bool {
+	udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	return ok && udp.DstPort == 53 && packet.Layer(layers.LayerTypeIPv4) != nil
+}
+
+// answers the DNS query carried by an IPv4 packet and returns the IPv4 packet of the response
+func (f *Forwarder) HandlePacket(packet gopacket.Packet) ([]byte, error) {
+	ipv4 := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	udp := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+
+	response, err := f.Resolve(udp.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	replyIPv4 := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    ipv4.DstIP,
+		DstIP:    ipv4.SrcIP,
+	}
+	replyUDP := &layers.UDP{
+		SrcPort: udp.DstPort,
+		DstPort: udp.SrcPort,
+	}
+	replyUDP.SetNetworkLayerForChecksum(replyIPv4)
+
+	buffer := gopacket.NewSerializeBuffer()
+	err = gopacket.SerializeLayers(
+		buffer,
+		gopacket.SerializeOptions{
+			FixLengths:       true,
+			ComputeChecksums: true,
+		},
+		replyIPv4,
+		replyUDP,
+		gopacket.Payload(response),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package layers
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Compression selects how the data link layers compress their messages.
+// When it is not CompressionNone, every message starts with a flag byte telling which codec was actually used,
+// so all the nodes on a segment must agree on whether compression is enabled.
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionDeflate
+	CompressionDictionary // DEFLATE with a preset dictionary of the strings common in small packets
+)
+
+// the preset dictionary, later strings are cheaper to refer to so the most common ones are at the end
+var compressionDictionary = []byte(
+	"application/json; charset=utf-8text/plain; charset=utf-8text/html; charset=utf-8" +
+		"Accept-Encoding: gzip, deflate\r\nAccept-Language: en-US,en;q=0.9\r\nConnection: keep-alive\r\n" +
+		"Cache-Control: no-cache\r\nUser-Agent: curl/8.0\r\nAccept: */*\r\nContent-Length: Content-Type: " +
+		"Server: Date: Location: https://http://www.example.com.org.net.cn" +
+		"HTTP/1.1 404 Not Found\r\nHTTP/1.1 301 Moved Permanently\r\nHTTP/1.1 200 OK\r\n" +
+		"GET / HTTP/1.1\r\nHost: POST / HTTP/1.1\r\n\r\n" +
+		"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x00\x01\x00\x01\x00\x00\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00" +
+		"the of and to in is that for it with as was on be by this are from",
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionDeflate:
+		return "deflate"
+	case CompressionDictionary:
+		return "dictionary"
+	default:
+		return fmt.Sprintf("Compression(%d)", uint8(c))
+	}
+}
+
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return CompressionNone, nil
+	case "deflate":
+		return CompressionDeflate, nil
+	case "dictionary", "dict":
+		return CompressionDictionary, nil
+	default:
+		return CompressionNone, fmt.Errorf("unknown compression %s, expected 'none', 'deflate' or 'dictionary'", name)
+	}
+}
+
+func (c Compression) compress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	var w *flate.Writer
+	var err error
+	switch c {
+	case CompressionDeflate:
+		w, err = flate.NewWriter(&buffer, flate.BestCompression)
+	case CompressionDictionary:
+		w, err = flate.NewWriterDict(&buffer, flate.BestCompression, compressionDictionary)
+	default:
+		return nil, fmt.Errorf("unknown compression %d", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// compresses the message and prefixes the flag byte, the message is kept as is when compression does not help
+func (c Compression) Encode(data []byte) []byte {
+	if c != CompressionNone {
+		compressed, err := c.compress(data)
+		if err == nil && len(compressed) < len(data) {
+			return append([]byte{byte(c)}, compressed...)
+		}
+	}
+	return append([]byte{byte(CompressionNone)}, data...)
+}
+
+// the largest IP packet, which bounds the messages of the naive data link layer
+const MaxPacketSize = 0xffff
+
+// restores a message produced by Encode, a message inflating past the limit is refused
+func DecodeCompressed(data []byte, limit int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("compressed message is empty")
+	}
+
+	var r io.ReadCloser
+	switch Compression(data[0]) {
+	case CompressionNone:
+		return data[1:], nil
+	case CompressionDeflate:
+		r = flate.NewReader(bytes.NewReader(data[1:]))
+	case CompressionDictionary:
+		r = flate.NewReaderDict(bytes.NewReader(data[1:]), compressionDictionary)
+	default:
+		return nil, fmt.Errorf("unknown compression flag %d", data[0])
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("compressed message inflates past %d bytes", limit)
+	}
+	return data, nil
+}
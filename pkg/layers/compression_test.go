@@ -0,0 +1,150 @@
+package layers
+
+import (
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/fixed"
+	"Aethernet/pkg/modem"
+	"bytes"
+	"crypto/rand"
+	"sync/atomic"
+	"testing"
+)
+
+var compressiblePayload = []byte("HTTP/1.1 200 OK\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Length: 1256\r\n" +
+	"Connection: keep-alive\r\n" +
+	"Cache-Control: no-cache\r\n\r\n" +
+	"<!doctype html><html><head><title>Example Domain</title></head>" +
+	"<body><div><h1>Example Domain</h1><p>This domain is for use in illustrative examples in documents. " +
+	"You may use this domain in literature without prior coordination or asking for permission.</p>" +
+	"<p><a href=\"https://www.iana.org/domains/example\">More information...</a></p></div></body></html>")
+
+func TestCompression(t *testing.T) {
+
+	random := make([]byte, 200)
+	rand.Read(random)
+
+	for _, c := range []Compression{CompressionNone, CompressionDeflate, CompressionDictionary} {
+		for _, data := range [][]byte{compressiblePayload, random, {}} {
+			encoded := c.Encode(data)
+			decoded, err := DecodeCompressed(encoded, MaxPacketSize)
+			if err != nil {
+				t.Fatalf("[%v] Failed to decode: %v", c, err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("[%v] Decoded data differs from the original", c)
+			}
+			if len(encoded) > len(data)+1 {
+				t.Errorf("[%v] Encoded %d bytes to %d bytes, expected at most one extra byte", c, len(data), len(encoded))
+			}
+		}
+		t.Logf("[%v] %d bytes encoded to %d bytes", c, len(compressiblePayload), len(c.Encode(compressiblePayload)))
+	}
+
+	if Compression(CompressionDeflate).Encode(random)[0] != byte(CompressionNone) {
+		t.Errorf("Incompressible data should be sent uncompressed")
+	}
+
+	largest := make([]byte, MaxPacketSize)
+	if decoded, err := DecodeCompressed(Compression(CompressionDeflate).Encode(largest), MaxPacketSize); err != nil || len(decoded) != MaxPacketSize {
+		t.Errorf("The largest packet is not restored: %v", err)
+	}
+	if _, err := DecodeCompressed(Compression(CompressionDeflate).Encode(make([]byte, 4*MaxPacketSize)), MaxPacketSize); err == nil {
+		t.Errorf("A message inflating past %d bytes should be refused", MaxPacketSize)
+	}
+}
+
+func BenchmarkCompressionAirtime(b *testing.B) {
+
+	const (
+		SAMPLE_RATE = 48000
+
+		PHYSICAL_BYTE_PER_FRAME = 125
+		FRAME_INTERVAL          = 256
+		CARRIER_SIZE            = 2
+
+		INPUT_BUFFER_SIZE  = 10000
+		OUTPUT_BUFFER_SIZE = 10
+
+		POWER_THRESHOLD = 30
+
+		POWER_MONITOR_THRESHOLD = 0.4
+		POWER_MONITOR_WINDOW    = 10
+	)
+
+	var preamble = modem.DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+
+	for _, c := range []Compression{CompressionNone, CompressionDeflate, CompressionDictionary} {
+		b.Run(c.String(), func(b *testing.B) {
+
+			var ticks atomic.Int64
+			network := device.Network[string]{
+				Config: device.NetworkConfig[string]{
+					{In: "w", Out: "w"},
+					{In: "w", Out: "w"},
+				},
+				SampleRate: SAMPLE_RATE,
+				LateUpdate: func() { ticks.Add(1) },
+			}
+			devices := network.Build()
+
+			var layers [2]NaiveDataLinkLayer
+			for i := range layers {
+				layers[i] = NaiveDataLinkLayer{
+					PhysicalLayer: PhysicalLayer{
+						Device: devices[i],
+						Decoder: Decoder{
+							Demodulator: modem.Demodulator{
+								Preamble:                 preamble,
+								CarrierSize:              CARRIER_SIZE,
+								DemodulatePowerThreshold: fixed.FromFloat(POWER_THRESHOLD),
+								BufferSize:               OUTPUT_BUFFER_SIZE,
+							},
+							BufferSize: INPUT_BUFFER_SIZE,
+						},
+						Encoder: Encoder{
+							Modulator: modem.Modulator{
+								Preamble:      preamble,
+								CarrierSize:   CARRIER_SIZE,
+								BytePerFrame:  PHYSICAL_BYTE_PER_FRAME,
+								FrameInterval: FRAME_INTERVAL,
+							},
+							BufferSize: OUTPUT_BUFFER_SIZE,
+						},
+						PowerMonitor: PowerMonitor{
+							Threshold:  fixed.FromFloat(POWER_MONITOR_THRESHOLD),
+							WindowSize: POWER_MONITOR_WINDOW,
+						},
+					},
+					Address:     byte(i + 1),
+					BufferSize:  OUTPUT_BUFFER_SIZE,
+					Compression: c,
+				}
+				layers[i].Open()
+				defer layers[i].Close()
+			}
+
+			// the samples actually modulated for the frame, the address byte included
+			encoded := c.Encode(compressiblePayload)
+			airtime := len(layers[0].Encoder.Modulator.Modulate(append([]byte{layers[0].Address}, encoded...)))
+			samples := int64(0)
+
+			b.ResetTimer()
+			for range b.N {
+				start := ticks.Load()
+				layers[0].Send(compressiblePayload)
+				received := layers[1].Receive()
+				samples += (ticks.Load() - start) * device.BufferSize
+				if !bytes.Equal(received, compressiblePayload) {
+					b.Fatal("Received packet does not match the sent packet")
+				}
+			}
+
+			b.ReportMetric(float64(len(encoded)), "bytes_on_air/op")
+			b.ReportMetric(float64(airtime), "samples_on_air/op")
+			b.ReportMetric(float64(airtime)/SAMPLE_RATE*1000, "airtime_ms/op")
+			b.ReportMetric(float64(samples)/float64(b.N)/SAMPLE_RATE*1000, "latency_ms/op")
+		})
+	}
+}
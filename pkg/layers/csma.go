@@ -0,0 +1,206 @@
+package layers
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+// CSMA is the carrier sense multiple access with collision avoidance of the PhysicalLayer, timed in samples of the device.
+// A frame waits for the medium to be idle for DIFS and then counts down its backoff slots, which freezes while the medium is busy,
+// while the replies like the ACKs only wait for SIFS so they get the medium before any new frame.
+// The backoff is drawn from the contention window, which doubles on each failure reported by the data link layer and resets on success.
+// The NAV set by the RTS and CTS of others keeps the medium busy for the frames, not for the replies, until the reservation ends.
+type CSMA struct {
+	DIFS      int // samples of idle medium before a frame, longer than SIFS
+	SIFS      int // samples of idle medium before a reply
+	Slot      int // samples per backoff slot, the frames are sent after DIFS without backoff if not positive
+	MinWindow int // the contention window in slots after a success
+	MaxWindow int // the largest contention window in slots
+
+	mutex   sync.Mutex
+	window  int
+	idle    int // number of consecutive idle samples
+	free    int // number of consecutive idle samples out of the NAV
+	nav     int // the remaining samples reserved by others
+	pending []*access
+	stats   CSMAStats
+}
+
+type CSMAStats struct {
+	Attempts int // frames granted the medium, the replies excluded
+	Failures int // failures reported by the data link layer, mostly collisions
+}
+
+// a frame waiting for the medium
+type access struct {
+	frame   EncoderFrame
+	space   int // DIFS or SIFS
+	slots   int // the remaining backoff slots
+	elapsed int // samples of the current slot
+	reply   bool
+}
+
+// the contention window doubled for the next attempt
+func (c *CSMA) Failure() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.window = min(max(c.window, c.MinWindow)*2, c.MaxWindow)
+	c.stats.Failures++
+}
+
+// the contention window reset for the next frame
+func (c *CSMA) Success() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.window = c.MinWindow
+}
+
+func (c *CSMA) Stats() CSMAStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stats
+}
+
+// the collision rate, i.e. the failures per attempt
+func (s CSMAStats) FailureRate() float64 {
+	if s.Attempts == 0 {
+		return 0
+	}
+	return float64(s.Failures) / float64(s.Attempts)
+}
+
+// queues the frame until it is granted the medium, its Done channel tells whether it has been sent or cancelled
+func (c *CSMA) request(frame EncoderFrame, reply bool) <-chan bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	a := &access{frame: frame, space: c.DIFS, reply: reply}
+	if reply {
+		a.space = c.SIFS
+	} else {
+		c.window = max(c.window, c.MinWindow)
+		if c.window > 0 && c.Slot > 0 {
+			a.slots = rand.Intn(c.window)
+		}
+	}
+	c.pending = append(c.pending, a)
+	return frame.Done
+}
+
+// sets the NAV, the virtual carrier sense, for the given samples unless it is already longer
+func (c *CSMA) Reserve(samples int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.nav = max(c.nav, samples)
+}
+
+// whether the medium is reserved by others
+func (c *CSMA) Reserved() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.nav > 0
+}
+
+// cancels the frames waiting for the medium
+func (c *CSMA) cancel(encoder *Encoder) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, a := range c.pending {
+		encoder.finish(a.frame, false)
+	}
+	c.pending = nil
+}
+
+// drops a frame waiting for the medium, whose sender is notified by the encoder
+func (c *CSMA) remove(done <-chan bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pending = slices.DeleteFunc(c.pending, func(a *access) bool { return a.frame.Done == done })
+}
+
+// senses the medium on every received sample and passes the frames granted the medium to the encoder
+func (c *CSMA) Update(in []int32, monitor *PowerMonitor, encoder *Encoder) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, sample := range in {
+		busy := monitor.update(sample)
+		reserved := c.nav > 0
+		if reserved {
+			c.nav--
+		}
+		if busy {
+			c.idle = 0
+		} else {
+			c.idle++
+		}
+		if busy || reserved {
+			c.free = 0
+		} else {
+			c.free++
+		}
+		for _, a := range c.pending {
+			if busy || reserved && !a.reply {
+				a.elapsed = 0
+			}
+		}
+		if !busy {
+			c.tick(encoder)
+		}
+	}
+	monitor.notify()
+}
+
+func (c *CSMA) tick(encoder *Encoder) {
+	for i := 0; i < len(c.pending); i++ {
+		a := c.pending[i]
+		idle := c.free
+		if a.reply {
+			idle = c.idle
+		}
+		if idle < a.space {
+			continue
+		}
+		// the slots count down after the interframe space
+		if idle > a.space && a.slots > 0 {
+			a.elapsed++
+			if a.elapsed == c.Slot {
+				a.slots--
+				a.elapsed = 0
+			}
+		}
+		if a.slots > 0 {
+			continue
+		}
+		if !encoder.push(a.frame) {
+			// the encoder is still busy with the previous frame
+			continue
+		}
+		if !a.reply {
+			c.stats.Attempts++
+		}
+		c.pending = append(c.pending[:i], c.pending[i+1:]...)
+		i--
+	}
+}
+
+// ExponentialBackoffTimer draws the backoff from a contention window of slots that doubles on every retry,
+// for the data link layers without CSMA
+type ExponentialBackoffTimer struct {
+	Slot      time.Duration
+	MinWindow int // the contention window in slots of the first retry
+	MaxWindow int
+}
+
+func (b ExponentialBackoffTimer) GetBackoffTime(retries int) time.Duration {
+	window := b.MinWindow
+	for range retries {
+		if window >= b.MaxWindow {
+			break
+		}
+		window *= 2
+	}
+	window = max(min(window, b.MaxWindow), 1)
+	return b.Slot * time.Duration(rand.Intn(window))
+}
@@ -0,0 +1,319 @@
+package layers
+
+import (
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/fixed"
+	"Aethernet/pkg/modem"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCSMA(t *testing.T) {
+	const (
+		DIFS = 100
+		SIFS = 20
+		SLOT = 10
+	)
+
+	csma := CSMA{DIFS: DIFS, SIFS: SIFS, Slot: SLOT, MinWindow: 4, MaxWindow: 16}
+	monitor := PowerMonitor{Threshold: fixed.FromFloat(0.1), WindowSize: 1}
+	encoder := Encoder{BufferSize: 2}
+	encoder.Init()
+
+	// feeds n samples and returns the number of idle samples before the first frame is granted, -1 if none is
+	feed := func(n int, busy bool) int {
+		sample := int32(0)
+		if busy {
+			sample = 0x7fffffff / 2
+		}
+		for i := range n {
+			csma.Update([]int32{sample}, &monitor, &encoder)
+			if encoder.ready() > 0 {
+				return i + 1
+			}
+		}
+		return -1
+	}
+	frame := func() EncoderFrame {
+		frame := EncoderFrame{Done: make(chan bool, 1)}
+		encoder.admit(frame)
+		return frame
+	}
+	// the encoder takes the frame granted the medium and sends it
+	take := func() EncoderFrame {
+		frame, _ := encoder.next()
+		encoder.finish(frame, true)
+		return frame
+	}
+
+	// the countdown of 3 slots after DIFS freezes while the medium is busy and loses the current slot
+	feed(10, true)
+	csma.pending = append(csma.pending, &access{frame: frame(), space: DIFS, slots: 3})
+	if granted := feed(DIFS+15, false); granted != -1 {
+		t.Fatalf("Granted after %d samples, before the end of the backoff", granted)
+	}
+	feed(5, true)
+	if granted := feed(1000, false); granted != DIFS+2*SLOT {
+		t.Errorf("Granted after %d idle samples, expected DIFS and the 2 remaining slots", granted)
+	}
+	take()
+
+	// a reply waits for SIFS only and goes before a frame waiting for DIFS
+	feed(10, true)
+	csma.request(frame(), false)
+	reply := frame()
+	csma.request(reply, true)
+	if granted := feed(1000, false); granted != SIFS {
+		t.Errorf("Granted after %d idle samples, expected SIFS", granted)
+	}
+	if take().Done != reply.Done {
+		t.Errorf("The frame went before the reply")
+	}
+	if stats := csma.Stats(); stats.Attempts != 1 {
+		t.Errorf("Expected 1 attempt, the reply excluded, got %+v", stats)
+	}
+
+	// the cancelled frames are notified
+	csma.cancel(&encoder)
+	if len(csma.pending) != 0 {
+		t.Errorf("The frames are still pending after cancel")
+	}
+
+	// the NAV defers the frames but not the replies
+	feed(10, true)
+	csma.Reserve(50)
+	csma.request(frame(), false)
+	slots := csma.pending[0].slots
+	if granted := feed(1000, false); granted != 50+DIFS+slots*SLOT {
+		t.Errorf("Granted after %d idle samples, expected the NAV, DIFS and the backoff", granted)
+	}
+	take()
+	feed(10, true)
+	csma.Reserve(50)
+	csma.request(frame(), true)
+	if granted := feed(1000, false); granted != SIFS {
+		t.Errorf("Reply granted after %d idle samples in the NAV, expected SIFS", granted)
+	}
+	take()
+
+	// the contention window doubles up to the max on failure and resets on success
+	for _, expected := range []int{8, 16, 16} {
+		csma.Failure()
+		if csma.window != expected {
+			t.Errorf("Window %d after a failure, expected %d", csma.window, expected)
+		}
+	}
+	csma.Success()
+	if csma.window != 4 {
+		t.Errorf("Window %d after a success, expected 4", csma.window)
+	}
+	if rate := csma.Stats().FailureRate(); rate != 1.5 {
+		t.Errorf("Failure rate %v, expected 3 failures in 2 attempts", rate)
+	}
+
+	// without a slot the frames are not deferred past DIFS
+	csma.Slot = 0
+	feed(50, true) // past the NAV of the reply
+	csma.request(frame(), false)
+	if granted := feed(1000, false); granted != DIFS {
+		t.Errorf("Granted after %d idle samples without a slot, expected DIFS", granted)
+	}
+	take()
+}
+
+func TestExponentialBackoffTimer(t *testing.T) {
+	timer := ExponentialBackoffTimer{Slot: time.Millisecond, MinWindow: 2, MaxWindow: 16}
+	for retries, window := range []int{2, 4, 8, 16, 16} {
+		longest := time.Duration(0)
+		for range 1000 {
+			longest = max(longest, timer.GetBackoffTime(retries))
+		}
+		if longest != time.Duration(window-1)*time.Millisecond {
+			t.Errorf("Longest backoff %v at retry %d, expected %d slots", longest, retries, window-1)
+		}
+	}
+}
+
+// a node of the tests on a device.Network in real time
+func newCSMANode(dev device.Device, address ReliableDataLinkAddress, bufferSize int) *ReliableDataLinkLayer {
+	const CARRIER_SIZE = 3
+	var preamble = modem.DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+	return &ReliableDataLinkLayer{
+		PhysicalLayer: PhysicalLayer{
+			Device: dev,
+			Decoder: Decoder{
+				Demodulator: modem.Demodulator{
+					Preamble:                 preamble,
+					CarrierSize:              CARRIER_SIZE,
+					DemodulatePowerThreshold: fixed.FromFloat(30),
+					BufferSize:               10,
+				},
+				BufferSize: 10000,
+			},
+			Encoder: Encoder{
+				Modulator: modem.Modulator{
+					Preamble:      preamble,
+					CarrierSize:   CARRIER_SIZE,
+					BytePerFrame:  125,
+					FrameInterval: 256,
+				},
+				BufferSize: 1,
+			},
+			PowerMonitor: PowerMonitor{
+				Threshold:  fixed.FromFloat(0.4),
+				WindowSize: 10,
+			},
+			CSMA: &CSMA{DIFS: 1024, SIFS: 256, Slot: 256, MinWindow: 8, MaxWindow: 64},
+		},
+		Address:    address,
+		ACKTimeout: 500 * time.Millisecond,
+		MaxRetries: 10,
+		BufferSize: bufferSize,
+	}
+}
+
+// three nodes contend for the medium to send to a fourth, each gets a fair share and the collisions are counted
+func TestCSMAFairness(t *testing.T) {
+	if testing.Short() {
+		t.Skip("The nodes run in real time")
+	}
+
+	const (
+		SENDERS      = 3
+		PACKETS      = 4
+		PAYLOAD_SIZE = 30
+	)
+
+	network := device.Network[string]{
+		Config:     make(device.NetworkConfig[string], SENDERS+1),
+		SampleRate: 48000 / device.BufferSize, // in real time so that the decoders keep up
+	}
+	for i := range network.Config {
+		network.Config[i].In, network.Config[i].Out = "air", "air"
+	}
+	devices := network.Build()
+
+	nodes := make([]*ReliableDataLinkLayer, SENDERS+1)
+	for i := range nodes {
+		nodes[i] = newCSMANode(devices[i], ReliableDataLinkAddress(i), SENDERS*PACKETS*2)
+		nodes[i].Open()
+	}
+	receiver := nodes[SENDERS]
+	start := time.Now()
+	var wg sync.WaitGroup
+	var finish [SENDERS]time.Duration
+	for i := range SENDERS {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range PACKETS {
+				payload := make([]byte, PAYLOAD_SIZE)
+				payload[0], payload[1] = byte(i), byte(k)
+				if err := nodes[i].Send(receiver.Address, payload); err != nil {
+					t.Errorf("Node %d: %v", i, err)
+					return
+				}
+			}
+			finish[i] = time.Since(start)
+		}()
+	}
+	wg.Wait()
+
+	var received [SENDERS]map[byte]bool
+	for i := range received {
+		received[i] = make(map[byte]bool)
+	}
+	for len(receiver.ReceiveAsync()) > 0 {
+		packet := <-receiver.ReceiveAsync()
+		received[packet[0]][packet[1]] = true
+	}
+
+	// Jain's fairness index of the throughput of the senders, 1 when equal and 1/n when one takes everything
+	var sum, squares float64
+	for i := range SENDERS {
+		stats := nodes[i].CSMA.Stats()
+		throughput := float64(PACKETS) / finish[i].Seconds()
+		sum += throughput
+		squares += throughput * throughput
+		t.Logf("Node %d: %d packets in %v, %d attempts, collision rate %.2f", i, len(received[i]), finish[i], stats.Attempts, stats.FailureRate())
+		if len(received[i]) != PACKETS {
+			t.Errorf("Node %d delivered %d packets, expected %d", i, len(received[i]), PACKETS)
+		}
+	}
+	fairness := sum * sum / (SENDERS * squares)
+	t.Logf("Fairness index %.2f", fairness)
+	if fairness < 0.7 {
+		t.Errorf("Unfair share of the medium")
+	}
+
+	for _, node := range nodes {
+		node.Close()
+	}
+}
+
+// A and C both hear B but not each other, the RTS/CTS keeps C quiet while A sends to B and the other way round
+func TestHiddenTerminal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("The nodes run in real time")
+	}
+
+	const (
+		PACKETS      = 4
+		PAYLOAD_SIZE = 100
+	)
+
+	// returns the collision rate of A and C
+	run := func(rtsThreshold int) float64 {
+		network := device.Network[string]{
+			Config: device.NetworkConfig[string]{
+				{In: "ac", Out: "b"},
+				{In: "b", Out: "ac"},
+				{In: "ac", Out: "b"},
+			},
+			SampleRate: 48000 / device.BufferSize,
+		}
+		devices := network.Build()
+
+		nodes := make([]*ReliableDataLinkLayer, len(devices))
+		for i := range nodes {
+			nodes[i] = newCSMANode(devices[i], ReliableDataLinkAddress(i), 2*PACKETS*2)
+			nodes[i].RTSThreshold = rtsThreshold
+			nodes[i].Open()
+		}
+
+		var wg sync.WaitGroup
+		for _, i := range []int{0, 2} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := range PACKETS {
+					payload := make([]byte, PAYLOAD_SIZE)
+					payload[0], payload[1] = byte(i), byte(k)
+					if err := nodes[i].Send(nodes[1].Address, payload); err != nil {
+						t.Logf("Node %d: %v", i, err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		var attempts, failures int
+		for _, i := range []int{0, 2} {
+			stats := nodes[i].CSMA.Stats()
+			attempts += stats.Attempts
+			failures += stats.Failures
+		}
+		for _, node := range nodes {
+			node.Close()
+		}
+		return CSMAStats{Attempts: attempts, Failures: failures}.FailureRate()
+	}
+
+	without := run(0)
+	with := run(PAYLOAD_SIZE / 2)
+	t.Logf("Collision rate %.2f without RTS/CTS, %.2f with", without, with)
+	if with >= without {
+		t.Errorf("RTS/CTS does not reduce the collisions of the hidden terminals")
+	}
+}
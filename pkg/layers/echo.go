@@ -0,0 +1,149 @@
+package layers
+
+import (
+	"math"
+	"sync"
+)
+
+// EchoCanceller subtracts the own output of the node from its input, so that the node hears the others while it sends.
+// The echo is the output delayed by Delay samples through a path learned by an adaptive FIR filter of Taps coefficients
+// with the normalised least mean squares, which adapts only while the node sends.
+// Once the echo is cancelled, a residual above the CollisionThreshold while the node sends is another sender, i.e. a collision.
+// The canceller is disabled by its zero value.
+type EchoCanceller struct {
+	Delay              int     // the samples between the output and its echo in the input, e.g. the buffer of a device.Network
+	Taps               int     // the length of the echo path from the Delay on, 0 disables the canceller
+	StepSize           float64 // the step of the NLMS in (0, 2), 0 means 0.5
+	CollisionThreshold float64 // the level of the residual relative to the full scale which tells a collision, 0 disables the detection
+	Window             int     // number of samples the levels are averaged over, 0 means 64
+
+	weights []float64
+	outputs []float64 // the outputs from the sample base on
+	base    int
+	read    int // the input samples processed
+
+	echo      float64 // the levels of the input and the residual while sending
+	residual  float64
+	converged bool // the residual was a tenth of the echo, after which the collisions are detected
+	colliding bool // a collision is detected, the filter is frozen until the output ends
+	detected  bool // a collision is detected in the last input
+
+	mutex sync.Mutex
+	stats EchoStats
+}
+
+type EchoStats struct {
+	Converged  bool
+	Collisions int
+	ERLE       float64 // the echo return loss enhancement in dB, i.e. how much the echo is attenuated while sending
+}
+
+func (e *EchoCanceller) enabled() bool {
+	return e.Taps > 0
+}
+
+func (e *EchoCanceller) Stats() EchoStats {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.stats
+}
+
+// the output of the given sample, 0 if it is not recorded
+func (e *EchoCanceller) output(index int) float64 {
+	if index < e.base || index >= e.base+len(e.outputs) {
+		return 0
+	}
+	return e.outputs[index-e.base]
+}
+
+// returns the input without the echo, or the input itself if the canceller is disabled
+func (e *EchoCanceller) Process(in []int32) []int32 {
+	if !e.enabled() {
+		return in
+	}
+	if e.weights == nil {
+		e.weights = make([]float64, e.Taps)
+	}
+	step := e.StepSize
+	if step == 0 {
+		step = 0.5
+	}
+	window := e.Window
+	if window == 0 {
+		window = 64
+	}
+	alpha := 1 / float64(window)
+
+	e.detected = false
+	sent := false
+	out := make([]int32, len(in))
+	taps := make([]float64, e.Taps)
+	for i, sample := range in {
+		x := float64(sample) / 0x7fffffff
+		n := e.read + i
+
+		norm, y := 0.0, 0.0
+		for k := range taps {
+			taps[k] = e.output(n - e.Delay - k)
+			norm += taps[k] * taps[k]
+			y += e.weights[k] * taps[k]
+		}
+		residual := x - y
+
+		if sending := norm > 1e-9; sending {
+			sent = true
+			e.echo += alpha * (math.Abs(x) - e.echo)
+			e.residual += alpha * (math.Abs(residual) - e.residual)
+			if !e.colliding && e.converged && e.CollisionThreshold > 0 && e.residual > e.CollisionThreshold {
+				// another sender, which must not be learned as the echo
+				e.colliding, e.detected = true, true
+			}
+			if !e.colliding {
+				g := step * residual / (norm + 1e-6)
+				for k := range taps {
+					e.weights[k] += g * taps[k]
+				}
+				if !e.converged && e.echo > 0 && e.residual < e.echo/10 {
+					e.converged = true
+				}
+			}
+		} else {
+			e.colliding = false
+			e.residual += alpha * (0 - e.residual)
+		}
+		out[i] = int32(max(min(residual, 1), -1) * 0x7fffffff)
+	}
+	e.read += len(in)
+
+	e.mutex.Lock()
+	e.stats.Converged = e.converged
+	if e.detected {
+		e.stats.Collisions++
+	}
+	if sent && e.residual > 0 && !e.colliding {
+		e.stats.ERLE = 20 * math.Log10(e.echo/e.residual)
+	}
+	e.mutex.Unlock()
+	return out
+}
+
+// records the output written to the device, whose echo comes in the later inputs
+func (e *EchoCanceller) Record(out []int32) {
+	if !e.enabled() {
+		return
+	}
+	for _, sample := range out {
+		e.outputs = append(e.outputs, float64(sample)/0x7fffffff)
+	}
+	// the outputs before the oldest tap of the next input are not needed anymore
+	if drop := e.read - e.Delay - e.Taps - e.base; drop > 0 {
+		drop = min(drop, len(e.outputs))
+		e.outputs = e.outputs[drop:]
+		e.base += drop
+	}
+}
+
+// whether a collision is detected in the last input
+func (e *EchoCanceller) collided() bool {
+	return e.detected
+}
@@ -0,0 +1,165 @@
+package layers
+
+import (
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/fixed"
+	"Aethernet/pkg/modem"
+	"crypto/rand"
+	mathrand "math/rand"
+	"testing"
+	"time"
+)
+
+func TestEchoCanceller(t *testing.T) {
+	const (
+		BUFFER = 256
+		DELAY  = 300
+	)
+	random := mathrand.New(mathrand.NewSource(1))
+	e := EchoCanceller{Delay: DELAY, Taps: 4, CollisionThreshold: 0.1}
+
+	// the echo path of a gain and a reflection
+	outputs := []float64{}
+	echo := func(n int) float64 {
+		at := func(i int) float64 {
+			if i < 0 {
+				return 0
+			}
+			return outputs[i]
+		}
+		return 0.6*at(n-DELAY) + 0.2*at(n-DELAY-2)
+	}
+
+	run := func(buffers int, other float64) (collided bool) {
+		in, out := make([]int32, BUFFER), make([]int32, BUFFER)
+		for range buffers {
+			// the input of this buffer holds the echo of the outputs before
+			for i := range in {
+				in[i] = int32((echo(len(outputs)+i) + other*(random.Float64()*2-1)) / 2 * 0x7fffffff)
+			}
+			e.Process(in)
+			collided = collided || e.collided()
+			for i := range out {
+				sample := float64(random.Intn(2)*2 - 1)
+				outputs = append(outputs, sample)
+				out[i] = int32(sample * 0x7fffffff)
+			}
+			e.Record(out)
+		}
+		return collided
+	}
+
+	if run(20, 0) {
+		t.Error("The echo is taken for a collision")
+	}
+	stats := e.Stats()
+	if !stats.Converged || stats.ERLE < 20 {
+		t.Fatalf("The echo is not cancelled: %+v", stats)
+	}
+	if len(e.outputs) > DELAY+BUFFER+e.Taps {
+		t.Errorf("%d outputs are kept", len(e.outputs))
+	}
+
+	if !run(2, 0.8) || e.Stats().Collisions != 1 {
+		t.Errorf("The other sender is not detected: %+v", e.Stats())
+	}
+}
+
+func newEchoNode(dev device.Device) *PhysicalLayer {
+	const CARRIER_SIZE = 3
+	var preamble = modem.DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+	return &PhysicalLayer{
+		Device: dev,
+		Decoder: Decoder{
+			Demodulator: modem.Demodulator{
+				Preamble:                 preamble,
+				CarrierSize:              CARRIER_SIZE,
+				DemodulatePowerThreshold: fixed.FromFloat(30),
+				BufferSize:               10,
+			},
+			BufferSize: 10000,
+		},
+		Encoder: Encoder{
+			Modulator: modem.Modulator{
+				Preamble:      preamble,
+				CarrierSize:   CARRIER_SIZE,
+				BytePerFrame:  125,
+				FrameInterval: 256,
+			},
+			BufferSize: 1,
+		},
+		PowerMonitor: PowerMonitor{
+			Threshold:  fixed.FromFloat(0.4),
+			WindowSize: 10,
+		},
+		// the network passes the output of a buffer to the inputs of the next one
+		Echo: EchoCanceller{Delay: device.BufferSize, Taps: 4, CollisionThreshold: 0.2},
+	}
+}
+
+// a node does not receive its own frames once the echo is cancelled, and two nodes sending at once abort their frames
+func TestEchoCollisionDetection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("The nodes run in real time")
+	}
+
+	network := device.Network[string]{
+		Config:     device.NetworkConfig[string]{{In: "air", Out: "air"}, {In: "air", Out: "air"}},
+		SampleRate: 48000 / device.BufferSize,
+	}
+	devices := network.Build()
+	nodes := make([]*PhysicalLayer, len(devices))
+	for i := range nodes {
+		nodes[i] = newEchoNode(devices[i])
+		nodes[i].Open()
+		defer nodes[i].Close()
+	}
+
+	data := make([]byte, 100)
+	rand.Read(data)
+	for i, node := range nodes {
+		// the frames of the other node are received while this one learns its echo
+		if !<-node.SendAsync(data) {
+			t.Fatalf("Node %d could not send alone", i)
+		}
+		if stats := node.Echo.Stats(); !stats.Converged {
+			t.Fatalf("Node %d did not learn its echo: %+v", i, stats)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for len(nodes[0].ReceiveAsync()) > 0 {
+		<-nodes[0].ReceiveAsync()
+	}
+	for len(nodes[1].ReceiveAsync()) > 0 {
+		<-nodes[1].ReceiveAsync()
+	}
+
+	// alone on the medium
+	if !<-nodes[0].SendAsync(data) {
+		t.Fatal("Node 0 could not send alone")
+	}
+	select {
+	case <-nodes[1].ReceiveAsync():
+	case <-time.After(time.Second):
+		t.Fatal("Node 1 did not receive the frame")
+	}
+	select {
+	case <-nodes[0].ReceiveAsync():
+		t.Error("Node 0 received its own frame")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// both start without sensing the medium, with other data as the same frames at once would add up to the own echo
+	other := make([]byte, len(data))
+	rand.Read(other)
+	sent := []<-chan bool{nodes[0].Encoder.sendAsync(data), nodes[1].Encoder.sendAsync(other)}
+	for i, node := range nodes {
+		if <-sent[i] {
+			t.Errorf("Node %d sent its frame through the collision", i)
+		}
+		t.Logf("Node %d: %+v", i, node.Echo.Stats())
+		if node.Echo.Stats().Collisions == 0 {
+			t.Errorf("Node %d did not detect the collision", i)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package layers
+
+import (
+	"fmt"
+	"time"
+)
+
+// the packets the output channel has room for, advertised to the sources.
+// An unbuffered channel takes a packet whenever the application waits for one, so it is advertised as 1
+func (m *ReliableDataLinkLayer) window() uint8 {
+	if cap(m.outputChan) == 0 {
+		return 1
+	}
+	return uint8(min(cap(m.outputChan)-len(m.outputChan), 0xff))
+}
+
+// sets the window of this node in an ACK or a NACK, versioned headers only
+func (m *ReliableDataLinkLayer) advertise(header *ReliableDataLinkHeader) {
+	if m.Header == ReliableDataLinkHeaderCompact {
+		return
+	}
+	header.Flags |= ReliableDataLinkFlagWindow
+	header.Window = m.window()
+}
+
+// notes the window advertised by the source
+func (m *ReliableDataLinkLayer) updateWindow(source ReliableDataLinkAddress, window uint8) {
+	m.windowMutex.Lock()
+	defer m.windowMutex.Unlock()
+	m.windows[source] = window
+	close(m.windowUpdated)
+	m.windowUpdated = make(chan struct{})
+}
+
+// the last window advertised by the address, false if it has not advertised any
+func (m *ReliableDataLinkLayer) PeerWindow(address ReliableDataLinkAddress) (uint8, bool) {
+	m.windowMutex.Lock()
+	defer m.windowMutex.Unlock()
+	window, ok := m.windows[address]
+	return window, ok
+}
+
+func (m *ReliableDataLinkLayer) FlowStats() FlowStats {
+	m.windowMutex.Lock()
+	defer m.windowMutex.Unlock()
+	return m.flowStats
+}
+
+// waits while the address has no room, up to the WindowProbe after which the next frame probes the window
+func (m *ReliableDataLinkLayer) pace(address ReliableDataLinkAddress) {
+	probe := m.WindowProbe
+	if probe == 0 {
+		probe = m.ACKTimeout
+	}
+	timeout := time.After(probe)
+	start := time.Now()
+	paused := false
+	for {
+		m.windowMutex.Lock()
+		window, ok := m.windows[address]
+		updated := m.windowUpdated
+		m.windowMutex.Unlock()
+		if !ok || window > 0 {
+			break
+		}
+		if !paused {
+			fmt.Printf("[MAC%x] %x has no room, pausing\n", m.Address, address)
+			paused = true
+		}
+		select {
+		case <-updated:
+			continue
+		case <-timeout:
+		}
+		break
+	}
+	if paused {
+		m.windowMutex.Lock()
+		m.flowStats.Pauses++
+		m.flowStats.Paused += time.Since(start)
+		m.windowMutex.Unlock()
+	}
+}
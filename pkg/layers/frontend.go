@@ -0,0 +1,142 @@
+package layers
+
+import "math"
+
+// FrontEnd conditions the received samples before the Decoder and the PowerMonitor.
+// Every stage is disabled by its zero value, so the zero FrontEnd passes the samples through.
+type FrontEnd struct {
+	DCBlock  DCBlocker
+	BandPass BandPass
+	AGC      AGC
+}
+
+func (f *FrontEnd) enabled() bool {
+	return f.DCBlock.Pole != 0 || f.BandPass.enabled() || f.AGC.Target != 0
+}
+
+// returns the processed copy of the samples, or the samples themselves if every stage is disabled
+func (f *FrontEnd) Process(in []int32) []int32 {
+	if !f.enabled() {
+		return in
+	}
+	out := make([]int32, len(in))
+	for i, sample := range in {
+		x := float64(sample) / 0x7fffffff
+		x = f.DCBlock.Update(x)
+		x = f.BandPass.Update(x)
+		x = f.AGC.Update(x)
+		out[i] = int32(max(min(x, 1), -1) * 0x7fffffff)
+	}
+	return out
+}
+
+// DCBlocker is the high-pass filter y[n] = x[n] - x[n-1] + Pole*y[n-1]
+type DCBlocker struct {
+	Pole float64 // in (0, 1), the closer to 1 the lower the cutoff, e.g. 0.995 is about 38 Hz at 48 kHz, 0 disables the blocker
+
+	x, y float64
+}
+
+func (d *DCBlocker) Update(x float64) float64 {
+	if d.Pole == 0 {
+		return x
+	}
+	y := x - d.x + d.Pole*d.y
+	d.x, d.y = x, y
+	return y
+}
+
+// BandPass is a second order Butterworth high-pass at Low followed by a low-pass at High
+type BandPass struct {
+	Low        float64 // in Hz, 0 disables the high-pass
+	High       float64 // in Hz, 0 disables the low-pass
+	SampleRate float64
+
+	highPass, lowPass *biquad
+}
+
+func (b *BandPass) enabled() bool {
+	return b.Low != 0 || b.High != 0
+}
+
+func (b *BandPass) Update(x float64) float64 {
+	if b.Low != 0 {
+		if b.highPass == nil {
+			b.highPass = newBiquad(b.Low, b.SampleRate, true)
+		}
+		x = b.highPass.update(x)
+	}
+	if b.High != 0 {
+		if b.lowPass == nil {
+			b.lowPass = newBiquad(b.High, b.SampleRate, false)
+		}
+		x = b.lowPass.update(x)
+	}
+	return x
+}
+
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// the coefficients of the audio EQ cookbook with Q = 1/sqrt(2)
+func newBiquad(cutoff, sampleRate float64, highPass bool) *biquad {
+	w := 2 * math.Pi * cutoff / sampleRate
+	alpha := math.Sin(w) / math.Sqrt2
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+
+	var b biquad
+	if highPass {
+		b.b0 = (1 + cos) / 2 / a0
+		b.b1 = -(1 + cos) / a0
+	} else {
+		b.b0 = (1 - cos) / 2 / a0
+		b.b1 = (1 - cos) / a0
+	}
+	b.b2 = b.b0
+	b.a1 = -2 * cos / a0
+	b.a2 = (1 - alpha) / a0
+	return &b
+}
+
+func (b *biquad) update(x float64) float64 {
+	y := b.b0*x + b.b1*b.x1 + b.b2*b.x2 - b.a1*b.y1 - b.a2*b.y2
+	b.x1, b.x2 = x, b.x1
+	b.y1, b.y2 = y, b.y1
+	return y
+}
+
+// AGC scales the samples so that their RMS level over about a frame reaches the target
+type AGC struct {
+	Target  float64 // the RMS level relative to the full scale, 0 disables the AGC
+	Window  int     // number of samples the level is measured over, about the length of a frame, 0 means 1024
+	MaxGain float64 // the gain applied to the silence, 0 means 100
+
+	power float64 // the moving average of the squared samples
+}
+
+func (a *AGC) Update(x float64) float64 {
+	if a.Target == 0 {
+		return x
+	}
+	window := a.Window
+	if window == 0 {
+		window = 1024
+	}
+	a.power += (x*x - a.power) / float64(window)
+	return x * a.Gain()
+}
+
+// the current gain
+func (a *AGC) Gain() float64 {
+	maxGain := a.MaxGain
+	if maxGain == 0 {
+		maxGain = 100
+	}
+	if a.power == 0 {
+		return maxGain
+	}
+	return min(a.Target/math.Sqrt(a.power), maxGain)
+}
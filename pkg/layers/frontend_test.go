@@ -0,0 +1,132 @@
+package layers
+
+import (
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/fixed"
+	"Aethernet/pkg/modem"
+	"crypto/rand"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// the RMS of a sine through the filter once settled
+func response(update func(float64) float64, freq, sampleRate float64) float64 {
+	const n = 48000
+	power := 0.0
+	for i := range n {
+		y := update(math.Sin(2 * math.Pi * freq * float64(i) / sampleRate))
+		if i >= n/2 {
+			power += y * y
+		}
+	}
+	return math.Sqrt(power / (n / 2))
+}
+
+func TestFrontEnd(t *testing.T) {
+
+	// the offset is removed
+	dc := DCBlocker{Pole: 0.995}
+	if y := response(func(x float64) float64 { return dc.Update(0.5 + x) }, 1000, 48000); math.Abs(y-math.Sqrt(0.5)) > 0.01 {
+		t.Errorf("DC blocker output RMS %.3f, expected the sine only", y)
+	}
+
+	// the band is kept and the outside attenuated
+	for freq, expected := range map[float64][2]float64{100: {0, 0.1}, 5000: {0.65, 0.75}, 20000: {0, 0.1}} {
+		bandPass := BandPass{Low: 1000, High: 10000, SampleRate: 48000}
+		if y := response(bandPass.Update, freq, 48000); y < expected[0] || y > expected[1] {
+			t.Errorf("Band-pass output RMS %.3f at %v Hz, expected in %v", y, freq, expected)
+		}
+	}
+
+	// a weak and a loud signal reach the same level
+	for _, amplitude := range []float64{0.01, 1} {
+		agc := AGC{Target: 0.5, Window: 256}
+		if y := response(func(x float64) float64 { return agc.Update(amplitude * x) }, 1000, 48000); math.Abs(y-0.5) > 0.02 {
+			t.Errorf("AGC output RMS %.3f for amplitude %v, expected 0.5", y, amplitude)
+		}
+	}
+
+	var passThrough FrontEnd
+	in := []int32{1, 2, 3}
+	if out := passThrough.Process(in); &out[0] != &in[0] {
+		t.Errorf("The zero front end should not copy the samples")
+	}
+}
+
+// a weak receiver with an offset decodes with the power threshold thanks to the front end
+func TestPhysicalLayerFrontEnd(t *testing.T) {
+
+	const (
+		CARRIER_SIZE    = 3
+		POWER_THRESHOLD = 30
+	)
+
+	var preamble = modem.DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+
+	for _, enabled := range []bool{false, true} {
+		network := device.Network[string]{
+			Config: device.NetworkConfig[string]{
+				{In: "air", Out: "air"},
+				{In: "air", Out: "air"},
+			},
+			SampleRate: 48000 / device.BufferSize, // in real time so that the decoders keep up
+			Channel:    &device.Channel{Gain: 0.05, DC: 0.02},
+		}
+		devices := network.Build()
+
+		var layers [2]PhysicalLayer
+		for i := range layers {
+			layers[i] = PhysicalLayer{
+				Device: devices[i],
+				Decoder: Decoder{
+					Demodulator: modem.Demodulator{
+						Preamble:                 preamble,
+						CarrierSize:              CARRIER_SIZE,
+						BufferSize:               10, // the sender hears itself
+						DemodulatePowerThreshold: fixed.FromFloat(POWER_THRESHOLD),
+					},
+					BufferSize: 10000,
+				},
+				Encoder: Encoder{
+					Modulator: modem.Modulator{
+						Preamble:      preamble,
+						CarrierSize:   CARRIER_SIZE,
+						BytePerFrame:  100,
+						FrameInterval: 256,
+					},
+				},
+				PowerMonitor: PowerMonitor{
+					Threshold:  fixed.FromFloat(0.5),
+					WindowSize: 10,
+				},
+			}
+			if enabled {
+				layers[i].FrontEnd = FrontEnd{DCBlock: DCBlocker{Pole: 0.995}, AGC: AGC{Target: 0.7, Window: 1024, MaxGain: 50}}
+			}
+			layers[i].Open()
+		}
+
+		payload := make([]byte, 200)
+		rand.Read(payload)
+		layers[0].Send(payload)
+
+		select {
+		case received := <-layers[1].ReceiveAsync():
+			if !enabled {
+				t.Errorf("Received without the front end, the test does not show anything")
+			} else if !reflect.DeepEqual(received, payload) {
+				t.Errorf("Received a different payload")
+			}
+		case <-time.After(time.Second):
+			if enabled {
+				t.Errorf("Nothing received with the front end")
+			}
+		}
+
+		for i := range layers {
+			layers[i].Close()
+		}
+	}
+}
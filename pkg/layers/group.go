@@ -0,0 +1,56 @@
+package layers
+
+import (
+	"slices"
+	"sync"
+)
+
+// Group is a multicast group of the data link layers, a frame sent to the broadcast address carries its group
+// and is delivered to the nodes which joined it, every node is in GroupAll
+type Group uint8
+
+const GroupAll Group = 0
+
+// Groups is the set of the groups a node joined, the zero value is in GroupAll only
+type Groups struct {
+	mutex  sync.RWMutex
+	joined map[Group]bool
+}
+
+func (g *Groups) Join(group Group) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.joined == nil {
+		g.joined = make(map[Group]bool)
+	}
+	g.joined[group] = true
+}
+
+func (g *Groups) Leave(group Group) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.joined, group)
+}
+
+func (g *Groups) IsMember(group Group) bool {
+	if group == GroupAll {
+		return true
+	}
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.joined[group]
+}
+
+// the joined groups in ascending order, GroupAll excluded
+func (g *Groups) Joined() []Group {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	groups := make([]Group, 0, len(g.joined))
+	for group := range g.joined {
+		if group != GroupAll {
+			groups = append(groups, group)
+		}
+	}
+	slices.Sort(groups)
+	return groups
+}
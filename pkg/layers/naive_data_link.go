@@ -1,29 +1,187 @@
 package layers
 
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// the destination of the frames to every node of a group, which is also the address of the nodes without one
+const NaiveDataLinkBroadcast = 0xff
+
+// NaiveDataLinkHeaderFormat is the layout of the header on air, all the nodes of a segment must use the same
+// as nothing in a frame tells them apart.
+type NaiveDataLinkHeaderFormat uint8
+
+const (
+	// Source (8 bit), kept for the nodes of the first version, every frame reaches every node whatever its destination or group
+	NaiveDataLinkHeaderLegacy NaiveDataLinkHeaderFormat = iota
+	// Source (8 bit) | Destination (8 bit) | Group (8 bit, to the broadcast address only)
+	NaiveDataLinkHeaderAddressed
+)
+
+func (f NaiveDataLinkHeaderFormat) String() string {
+	switch f {
+	case NaiveDataLinkHeaderLegacy:
+		return "legacy"
+	case NaiveDataLinkHeaderAddressed:
+		return "addressed"
+	default:
+		return fmt.Sprintf("NaiveDataLinkHeaderFormat(%d)", uint8(f))
+	}
+}
+
+func ParseNaiveDataLinkHeaderFormat(name string) (NaiveDataLinkHeaderFormat, error) {
+	switch strings.ToLower(name) {
+	case "", "legacy":
+		return NaiveDataLinkHeaderLegacy, nil
+	case "addressed":
+		return NaiveDataLinkHeaderAddressed, nil
+	default:
+		return NaiveDataLinkHeaderLegacy, fmt.Errorf("unknown header %s, expected 'legacy' or 'addressed'", name)
+	}
+}
+
 type NaiveDataLinkLayer struct {
 	PhysicalLayer
+	Groups
 
-	Address    byte
-	BufferSize int
+	Header      NaiveDataLinkHeaderFormat
+	Address     byte // the address at Open, changed with SetAddress afterwards
+	BufferSize  int
+	Compression Compression
+	Secure      *Secure // authenticated encryption with the key of the destination, or of SecureGroupKey for the broadcasts, disabled if nil
 
 	outChan chan []byte
+	current atomic.Uint32 // the address read by the receiving loop and the senders
+}
+
+// changes the address of an opened layer, e.g. once it is assigned by the coordinator
+func (l *NaiveDataLinkLayer) SetAddress(address byte) {
+	l.current.Store(uint32(address))
+}
+
+// the address the layer sends from and receives at
+func (l *NaiveDataLinkLayer) CurrentAddress() byte {
+	return byte(l.current.Load())
 }
 
 func (l *NaiveDataLinkLayer) Open() {
+	l.current.Store(uint32(l.Address))
 	l.PhysicalLayer.Open()
 	l.outChan = make(chan []byte, l.BufferSize)
 	go func() {
 		for data := range l.PhysicalLayer.ReceiveAsync() {
-			if data[0] != l.Address {
-				// the packet was sent by someone else
-				l.outChan <- data[1:]
+			address := l.CurrentAddress()
+			if len(data) < 1 || l.Header == NaiveDataLinkHeaderAddressed && len(data) < 2 {
+				fmt.Printf("[DataLink%x] Packet is too short, dropping\n", address)
+				continue
+			}
+			source, destination, payload := data[0], byte(NaiveDataLinkBroadcast), data[1:]
+			if l.Header == NaiveDataLinkHeaderAddressed {
+				destination, payload = data[1], data[2:]
+			}
+			header := data[:len(data)-len(payload)]
+			if source == address {
+				// the packet was sent by this node
+				continue
+			}
+			switch {
+			case l.Header == NaiveDataLinkHeaderLegacy:
+				// the legacy frames have neither a destination nor a group
+			case destination == NaiveDataLinkBroadcast:
+				if len(payload) < 1 {
+					fmt.Printf("[DataLink%x] Broadcast packet without group, dropping\n", address)
+					continue
+				}
+				if !l.IsMember(Group(payload[0])) {
+					continue
+				}
+				payload = payload[1:]
+				header = data[:len(data)-len(payload)]
+			case destination != address:
+				continue
 			}
+			if l.Secure != nil {
+				peer := source
+				if destination == NaiveDataLinkBroadcast {
+					peer = SecureGroupKey
+				}
+				var err error
+				// the frames carry no sequence number
+				payload, err = l.Secure.Open(peer, source, destination, 0, header, payload)
+				if err != nil {
+					fmt.Printf("[DataLink%x] Dropping packet from %x: %v\n", address, source, err)
+					continue
+				}
+			}
+			if l.Compression != CompressionNone {
+				var err error
+				payload, err = DecodeCompressed(payload, MaxPacketSize)
+				if err != nil {
+					fmt.Printf("[DataLink%x] Failed to decompress packet: %v\n", address, err)
+					continue
+				}
+			}
+			l.outChan <- payload
 		}
 	}()
 }
 
+func (l *NaiveDataLinkLayer) send(destination byte, group Group, data []byte) <-chan bool {
+	address := l.CurrentAddress()
+	if l.Header == NaiveDataLinkHeaderLegacy {
+		// the frame reaches every node anyway
+		destination = NaiveDataLinkBroadcast
+	}
+	header := []byte{address}
+	if l.Header == NaiveDataLinkHeaderAddressed {
+		header = append(header, destination)
+		if destination == NaiveDataLinkBroadcast {
+			header = append(header, byte(group))
+		}
+	}
+	if l.Compression != CompressionNone {
+		data = l.Compression.Encode(data)
+	}
+	if l.Secure != nil {
+		peer := destination
+		if destination == NaiveDataLinkBroadcast {
+			peer = SecureGroupKey
+		}
+		sealed, err := l.Secure.Seal(peer, address, destination, 0, header, data)
+		if err != nil {
+			fmt.Printf("[DataLink%x] Failed to encrypt packet: %v\n", address, err)
+			failed := make(chan bool, 1)
+			failed <- false
+			return failed
+		}
+		data = sealed
+	}
+	return l.PhysicalLayer.SendAsync(append(header, data...))
+}
+
+// sends the data to every node
 func (l *NaiveDataLinkLayer) SendAsync(data []byte) <-chan bool {
-	return l.PhysicalLayer.SendAsync(append([]byte{l.Address}, data...))
+	return l.send(NaiveDataLinkBroadcast, GroupAll, data)
+}
+
+// sends the data to the node of the address only, or to every node with NaiveDataLinkBroadcast or the legacy header
+func (l *NaiveDataLinkLayer) SendToAsync(address byte, data []byte) <-chan bool {
+	return l.send(address, GroupAll, data)
+}
+
+func (l *NaiveDataLinkLayer) SendTo(address byte, data []byte) {
+	<-l.SendToAsync(address, data)
+}
+
+// sends the data to the nodes which joined the group, or to every node with the legacy header
+func (l *NaiveDataLinkLayer) MulticastAsync(group Group, data []byte) <-chan bool {
+	return l.send(NaiveDataLinkBroadcast, group, data)
+}
+
+func (l *NaiveDataLinkLayer) Multicast(group Group, data []byte) {
+	<-l.MulticastAsync(group, data)
 }
 
 func (l *NaiveDataLinkLayer) Send(data []byte) {
@@ -113,3 +113,91 @@ func TestNaiveDataLinkLayer(t *testing.T) {
 	}
 
 }
+
+// with the addressed header, a frame to an address reaches that node only, to a group the nodes which joined it
+// and to the broadcast address every node, while with the legacy header every frame reaches every node
+func TestNaiveDataLinkMulticast(t *testing.T) {
+	t.Run("addressed", func(t *testing.T) {
+		testNaiveDataLinkMulticast(t, NaiveDataLinkHeaderAddressed, [][]string{nil, {"multicast", "broadcast"}, {"unicast", "broadcast"}})
+	})
+	t.Run("legacy", func(t *testing.T) {
+		testNaiveDataLinkMulticast(t, NaiveDataLinkHeaderLegacy, [][]string{nil, {"unicast", "multicast", "broadcast"}, {"unicast", "multicast", "broadcast"}})
+	})
+}
+
+func testNaiveDataLinkMulticast(t *testing.T, header NaiveDataLinkHeaderFormat, expectations [][]string) {
+
+	const (
+		SAMPLE_RATE = 48000
+		GROUP       = 5
+	)
+
+	var preamble = modem.DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+
+	network := device.Network[string]{
+		Config:     make(device.NetworkConfig[string], 3),
+		SampleRate: SAMPLE_RATE,
+	}
+	for i := range network.Config {
+		network.Config[i].In, network.Config[i].Out = "w", "w"
+	}
+	devices := network.Build()
+
+	var nodes [3]NaiveDataLinkLayer
+	for i := range nodes {
+		nodes[i] = NaiveDataLinkLayer{
+			PhysicalLayer: PhysicalLayer{
+				Device: devices[i],
+				Decoder: Decoder{
+					Demodulator: modem.Demodulator{
+						Preamble:                 preamble,
+						CarrierSize:              2,
+						DemodulatePowerThreshold: fixed.FromFloat(30),
+						BufferSize:               10,
+					},
+					BufferSize: 10000,
+				},
+				Encoder: Encoder{
+					Modulator: modem.Modulator{
+						Preamble:      preamble,
+						CarrierSize:   2,
+						BytePerFrame:  127,
+						FrameInterval: 256,
+					},
+					BufferSize: 10,
+				},
+				PowerMonitor: PowerMonitor{
+					Threshold:  fixed.FromFloat(0.4),
+					WindowSize: 10,
+				},
+			},
+			Header:     header,
+			BufferSize: 10,
+			Address:    byte(i + 1),
+		}
+		nodes[i].Open()
+		defer nodes[i].Close()
+	}
+	nodes[1].Join(GROUP)
+
+	nodes[0].SendTo(nodes[2].Address, []byte("unicast"))
+	nodes[0].Multicast(GROUP, []byte("multicast"))
+	nodes[0].SendTo(NaiveDataLinkBroadcast, []byte("broadcast"))
+
+	for i, expected := range expectations {
+		var received []string
+		for range expected {
+			select {
+			case packet := <-nodes[i].ReceiveAsync():
+				received = append(received, string(packet))
+			case <-time.After(2 * time.Second):
+			}
+		}
+		for len(nodes[i].ReceiveAsync()) > 0 {
+			received = append(received, string(<-nodes[i].ReceiveAsync()))
+		}
+		if !reflect.DeepEqual(received, expected) {
+			t.Errorf("Node %d received %q, expected %q", i, received, expected)
+		}
+	}
+}
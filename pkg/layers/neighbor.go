@@ -0,0 +1,321 @@
+package layers
+
+import (
+	"Aethernet/pkg/modem"
+	"fmt"
+	"math/bits"
+	"slices"
+	"sync"
+	"time"
+)
+
+// NeighborCapabilities are the features a node advertises in its hellos
+type NeighborCapabilities uint16
+
+const (
+	CapabilitySecure NeighborCapabilities = 1 << iota
+	CapabilityCompression
+	CapabilityCSMA
+	CapabilityRTS
+	CapabilityToken
+	CapabilityRouter // forwards the IP packets of the others
+)
+
+func (c NeighborCapabilities) Has(capability NeighborCapabilities) bool {
+	return c&capability == capability
+}
+
+// the number of the last hellos the hello ratio is computed on
+const neighborHelloWindow = 32
+
+type Neighbor struct {
+	Address      ReliableDataLinkAddress
+	LastHeard    time.Time
+	RSSI         float64 // the peak correlation of the preamble of the last frame received, normalised to [-1, 1] with a Detector
+	CRCOK        int     // the frames received with a valid CRC since it is up
+	CRCFailed    int     // the frames whose CRC failed since it is up, told by the header they carry
+	CRCRatio     float64 // the share of its frames received with a valid CRC
+	HelloRatio   float64 // the share of the last hellos received, the lost ones are told by the gaps of their sequence
+	Capabilities NeighborCapabilities
+	HasHello     bool // whether a hello is received, the capabilities and the hello ratio are unknown before
+
+	sequence uint16 // of the last hello
+	history  uint32 // a bit for each of the last hellos, the lowest is the last one, set if it is received
+	span     int    // the hellos sent since the first one received, up to neighborHelloWindow
+}
+
+type NeighborEventType int
+
+const (
+	NeighborUp      NeighborEventType = iota // heard for the first time or after it was down
+	NeighborDown                             // not heard for the Timeout
+	NeighborChanged                          // advertises other capabilities
+)
+
+func (t NeighborEventType) String() string {
+	switch t {
+	case NeighborUp:
+		return "up"
+	case NeighborDown:
+		return "down"
+	case NeighborChanged:
+		return "changed"
+	default:
+		return fmt.Sprintf("NeighborEventType(%d)", int(t))
+	}
+}
+
+type NeighborEvent struct {
+	Type     NeighborEventType
+	Neighbor Neighbor
+}
+
+// NeighborDiscovery keeps the table of the nodes a ReliableDataLinkLayer hears, any frame keeps a node alive and the hellos
+// sent every Interval to the broadcast address tell its capabilities and the ratio of its frames received.
+// A node not heard for the Timeout is dropped from the table.
+type NeighborDiscovery struct {
+	Interval     time.Duration
+	Timeout      time.Duration
+	Capabilities NeighborCapabilities      // advertised besides the ones of the layer
+	OnChange     func(event NeighborEvent) // called from the receiving loop, so it must not block, may be nil
+	Now          func() time.Time          // the clock, time.Now if nil
+
+	sequence uint16
+	closed   chan struct{}
+	traced   *ReliableDataLinkAddress // the source of the packet whose frames are traced, nil if unknown
+
+	mutex sync.Mutex
+	table map[ReliableDataLinkAddress]*Neighbor
+	links map[ReliableDataLinkAddress]*Neighbor // the measurements of the frames traced, which may come before the node is heard
+}
+
+func (n *NeighborDiscovery) init() {
+	n.closed = make(chan struct{})
+	n.table = make(map[ReliableDataLinkAddress]*Neighbor)
+	n.links = make(map[ReliableDataLinkAddress]*Neighbor)
+}
+
+// the neighbor with the measurements of its frames, under the mutex
+func (n *NeighborDiscovery) copy(neighbor *Neighbor) Neighbor {
+	copied := *neighbor
+	if link, ok := n.links[neighbor.Address]; ok {
+		copied.RSSI, copied.CRCOK, copied.CRCFailed = link.RSSI, link.CRCOK, link.CRCFailed
+		copied.CRCRatio = float64(link.CRCOK) / float64(link.CRCOK+link.CRCFailed)
+	}
+	return copied
+}
+
+func (n *NeighborDiscovery) now() time.Time {
+	if n.Now != nil {
+		return n.Now()
+	}
+	return time.Now()
+}
+
+// the alive neighbors in ascending order of address
+func (n *NeighborDiscovery) Neighbors() []Neighbor {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	neighbors := make([]Neighbor, 0, len(n.table))
+	for _, neighbor := range n.table {
+		neighbors = append(neighbors, n.copy(neighbor))
+	}
+	slices.SortFunc(neighbors, func(a, b Neighbor) int { return int(a.Address) - int(b.Address) })
+	return neighbors
+}
+
+func (n *NeighborDiscovery) Neighbor(address ReliableDataLinkAddress) (Neighbor, bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	neighbor, ok := n.table[address]
+	if !ok {
+		return Neighbor{}, false
+	}
+	return n.copy(neighbor), true
+}
+
+func (n *NeighborDiscovery) IsReachable(address ReliableDataLinkAddress) bool {
+	_, ok := n.Neighbor(address)
+	return ok
+}
+
+// measures a frame from the physical layer, whose source is read from the header of the first frame of its packet.
+// A frame with a valid CRC counts for its source even before the source is heard, a broken one only for a source known already.
+func (n *NeighborDiscovery) trace(format ReliableDataLinkHeaderFormat, address ReliableDataLinkAddress, report modem.FrameReport) {
+	if !report.HeaderOK {
+		return
+	}
+	if report.Header.Index == 0 {
+		n.traced = nil
+		header := ReliableDataLinkHeader{Format: format}
+		if header.FromBytes(report.Data) == nil && header.Source != address {
+			n.traced = &header.Source
+		}
+	}
+	if n.traced == nil {
+		return
+	}
+
+	source := *n.traced
+	if report.Header.IsLast {
+		n.traced = nil
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	link, ok := n.links[source]
+	if !ok {
+		if !report.CRCOK {
+			return
+		}
+		link = &Neighbor{Address: source}
+		n.links[source] = link
+	}
+	if report.CRCOK {
+		link.CRCOK++
+		link.RSSI = report.Power
+	} else {
+		link.CRCFailed++
+	}
+}
+
+func (n *NeighborDiscovery) notify(events []NeighborEvent) {
+	if n.OnChange == nil {
+		return
+	}
+	for _, event := range events {
+		n.OnChange(event)
+	}
+}
+
+// notes a frame of the source
+func (n *NeighborDiscovery) hear(source ReliableDataLinkAddress) {
+	n.mutex.Lock()
+	neighbor, ok := n.table[source]
+	if !ok {
+		neighbor = &Neighbor{Address: source}
+		n.table[source] = neighbor
+	}
+	neighbor.LastHeard = n.now()
+	copied := n.copy(neighbor)
+	n.mutex.Unlock()
+
+	if !ok {
+		n.notify([]NeighborEvent{{NeighborUp, copied}})
+	}
+}
+
+// notes a hello of the source, which is heard already
+func (n *NeighborDiscovery) hello(source ReliableDataLinkAddress, frame ReliableDataLinkControlFrame) {
+	n.mutex.Lock()
+	neighbor, ok := n.table[source]
+	if !ok {
+		n.mutex.Unlock()
+		return
+	}
+	gap := int(frame.Sequence - neighbor.sequence)
+	switch {
+	case !neighbor.HasHello || gap >= neighborHelloWindow:
+		// the first hello, or the first one after a restart of the source
+		neighbor.history, neighbor.span = 1, 1
+	case gap == 0:
+		// a duplicate
+	default:
+		neighbor.history = neighbor.history<<gap | 1
+		neighbor.span = min(neighbor.span+gap, neighborHelloWindow)
+	}
+	changed := neighbor.HasHello && neighbor.Capabilities != frame.Capabilities
+	neighbor.sequence = frame.Sequence
+	neighbor.Capabilities = frame.Capabilities
+	neighbor.HasHello = true
+	neighbor.HelloRatio = float64(bits.OnesCount32(neighbor.history)) / float64(neighbor.span)
+	copied := n.copy(neighbor)
+	n.mutex.Unlock()
+
+	if changed {
+		n.notify([]NeighborEvent{{NeighborChanged, copied}})
+	}
+}
+
+// drops the neighbors not heard for the Timeout
+func (n *NeighborDiscovery) expire() {
+	now := n.now()
+	events := []NeighborEvent{}
+	n.mutex.Lock()
+	for address, neighbor := range n.table {
+		if now.Sub(neighbor.LastHeard) > n.Timeout {
+			events = append(events, NeighborEvent{NeighborDown, n.copy(neighbor)})
+			delete(n.table, address)
+		}
+	}
+	for address := range n.links {
+		if _, ok := n.table[address]; !ok {
+			// down, or its packet was never received
+			delete(n.links, address)
+		}
+	}
+	n.mutex.Unlock()
+
+	slices.SortFunc(events, func(a, b NeighborEvent) int { return int(a.Neighbor.Address) - int(b.Neighbor.Address) })
+	n.notify(events)
+}
+
+// the capabilities of the layer and the advertised ones
+func (m *ReliableDataLinkLayer) capabilities() NeighborCapabilities {
+	capabilities := m.Neighbors.Capabilities
+	if m.Secure != nil {
+		capabilities |= CapabilitySecure
+	}
+	if m.Compression != CompressionNone {
+		capabilities |= CapabilityCompression
+	}
+	if m.CSMA != nil {
+		capabilities |= CapabilityCSMA
+	}
+	if m.RTSThreshold > 0 {
+		capabilities |= CapabilityRTS
+	}
+	if m.Token != nil {
+		capabilities |= CapabilityToken
+	}
+	return capabilities
+}
+
+func (m *ReliableDataLinkLayer) sendHello() {
+	n := m.Neighbors
+	frame := ReliableDataLinkControlFrame{Control: ReliableDataLinkControlHello, Sequence: n.sequence, Capabilities: m.capabilities()}
+	n.sequence++
+	header, err := m.header(ReliableDataLinkBroadcast, ReliableDataLinkTypeControl).ToBytes()
+	if err != nil {
+		fmt.Printf("[MAC%x] Failed to make hello: %v\n", m.Address, err)
+		return
+	}
+	data := append(header, frame.ToBytes()...)
+
+	if m.Token != nil {
+		m.Token.enter()
+		defer m.Token.leave()
+		m.Token.acquire()
+		defer m.Token.finish()
+		<-m.PhysicalLayer.ReplyAsync(data)
+	} else if sent, err := m.PhysicalLayer.SendPriorityAsync(data, PriorityControl); err != nil {
+		fmt.Printf("[MAC%x] Failed to send hello: %v\n", m.Address, err)
+	} else {
+		<-sent
+	}
+}
+
+func (m *ReliableDataLinkLayer) neighborLoop() {
+	n := m.Neighbors
+	ticker := time.NewTicker(n.Interval)
+	defer ticker.Stop()
+	for {
+		m.sendHello()
+		n.expire()
+		select {
+		case <-n.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package layers
+
+import (
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/modem"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNeighborTable(t *testing.T) {
+	frame := ReliableDataLinkControlFrame{Control: ReliableDataLinkControlHello, Sequence: 0x1234, Capabilities: CapabilityCSMA | CapabilityRouter}
+	read := ReliableDataLinkControlFrame{}
+	if err := read.FromBytes(frame.ToBytes()); err != nil || read != frame || len(frame.ToBytes()) != frame.NumBytes() {
+		t.Fatalf("Hello %+v is read as %+v %v", frame, read, err)
+	}
+
+	now := time.Unix(0, 0)
+	events := []NeighborEvent{}
+	n := &NeighborDiscovery{
+		Timeout:  time.Second,
+		Now:      func() time.Time { return now },
+		OnChange: func(event NeighborEvent) { events = append(events, event) },
+	}
+	n.init()
+
+	// the hellos 1, 2 and 4 of the 4 are received
+	for _, sequence := range []uint16{1, 2, 2, 4} {
+		n.hear(3)
+		n.hello(3, ReliableDataLinkControlFrame{Control: ReliableDataLinkControlHello, Sequence: sequence, Capabilities: CapabilityCSMA})
+	}
+	neighbor, ok := n.Neighbor(3)
+	if !ok || neighbor.HelloRatio != 0.75 || !neighbor.Capabilities.Has(CapabilityCSMA) {
+		t.Errorf("Unexpected neighbor %+v", neighbor)
+	}
+
+	// the frames of 3 are measured, the broken frames of a node not received yet and the frames of this node are not
+	frame3, _ := ReliableDataLinkHeader{Source: 3, Destination: 1, IsLast: true}.ToBytes()
+	frame6, _ := ReliableDataLinkHeader{Source: 6, Destination: 1, IsLast: true}.ToBytes()
+	frame1, _ := ReliableDataLinkHeader{Source: 1, Destination: 3, IsLast: true}.ToBytes()
+	for _, report := range []modem.FrameReport{
+		{HeaderOK: true, CRCOK: true, Power: 0.5, Data: frame3},
+		{HeaderOK: true, CRCOK: true, Power: 0.8, Data: frame3},
+		{HeaderOK: true, CRCOK: false, Power: 0.9, Data: frame3},
+		{HeaderOK: true, CRCOK: false, Power: 0.9, Data: frame6},
+		{HeaderOK: true, CRCOK: true, Power: 0.9, Data: frame1},
+		{HeaderOK: false, Power: 0.9},
+	} {
+		report.Header.IsLast = true
+		n.trace(ReliableDataLinkHeaderCompact, 1, report)
+	}
+	neighbor, _ = n.Neighbor(3)
+	if neighbor.CRCOK != 2 || neighbor.CRCFailed != 1 || neighbor.CRCRatio != 2.0/3 || neighbor.RSSI != 0.8 {
+		t.Errorf("Unexpected measurements %+v", neighbor)
+	}
+	if len(n.links) != 1 {
+		t.Errorf("Unexpected measured nodes %+v", n.links)
+	}
+
+	n.hello(3, ReliableDataLinkControlFrame{Control: ReliableDataLinkControlHello, Sequence: 5, Capabilities: CapabilityToken})
+	now = now.Add(500 * time.Millisecond)
+	n.hear(5)
+	now = now.Add(700 * time.Millisecond)
+	n.expire()
+	if n.IsReachable(3) || !n.IsReachable(5) || len(n.Neighbors()) != 1 {
+		t.Errorf("Unexpected neighbors %+v", n.Neighbors())
+	}
+
+	expected := []struct {
+		typ     NeighborEventType
+		address ReliableDataLinkAddress
+	}{{NeighborUp, 3}, {NeighborChanged, 3}, {NeighborUp, 5}, {NeighborDown, 3}}
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected events %+v", events)
+	}
+	for i, event := range events {
+		if event.Type != expected[i].typ || event.Neighbor.Address != expected[i].address {
+			t.Errorf("Event %d is %v of %x, expected %v of %x", i, event.Type, event.Neighbor.Address, expected[i].typ, expected[i].address)
+		}
+	}
+}
+
+// three nodes find each other with their hellos, and the two others see the third down after it is closed
+func TestNeighborDiscovery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("The nodes run in real time")
+	}
+
+	const NODES = 3
+
+	network := device.Network[string]{
+		Config:     make(device.NetworkConfig[string], NODES),
+		SampleRate: 48000 / device.BufferSize,
+	}
+	for i := range network.Config {
+		network.Config[i].In, network.Config[i].Out = "air", "air"
+	}
+	devices := network.Build()
+
+	var mutex sync.Mutex
+	down := make([][]ReliableDataLinkAddress, NODES)
+	nodes := make([]*ReliableDataLinkLayer, NODES)
+	for i := range nodes {
+		nodes[i] = newCSMANode(devices[i], ReliableDataLinkAddress(i), 4)
+		nodes[i].Neighbors = &NeighborDiscovery{
+			Interval:     200 * time.Millisecond,
+			Timeout:      time.Second,
+			Capabilities: CapabilityRouter,
+			OnChange: func(event NeighborEvent) {
+				if event.Type == NeighborDown {
+					mutex.Lock()
+					down[i] = append(down[i], event.Neighbor.Address)
+					mutex.Unlock()
+				}
+			},
+		}
+		nodes[i].Open()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, node := range nodes {
+		for len(node.Neighbors.Neighbors()) < NODES-1 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	for i, node := range nodes {
+		neighbors := node.Neighbors.Neighbors()
+		t.Logf("Node %d: %+v", i, neighbors)
+		if len(neighbors) != NODES-1 {
+			t.Fatalf("Node %d found %d neighbors, expected %d", i, len(neighbors), NODES-1)
+		}
+		for _, neighbor := range neighbors {
+			if neighbor.Address == node.Address || neighbor.RSSI <= 0 || neighbor.CRCOK == 0 {
+				t.Errorf("Node %d has an unexpected neighbor %+v", i, neighbor)
+			}
+			if neighbor.HasHello && !neighbor.Capabilities.Has(CapabilityCSMA|CapabilityRouter) {
+				t.Errorf("Node %d sees the capabilities %b of %x", i, neighbor.Capabilities, neighbor.Address)
+			}
+		}
+	}
+
+	nodes[2].Close()
+	deadline = time.Now().Add(3 * time.Second)
+	for _, node := range nodes[:2] {
+		for node.Neighbors.IsReachable(2) && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	for i, node := range nodes[:2] {
+		mutex.Lock()
+		if node.Neighbors.IsReachable(2) || len(down[i]) != 1 || down[i][0] != 2 {
+			t.Errorf("Node %d still sees node 2, down events %v", i, down[i])
+		}
+		mutex.Unlock()
+		if !node.Neighbors.IsReachable(nodes[1-i].Address) {
+			t.Errorf("Node %d lost node %d", i, 1-i)
+		}
+		node.Close()
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"Aethernet/pkg/fixed"
 	"Aethernet/pkg/modem"
 	"fmt"
+	"sync"
 )
 
 type PhysicalLayer struct {
@@ -16,6 +17,10 @@ type PhysicalLayer struct {
 
 	PowerMonitor PowerMonitor
 
+	FrontEnd FrontEnd      // conditions the input of the Decoder and the PowerMonitor
+	Echo     EchoCanceller // removes the own output from the input first, and aborts the frame on a collision
+	CSMA     *CSMA         // the slotted channel access, nil to send as soon as the PowerMonitor is not busy
+
 	LateUpdate func(in, out []int32)
 }
 
@@ -29,16 +34,27 @@ type Decoder struct {
 }
 
 type EncoderFrame struct {
-	Data []int32
-	Done chan bool // a channel with buffer size 1 to notify the sender that the data has been sent
+	Data     []int32
+	Done     chan bool // a channel with buffer size 1 to notify the sender that the data has been sent
+	Priority Priority
 }
 
+// Encoder writes the frames to the device from a queue for each priority class
 type Encoder struct {
 	Modulator  modem.Modulator
-	BufferSize int
+	BufferSize int                       // the frames queued for the device ahead of the current one, beyond which the CSMA holds its frames back
+	Classes    [NumPriorities]QueueClass // indexed by Priority
+	Weighted   bool                      // serves the classes in turns of their weights instead of strictly by priority
 
-	buffer  chan EncoderFrame // data to be sent
-	current *EncoderFrame     // current sending data
+	mutex    sync.Mutex
+	queues   [NumPriorities][]EncoderFrame // data to be sent
+	current  *EncoderFrame                 // current sending data
+	queued   [NumPriorities]int            // the frames admitted and not done
+	admitted map[<-chan bool]EncoderFrame
+	released chan struct{} // closed when an admitted frame is done
+	turn     Priority
+	served   int
+	stats    EncoderStats
 }
 
 type PowerMonitor struct {
@@ -71,33 +87,97 @@ func (e *Encoder) Init() {
 	if e.BufferSize == 0 {
 		e.BufferSize = 1
 	}
-	e.buffer = make(chan EncoderFrame, e.BufferSize)
+	e.mutex.Lock()
+	e.admitted = make(map[<-chan bool]EncoderFrame)
+	e.released = make(chan struct{})
+	e.mutex.Unlock()
 	e.Reset()
 }
 
+// cuts off the frame being sent
 func (e *Encoder) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 	if e.current != nil {
-		// TODO notify the sender that the data has been cancelled
-		e.current.Done <- false
+		e.finishLocked(*e.current, false)
 	}
 	e.current = nil
 }
 
+func (e *Encoder) isSending() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.current != nil || e.ready() > 0
+}
+
 func (p *PhysicalLayer) Send(data []byte) {
 	<-p.SendAsync(data)
 }
 
+// sends an interactive frame, the channel tells false if it is refused by its class or cancelled
 func (p *PhysicalLayer) SendAsync(data []byte) <-chan bool {
-	done := make(chan bool)
+	return p.queue(data, PriorityInteractive, false)
+}
+
+// sends a reply like an ACK, which waits for SIFS only with CSMA
+func (p *PhysicalLayer) Reply(data []byte) {
+	<-p.ReplyAsync(data)
+}
+
+func (p *PhysicalLayer) ReplyAsync(data []byte) <-chan bool {
+	return p.queue(data, PriorityControl, true)
+}
+
+// sends a frame of the priority, which may wait for room in its class, and returns a channel telling whether it has been sent,
+// the channel identifies the frame to Cancel
+func (p *PhysicalLayer) SendPriorityAsync(data []byte, priority Priority) (<-chan bool, error) {
+	return p.send(data, priority, false)
+}
+
+func (p *PhysicalLayer) send(data []byte, priority Priority, reply bool) (<-chan bool, error) {
+	frame := EncoderFrame{Done: make(chan bool, 1), Priority: priority}
+	if err := p.Encoder.admit(frame); err != nil {
+		return nil, err
+	}
+	if p.CSMA != nil {
+		frame.Data = p.Encoder.Modulator.Modulate(data)
+		return p.CSMA.request(frame, reply), nil
+	}
 	go func() {
 		<-p.PowerMonitor.NotBusySignal()
-		done <- <-p.Encoder.sendAsync(data)
+		frame.Data = p.Encoder.Modulator.Modulate(data)
+		p.Encoder.pushWait(frame)
 	}()
+	return frame.Done, nil
+}
+
+// sends the frame and tells false on the channel if it is refused by its class
+func (p *PhysicalLayer) queue(data []byte, priority Priority, reply bool) <-chan bool {
+	done, err := p.send(data, priority, reply)
+	if err != nil {
+		fmt.Printf("[Physical] Frame refused: %v\n", err)
+		refused := make(chan bool, 1)
+		refused <- false
+		return refused
+	}
 	return done
 }
 
+// removes a frame waiting for the medium or the device, or cuts it off if it is being sent, false if it is done already
+func (p *PhysicalLayer) Cancel(done <-chan bool) bool {
+	if p.CSMA != nil {
+		p.CSMA.remove(done)
+	}
+	return p.Encoder.cancel(done)
+}
+
+// the number of samples on air of the data of the given size
+func (p *PhysicalLayer) Airtime(size int) int {
+	return len(p.Encoder.Modulator.Modulate(make([]byte, size)))
+}
+
 func (p *PhysicalLayer) IsSending() bool {
-	return p.Encoder.current != nil || len(p.Encoder.buffer) > 0
+	return p.Encoder.isSending()
 }
 
 func (p *PhysicalLayer) DecodeErrorSignal() <-chan error {
@@ -105,6 +185,9 @@ func (p *PhysicalLayer) DecodeErrorSignal() <-chan error {
 }
 
 func (p *PhysicalLayer) CancelSend() {
+	if p.CSMA != nil {
+		p.CSMA.cancel(&p.Encoder)
+	}
 	p.Encoder.Reset()
 }
 
@@ -120,9 +203,21 @@ func (p *PhysicalLayer) Open() {
 	p.Decoder.Init()
 	p.Encoder.Init()
 	p.Device.Start(func(in, out []int32) {
-		p.inputCallback(in)
-		p.outputCallback(out)
-		p.PowerMonitor.Update(in)
+		received := p.FrontEnd.Process(p.Echo.Process(in))
+		if p.Echo.collided() && p.Encoder.isSending() {
+			fmt.Println("[Physical] Collision detected, aborting the frame")
+			p.Encoder.Reset()
+		}
+		p.inputCallback(received)
+		if p.CSMA != nil {
+			// the frames granted the medium start in this output
+			p.CSMA.Update(received, &p.PowerMonitor, &p.Encoder)
+			p.outputCallback(out)
+		} else {
+			p.outputCallback(out)
+			p.PowerMonitor.Update(received)
+		}
+		p.Echo.Record(out)
 		if p.LateUpdate != nil {
 			p.LateUpdate(in, out)
 		}
@@ -172,16 +267,15 @@ func (d *Decoder) submit(data []int32) {
 }
 
 func (e *Encoder) fetch() {
-	select {
-	case current := <-e.buffer:
+	if current, ok := e.next(); ok {
 		e.current = &current
-	default:
-		// no new data
 	}
 }
 
 // try to consume the outputBuffer and write some data to out
 func (e *Encoder) write(out []int32) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 
 	if e.current == nil {
 		e.fetch()
@@ -196,7 +290,7 @@ func (e *Encoder) write(out []int32) {
 
 		if len(e.current.Data) == 0 {
 			// notify the sender that the data has been sent
-			e.current.Done <- true
+			e.finishLocked(*e.current, true)
 			e.current = nil
 			e.fetch()
 		}
@@ -213,18 +307,39 @@ func (e *Encoder) write(out []int32) {
 	}
 }
 
-// sends data to the device and returns a boolean channel to indicate whether the data has been sent or cancelled
+// sends data to the device and returns a boolean channel to indicate whether the data has been sent or cancelled,
+// the frame waits for the ones ahead when the buffer is full
 func (e *Encoder) sendAsync(data []byte) <-chan bool {
-	done := make(chan bool, 1)
-	select {
-	case e.buffer <- EncoderFrame{
+	frame := e.frame(data)
+	frame.Priority = PriorityInteractive
+	if err := e.admit(frame); err != nil {
+		fmt.Printf("[Encoder] Frame refused: %v\n", err)
+		frame.Done <- false
+		return frame.Done
+	}
+	e.pushWait(frame)
+	return frame.Done
+}
+
+// queues an admitted frame, waiting for the ones ahead when the encoder holds BufferSize frames
+func (e *Encoder) pushWait(frame EncoderFrame) {
+	for {
+		e.mutex.Lock()
+		released := e.released
+		e.mutex.Unlock()
+		if e.push(frame) {
+			return
+		}
+		<-released
+	}
+}
+
+// the modulated frame of the data
+func (e *Encoder) frame(data []byte) EncoderFrame {
+	return EncoderFrame{
 		Data: e.Modulator.Modulate(data),
-		Done: done,
-	}:
-	default:
-		panic("outputBuffer is full")
+		Done: make(chan bool, 1),
 	}
-	return done
 }
 
 func (b *PowerMonitor) Update(in []int32) {
@@ -232,26 +347,45 @@ func (b *PowerMonitor) Update(in []int32) {
 	if b.WindowSize == 0 {
 		return
 	}
-	maxsum := fixed.Zero
-	for i := range in {
-		v := fixed.T(in[i] >> fixed.N)
-		if v < 0 {
-			v = -v
-		}
-		if len(b.latest) >= b.WindowSize {
-			b.sum -= b.latest[0]
-			b.latest = b.latest[1:]
-		}
-		b.latest = append(b.latest, v)
-		b.sum += v
-		if b.sum > maxsum {
+	for _, sample := range in {
+		b.slide(sample)
+		// the power holds over the silence, e.g. the intervals between the frames
+		if b.sum > 0 {
 			b.Power = b.sum.Div(fixed.FromInt(b.WindowSize))
 		}
 	}
+	b.notify()
+
+}
+
+// updates the power with one sample and returns whether the medium is busy,
+// the power does not hold over the silence as the CSMA waits for DIFS instead
+func (b *PowerMonitor) update(sample int32) bool {
+	if b.WindowSize == 0 {
+		return false
+	}
+	b.slide(sample)
+	b.Power = b.sum.Div(fixed.FromInt(b.WindowSize))
+	return b.IsBusy()
+}
+
+func (b *PowerMonitor) slide(sample int32) {
+	v := fixed.T(sample >> fixed.N)
+	if v < 0 {
+		v = -v
+	}
+	if len(b.latest) >= b.WindowSize {
+		b.sum -= b.latest[0]
+		b.latest = b.latest[1:]
+	}
+	b.latest = append(b.latest, v)
+	b.sum += v
+}
+
+func (b *PowerMonitor) notify() {
 	if !b.IsBusy() {
 		b.notBusy.Notify()
 	}
-
 }
 
 func (b *PowerMonitor) NotBusySignal() <-chan struct{} {
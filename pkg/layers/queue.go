@@ -0,0 +1,211 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Priority is the class of service of a frame in the Encoder
+type Priority int
+
+const (
+	PriorityControl     Priority = iota // the ACKs and the control frames, which the others wait for
+	PriorityInteractive                 // the packets of one frame, the default of SendAsync
+	PriorityBulk                        // the frames of the long packets
+	NumPriorities                       // the number of the classes
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityControl:
+		return "control"
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityBulk:
+		return "bulk"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// QueuePolicy tells what happens to a frame whose class is full
+type QueuePolicy int
+
+const (
+	QueueBlock QueuePolicy = iota // the sender waits for room up to the Timeout of the class, then fails with ErrBackpressure
+	QueueDrop                     // the frame is dropped at once with ErrQueueFull
+)
+
+func ParseQueuePolicy(name string) (QueuePolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "block":
+		return QueueBlock, nil
+	case "drop":
+		return QueueDrop, nil
+	default:
+		return QueueBlock, fmt.Errorf("unknown queue policy %s, expected 'block' or 'drop'", name)
+	}
+}
+
+var (
+	ErrQueueFull    = errors.New("the queue of the class is full")
+	ErrBackpressure = errors.New("no room in the queue of the class before the timeout")
+)
+
+// QueueClass limits the frames of a priority which are queued, i.e. waiting for the medium or the device or being sent
+type QueueClass struct {
+	Limit   int // 0 for no limit
+	Policy  QueuePolicy
+	Timeout time.Duration // the longest wait for room with QueueBlock, 0 to wait as long as it takes
+	Weight  int           // the frames sent in a row in the weighted scheduling, 0 means 1
+}
+
+type EncoderStats struct {
+	Sent      [NumPriorities]int
+	Refused   [NumPriorities]int // dropped or timed out as their class is full
+	Cancelled [NumPriorities]int
+}
+
+// counts the frame in its class, waiting for room with QueueBlock
+func (e *Encoder) admit(frame EncoderFrame) error {
+	class := e.Classes[frame.Priority]
+	var timeout <-chan time.Time
+	if class.Timeout > 0 {
+		timer := time.NewTimer(class.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.admitted == nil {
+		// the encoder is not opened yet
+		e.admitted = make(map[<-chan bool]EncoderFrame)
+		e.released = make(chan struct{})
+	}
+	for class.Limit > 0 && e.queued[frame.Priority] >= class.Limit {
+		if class.Policy == QueueDrop {
+			e.stats.Refused[frame.Priority]++
+			return fmt.Errorf("%v frame: %w", frame.Priority, ErrQueueFull)
+		}
+		released := e.released
+		e.mutex.Unlock()
+		select {
+		case <-released:
+			e.mutex.Lock()
+		case <-timeout:
+			e.mutex.Lock()
+			e.stats.Refused[frame.Priority]++
+			return fmt.Errorf("%v frame after %v: %w", frame.Priority, class.Timeout, ErrBackpressure)
+		}
+	}
+	e.queued[frame.Priority]++
+	e.admitted[frame.Done] = EncoderFrame{Done: frame.Done, Priority: frame.Priority}
+	return nil
+}
+
+// notifies the sender of an admitted frame, which is not counted anymore, false if it is done already
+func (e *Encoder) finish(frame EncoderFrame, sent bool) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.finishLocked(frame, sent)
+}
+
+func (e *Encoder) finishLocked(frame EncoderFrame, sent bool) bool {
+	admitted, ok := e.admitted[frame.Done]
+	if !ok {
+		return false
+	}
+	priority := admitted.Priority
+	delete(e.admitted, frame.Done)
+	e.queued[priority]--
+	if sent {
+		e.stats.Sent[priority]++
+	} else {
+		e.stats.Cancelled[priority]++
+	}
+	frame.Done <- sent
+	close(e.released)
+	e.released = make(chan struct{})
+	return true
+}
+
+// queues an admitted frame for the device, false if the encoder holds BufferSize frames ahead of the current one
+func (e *Encoder) push(frame EncoderFrame) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if _, ok := e.admitted[frame.Done]; !ok {
+		// cancelled while waiting for the medium
+		return true
+	}
+	if e.ready() >= e.BufferSize {
+		return false
+	}
+	e.queues[frame.Priority] = append(e.queues[frame.Priority], frame)
+	return true
+}
+
+// the frames queued for the device
+func (e *Encoder) ready() int {
+	n := 0
+	for _, queue := range e.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// takes the next frame for the device, strictly by priority or in turns of the weights of the classes
+func (e *Encoder) next() (EncoderFrame, bool) {
+	if !e.Weighted {
+		for priority, queue := range e.queues {
+			if len(queue) > 0 {
+				e.queues[priority] = queue[1:]
+				return queue[0], true
+			}
+		}
+		return EncoderFrame{}, false
+	}
+	for range 2 * NumPriorities {
+		queue := e.queues[e.turn]
+		if len(queue) > 0 && e.served < max(e.Classes[e.turn].Weight, 1) {
+			e.queues[e.turn] = queue[1:]
+			e.served++
+			return queue[0], true
+		}
+		e.turn = (e.turn + 1) % NumPriorities
+		e.served = 0
+	}
+	return EncoderFrame{}, false
+}
+
+// removes a frame which is queued or being sent, the frame being sent is cut off
+func (e *Encoder) cancel(done <-chan bool) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.current != nil && e.current.Done == done {
+		frame := *e.current
+		e.current = nil
+		return e.finishLocked(frame, false)
+	}
+	for priority, queue := range e.queues {
+		for i, frame := range queue {
+			if frame.Done == done {
+				e.queues[priority] = append(queue[:i:i], queue[i+1:]...)
+				return e.finishLocked(frame, false)
+			}
+		}
+	}
+	if frame, ok := e.admitted[done]; ok {
+		// waiting for the medium
+		return e.finishLocked(frame, false)
+	}
+	return false
+}
+
+func (e *Encoder) Stats() EncoderStats {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.stats
+}
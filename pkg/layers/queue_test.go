@@ -0,0 +1,107 @@
+package layers
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEncoderQueues(t *testing.T) {
+	queue := func(e *Encoder, priority Priority) EncoderFrame {
+		frame := EncoderFrame{Done: make(chan bool, 1), Priority: priority}
+		if err := e.admit(frame); err != nil {
+			t.Fatal(err)
+		}
+		if !e.push(frame) {
+			t.Fatalf("The %v frame is not pushed", priority)
+		}
+		return frame
+	}
+	order := func(e *Encoder) []Priority {
+		priorities := []Priority{}
+		for {
+			frame, ok := e.next()
+			if !ok {
+				return priorities
+			}
+			e.finish(frame, true)
+			priorities = append(priorities, frame.Priority)
+		}
+	}
+	equal := func(a, b []Priority) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	// the control frames go first however late they come
+	strict := &Encoder{BufferSize: 10}
+	strict.Init()
+	for _, priority := range []Priority{PriorityBulk, PriorityBulk, PriorityInteractive, PriorityControl} {
+		queue(strict, priority)
+	}
+	if got := order(strict); !equal(got, []Priority{PriorityControl, PriorityInteractive, PriorityBulk, PriorityBulk}) {
+		t.Errorf("Strict order %v", got)
+	}
+
+	// the bulk frames get their turn among the others
+	weighted := &Encoder{BufferSize: 10, Weighted: true}
+	weighted.Classes[PriorityControl].Weight = 2
+	weighted.Init()
+	for range 3 {
+		queue(weighted, PriorityBulk)
+		queue(weighted, PriorityControl)
+	}
+	if got := order(weighted); !equal(got, []Priority{PriorityControl, PriorityControl, PriorityBulk, PriorityControl, PriorityBulk, PriorityBulk}) {
+		t.Errorf("Weighted order %v", got)
+	}
+	if stats := weighted.Stats(); stats.Sent[PriorityControl] != 3 || stats.Sent[PriorityBulk] != 3 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// a full class drops its frames or makes the sender wait, the others are not held back
+	limited := &Encoder{BufferSize: 10}
+	limited.Classes[PriorityBulk] = QueueClass{Limit: 1, Policy: QueueDrop}
+	limited.Classes[PriorityInteractive] = QueueClass{Limit: 1, Timeout: 50 * time.Millisecond}
+	limited.Init()
+	queue(limited, PriorityBulk)
+	interactive := queue(limited, PriorityInteractive)
+	queue(limited, PriorityControl)
+	if err := limited.admit(EncoderFrame{Done: make(chan bool, 1), Priority: PriorityBulk}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("The bulk frame is not dropped: %v", err)
+	}
+	if err := limited.admit(EncoderFrame{Done: make(chan bool, 1), Priority: PriorityInteractive}); !errors.Is(err, ErrBackpressure) {
+		t.Errorf("The interactive frame does not time out: %v", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		limited.cancel(interactive.Done)
+	}()
+	if err := limited.admit(EncoderFrame{Done: make(chan bool, 1), Priority: PriorityInteractive}); err != nil {
+		t.Errorf("The interactive frame is refused after the room is made: %v", err)
+	}
+	if stats := limited.Stats(); stats.Refused[PriorityBulk] != 1 || stats.Refused[PriorityInteractive] != 1 || stats.Cancelled[PriorityInteractive] != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// a frame is cancelled in its queue, the others are kept
+	cancelled := &Encoder{BufferSize: 10}
+	cancelled.Init()
+	first := queue(cancelled, PriorityBulk)
+	second := queue(cancelled, PriorityBulk)
+	if !cancelled.cancel(first.Done) || <-first.Done {
+		t.Error("The queued frame is not cancelled")
+	}
+	if cancelled.cancel(first.Done) {
+		t.Error("The frame is cancelled twice")
+	}
+	if frame, ok := cancelled.next(); !ok || frame.Done != second.Done || cancelled.ready() != 0 {
+		t.Error("The other frame is not kept")
+	}
+}
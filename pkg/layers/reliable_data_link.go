@@ -2,68 +2,68 @@ package layers
 
 import (
 	"Aethernet/pkg/async"
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/modem"
+	"encoding/binary"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/exp/rand"
 )
 
-type ReliableDataLinkAddress uint8
-
-type ReliableDataLinkType uint8
+type ReliableDataLinkControl uint8
 
 const (
-	ReliableDataLinkTypeData ReliableDataLinkType = iota
-	ReliableDataLinkTypeACK
+	ReliableDataLinkControlRTS ReliableDataLinkControl = iota
+	ReliableDataLinkControlCTS
+	ReliableDataLinkControlToken
+	ReliableDataLinkControlHello // the beacon of the neighbor discovery, sent to ReliableDataLinkBroadcast
 )
 
-// Source (3 bit) | Destination (3 bit) | Type (1 bit) | IsLast (1 bit) | Index (8 bit)
-type ReliableDataLinkHeader struct {
-	Source      ReliableDataLinkAddress
-	Destination ReliableDataLinkAddress
-	Type        ReliableDataLinkType
-	IsLast      bool
-	Index       uint8
+// Control (8 bit) | Duration (32 bit), or Control (8 bit) | Sequence (16 bit) | Capabilities (16 bit) for a hello
+type ReliableDataLinkControlFrame struct {
+	Control      ReliableDataLinkControl
+	Duration     int // the samples the medium is reserved for after an RTS or CTS
+	Sequence     uint16
+	Capabilities NeighborCapabilities
 }
 
-func (m ReliableDataLinkHeader) Validate() {
-	if m.Source&0x7 != m.Source {
-		panic("Invalid source address")
-	}
-	if m.Destination&0x7 != m.Destination {
-		panic("Invalid destination address")
-	}
-	if m.Type&0x1 != m.Type {
-		panic("Invalid type")
+func (c ReliableDataLinkControlFrame) ToBytes() []byte {
+	if c.Control == ReliableDataLinkControlHello {
+		data := binary.BigEndian.AppendUint16([]byte{byte(c.Control)}, c.Sequence)
+		return binary.BigEndian.AppendUint16(data, uint16(c.Capabilities))
 	}
+	return binary.BigEndian.AppendUint32([]byte{byte(c.Control)}, uint32(c.Duration))
 }
 
-func (m ReliableDataLinkHeader) ToBytes() []byte {
-	m.Validate()
-	bytes := []byte{byte(m.Source)<<5 | byte(m.Destination)<<2 | byte(m.Type)<<1, m.Index}
-	if m.IsLast {
-		bytes[0] |= 0x1
+func (c *ReliableDataLinkControlFrame) FromBytes(data []byte) error {
+	if len(data) < c.NumBytes() {
+		return fmt.Errorf("control frame of %d bytes is too short", len(data))
 	}
-	return bytes
-}
-
-func (m *ReliableDataLinkHeader) FromBytes(data []byte) {
-	m.Source = ReliableDataLinkAddress(data[0] >> 5)
-	m.Destination = ReliableDataLinkAddress((data[0] >> 2) & 0x7)
-	m.Type = ReliableDataLinkType((data[0] >> 1) & 0x1)
-	m.IsLast = (data[0] & 0x1) == 1
-	m.Index = data[1]
-	m.Validate()
+	c.Control = ReliableDataLinkControl(data[0])
+	if c.Control > ReliableDataLinkControlHello {
+		return fmt.Errorf("unknown control %d", c.Control)
+	}
+	if c.Control == ReliableDataLinkControlHello {
+		c.Sequence = binary.BigEndian.Uint16(data[1:])
+		c.Capabilities = NeighborCapabilities(binary.BigEndian.Uint16(data[3:]))
+		return nil
+	}
+	c.Duration = int(binary.BigEndian.Uint32(data[1:]))
+	return nil
 }
 
-func (m ReliableDataLinkHeader) NumBytes() int {
-	return 2
+func (c ReliableDataLinkControlFrame) NumBytes() int {
+	return 5
 }
 
 type BackoffTimer interface {
 	GetBackoffTime(retries int) time.Duration
 }
 
+// RandomBackoffTimer draws the backoff uniformly whatever the retries, see ExponentialBackoffTimer for a growing window
 type RandomBackoffTimer struct {
 	MinDelay time.Duration
 	MaxDelay time.Duration
@@ -80,6 +80,7 @@ func (b RandomBackoffTimer) GetBackoffTime(retries int) time.Duration {
 
 type ReliableDataLinkLayer struct {
 	PhysicalLayer
+	Groups
 
 	BytePerFrame int
 	Address      ReliableDataLinkAddress
@@ -87,124 +88,657 @@ type ReliableDataLinkLayer struct {
 	MaxRetries   int
 	BackoffTimer BackoffTimer
 	BufferSize   int
+	Compression  Compression
+	Secure       *Secure       // authenticated encryption with per-peer keys, disabled if nil
+	RTSThreshold int           // the payload size from which a frame reserves the medium with RTS/CTS first, 0 to disable, needs CSMA
+	Token        *TokenPassing // only the holder of the token sends instead of contending for the medium, disabled if nil
+	Header       ReliableDataLinkHeaderFormat
+	Neighbors    *NeighborDiscovery // the table of the nodes heard, with hellos every Interval, disabled if nil
+	ACKDelay     time.Duration      // how long an ACK waits to ride on a data frame to its destination, 0 to send it at once, needs a versioned header
+	// how long a sender paused by a destination without room waits for its window before the next frame probes it, 0 means the ACKTimeout,
+	// the windows are advertised in the ACKs of the versioned headers
+	WindowProbe time.Duration
+	// the largest message sent, and received once decompressed, DefaultMaxMessageSize if 0, the nodes of a segment must agree on it
+	MaxMessageSize int
 
 	// Send
-	expectedIndex uint8
-	receivedACK   chan uint8
+	messageID    atomic.Uint32
+	receivedACK  chan receivedACK
+	receivedNACK chan receivedNACK
+	receivedCTS  chan ReliableDataLinkAddress
 
 	// Receive
-	currentPacket []byte
-	outputChan    chan []byte
+	reassemblies map[ReliableDataLinkAddress]*reassembly // the packets being received by their sources
+	outputChan   chan []byte
+
+	// the delayed ACKs by the address they are for
+	ackMutex    sync.Mutex
+	delayedACKs map[ReliableDataLinkAddress]*delayedACK
+	ackStats    ACKStats
+
+	// the windows advertised by the destinations
+	windowMutex   sync.Mutex
+	windows       map[ReliableDataLinkAddress]uint8
+	windowUpdated chan struct{} // closed when a window is advertised
+	flowStats     FlowStats
+}
+
+type FlowStats struct {
+	Pauses  int           // the frames which waited for the window of their destination
+	Paused  time.Duration // the time spent waiting
+	Refused int           // the packets received while the output channel was full, which their sources send again
+}
+
+// the packet being received from a source
+type reassembly struct {
+	expectedIndex uint16
+	message       uint8 // versioned headers only
+	packet        []byte
+}
+
+// an ACK from the source for the frame of the index. A standalone ACK of a versioned header tells the message of the frame,
+// a piggybacked one only its index
+type receivedACK struct {
+	source  ReliableDataLinkAddress
+	index   uint16
+	message uint8
+	exact   bool // the message is known
+}
+
+// whether the ACK is for the frame sent with the header, not for a frame of another destination or of an earlier packet
+func (a receivedACK) acknowledges(header ReliableDataLinkHeader) bool {
+	return a.source == header.Destination && a.index == header.Index && (!a.exact || a.message == header.MessageID)
+}
+
+// a NACK from the source, which expects the frame of the index
+type receivedNACK struct {
+	source   ReliableDataLinkAddress
+	expected uint16
+}
+
+type delayedACK struct {
+	message uint8
+	index   uint16
+	timer   *time.Timer
+}
+
+type ACKStats struct {
+	Standalone  int // the ACKs sent in their own frames
+	Piggybacked int // the ACKs carried by data frames
+}
+
+func (m *ReliableDataLinkLayer) ACKStats() ACKStats {
+	m.ackMutex.Lock()
+	defer m.ackMutex.Unlock()
+	return m.ackStats
+}
+
+// the largest message of a layer without MaxMessageSize, room for the files of aethernet send
+const DefaultMaxMessageSize = 16 << 20
+
+func (m *ReliableDataLinkLayer) maxMessageSize() int {
+	if m.MaxMessageSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return m.MaxMessageSize
+}
+
+// a header from this node
+func (m *ReliableDataLinkLayer) header(destination ReliableDataLinkAddress, typ ReliableDataLinkType) ReliableDataLinkHeader {
+	return ReliableDataLinkHeader{
+		Format:      m.Header,
+		Source:      m.Address,
+		Destination: destination,
+		Type:        typ,
+	}
 }
 
 func (m *ReliableDataLinkLayer) Open() {
+	if m.Neighbors != nil {
+		m.Neighbors.init()
+		// the power and the CRC of the frames tell the link quality of their sources
+		trace := m.PhysicalLayer.Decoder.Demodulator.Trace
+		m.PhysicalLayer.Decoder.Demodulator.Trace = func(report modem.FrameReport) {
+			m.Neighbors.trace(m.Header, m.Address, report)
+			if trace != nil {
+				trace(report)
+			}
+		}
+	}
 	m.PhysicalLayer.Open()
-	m.receivedACK = make(chan uint8, 1)
+	m.receivedACK = make(chan receivedACK, 1)
+	m.receivedNACK = make(chan receivedNACK, 1)
+	// seeded from the current time so that the packets after a restart are not taken for the ones before
+	m.messageID.Store(uint32(time.Now().UnixNano()))
+	m.receivedCTS = make(chan ReliableDataLinkAddress, 1)
+	m.delayedACKs = make(map[ReliableDataLinkAddress]*delayedACK)
+	m.windows = make(map[ReliableDataLinkAddress]uint8)
+	m.windowUpdated = make(chan struct{})
 	m.outputChan = make(chan []byte, m.BufferSize)
+	if m.Token != nil {
+		m.Token.init()
+		go m.tokenLoop()
+	}
+	if m.Neighbors != nil {
+		go m.neighborLoop()
+	}
 	go func() {
 		for packet := range m.PhysicalLayer.ReceiveAsync() {
-			header := ReliableDataLinkHeader{}
-			header.FromBytes(packet[:header.NumBytes()])
-			if header.Destination == m.Address {
-				if len(packet) < header.NumBytes() {
-					fmt.Printf("[MAC%x] Packet is too short, dropping\n", m.Address)
-					panic("Packet is too short")
+			header := ReliableDataLinkHeader{Format: m.Header}
+			if err := header.FromBytes(packet); err != nil {
+				fmt.Printf("[MAC%x] Dropping packet: %v\n", m.Address, err)
+				continue
+			}
+			if m.Token != nil && header.Source != m.Address {
+				m.Token.hear(header.Source)
+			}
+			if m.Neighbors != nil && header.Source != m.Address {
+				m.Neighbors.hear(header.Source)
+			}
+			if header.IsControl() {
+				// the control frames of others set the NAV
+				m.control(header, packet[header.NumBytes():])
+			} else if header.Type == ReliableDataLinkTypeKeepalive {
+				// only tells that the source is alive
+			} else if header.Destination == ReliableDataLinkBroadcast {
+				if header.Source != m.Address && header.Type == ReliableDataLinkTypeData && m.IsMember(Group(header.Index)) {
+					m.deliver(header, packet[header.NumBytes():])
 				}
+			} else if header.Destination == m.Address {
 				m.handle(header, packet[header.NumBytes():])
 			}
 		}
 	}()
 }
 
-func (m *ReliableDataLinkLayer) sendACK(address ReliableDataLinkAddress, index uint8) {
+func (m *ReliableDataLinkLayer) sendACK(address ReliableDataLinkAddress, message uint8, index uint16) {
 	// <-m.PowerFreeSignal()
-	m.PhysicalLayer.Send(ReliableDataLinkHeader{
-		Source:      m.Address,
-		Destination: address,
-		Type:        ReliableDataLinkTypeACK,
-		Index:       index,
-	}.ToBytes())
+	header := m.header(address, ReliableDataLinkTypeACK)
+	header.Index = index
+	header.MessageID = message
+	m.advertise(&header)
+	data, err := header.ToBytes()
+	if err != nil {
+		fmt.Printf("[MAC%x] Failed to make ACK: %v\n", m.Address, err)
+		return
+	}
+	m.PhysicalLayer.Reply(data)
+	m.ackMutex.Lock()
+	m.ackStats.Standalone++
+	m.ackMutex.Unlock()
 	fmt.Printf("[MAC%x] ACK for packet %d sent\n", m.Address, index)
 }
 
+// acknowledges a frame from the address, at once or after the ACKDelay unless a data frame to the address carries the ACK first,
+// a later ACK to the same address replaces the delayed one as it acknowledges the last frame
+func (m *ReliableDataLinkLayer) acknowledge(address ReliableDataLinkAddress, message uint8, index uint16, delay bool) {
+	m.ackMutex.Lock()
+	if pending, ok := m.delayedACKs[address]; ok {
+		pending.timer.Stop()
+		delete(m.delayedACKs, address)
+	}
+	if !delay || m.ACKDelay == 0 || m.Header == ReliableDataLinkHeaderCompact {
+		m.ackMutex.Unlock()
+		go m.sendACK(address, message, index)
+		return
+	}
+	pending := &delayedACK{message: message, index: index}
+	pending.timer = time.AfterFunc(m.ACKDelay, func() {
+		m.ackMutex.Lock()
+		if m.delayedACKs[address] != pending {
+			// taken by a data frame or replaced
+			m.ackMutex.Unlock()
+			return
+		}
+		delete(m.delayedACKs, address)
+		m.ackMutex.Unlock()
+		m.sendACK(address, message, index)
+	})
+	m.delayedACKs[address] = pending
+	m.ackMutex.Unlock()
+}
+
+// takes the delayed ACK for the address to carry it on a data frame, false if there is none
+func (m *ReliableDataLinkLayer) takeACK(address ReliableDataLinkAddress) (uint16, bool) {
+	m.ackMutex.Lock()
+	defer m.ackMutex.Unlock()
+	pending, ok := m.delayedACKs[address]
+	if !ok {
+		return 0, false
+	}
+	pending.timer.Stop()
+	delete(m.delayedACKs, address)
+	m.ackStats.Piggybacked++
+	return pending.index, true
+}
+
+// tells the source that its frame is not taken and which index is expected instead, versioned headers only
+func (m *ReliableDataLinkLayer) sendNACK(address ReliableDataLinkAddress, expected uint16) {
+	header := m.header(address, ReliableDataLinkTypeNACK)
+	header.Index = expected
+	m.advertise(&header)
+	data, err := header.ToBytes()
+	if err != nil {
+		fmt.Printf("[MAC%x] Failed to make NACK: %v\n", m.Address, err)
+		return
+	}
+	m.PhysicalLayer.Reply(data)
+	fmt.Printf("[MAC%x] NACK to %x sent, expecting packet %d\n", m.Address, address, expected)
+}
+
+func (m *ReliableDataLinkLayer) sendControl(address ReliableDataLinkAddress, frame ReliableDataLinkControlFrame) <-chan bool {
+	header, err := m.header(address, ReliableDataLinkTypeControl).ToBytes()
+	if err != nil {
+		fmt.Printf("[MAC%x] Failed to make control frame: %v\n", m.Address, err)
+		failed := make(chan bool, 1)
+		failed <- false
+		return failed
+	}
+	data := append(header, frame.ToBytes()...)
+	if frame.Control != ReliableDataLinkControlRTS {
+		return m.PhysicalLayer.ReplyAsync(data)
+	}
+	sent, err := m.PhysicalLayer.SendPriorityAsync(data, PriorityControl)
+	if err != nil {
+		fmt.Printf("[MAC%x] Failed to send control frame: %v\n", m.Address, err)
+		failed := make(chan bool, 1)
+		failed <- false
+		return failed
+	}
+	return sent
+}
+
+// the samples between the end of a frame and the start of its reply, i.e. SIFS after the buffer of the device where the frame ends is decoded
+func (m *ReliableDataLinkLayer) turnaround() int {
+	return m.CSMA.SIFS + 2*device.BufferSize
+}
+
+func (m *ReliableDataLinkLayer) control(header ReliableDataLinkHeader, data []byte) {
+	frame := ReliableDataLinkControlFrame{}
+	if err := frame.FromBytes(data); err != nil {
+		fmt.Printf("[MAC%x] Dropping control frame from %x: %v\n", m.Address, header.Source, err)
+		return
+	}
+	if frame.Control == ReliableDataLinkControlToken {
+		if header.Destination == m.Address && m.Token != nil && !m.Token.receive() {
+			fmt.Printf("[MAC%x] Duplicate token from %x dropped\n", m.Address, header.Source)
+		}
+		return
+	}
+	if frame.Control == ReliableDataLinkControlHello {
+		if m.Neighbors != nil && header.Source != m.Address {
+			m.Neighbors.hello(header.Source, frame)
+		}
+		return
+	}
+	if m.CSMA == nil {
+		return
+	}
+	if header.Destination != m.Address {
+		fmt.Printf("[MAC%x] Medium reserved by %x for %d samples\n", m.Address, header.Source, frame.Duration)
+		m.CSMA.Reserve(frame.Duration)
+		return
+	}
+	switch frame.Control {
+	case ReliableDataLinkControlRTS:
+		if m.CSMA.Reserved() {
+			fmt.Printf("[MAC%x] RTS from %x while the medium is reserved, no CTS\n", m.Address, header.Source)
+			return
+		}
+		// the rest of the reservation after the CTS, during which no other RTS is answered
+		duration := frame.Duration - m.turnaround() - m.Airtime(header.NumBytes()+frame.NumBytes())
+		m.CSMA.Reserve(frame.Duration)
+		go func() {
+			<-m.sendControl(header.Source, ReliableDataLinkControlFrame{Control: ReliableDataLinkControlCTS, Duration: max(duration, 0)})
+			fmt.Printf("[MAC%x] CTS for %x sent\n", m.Address, header.Source)
+		}()
+	case ReliableDataLinkControlCTS:
+		select {
+		case m.receivedCTS <- header.Source:
+		default:
+			fmt.Printf("[MAC%x] CTS channel is full, dropping CTS from %x\n", m.Address, header.Source)
+		}
+	}
+}
+
+// reserves the medium with RTS/CTS for a frame of the given size and its ACK, returns whether the CTS is received
+func (m *ReliableDataLinkLayer) reserve(address ReliableDataLinkAddress, size int) bool {
+	// a CTS of a previous attempt is stale
+	select {
+	case <-m.receivedCTS:
+	default:
+	}
+
+	control := m.Header.NumBytes() + ReliableDataLinkControlFrame{}.NumBytes()
+	ack := m.Header.NumBytes()
+	duration := 3*m.turnaround() + m.Airtime(control) + m.Airtime(size) + m.Airtime(ack)
+	if !<-m.sendControl(address, ReliableDataLinkControlFrame{Control: ReliableDataLinkControlRTS, Duration: duration}) {
+		return false
+	}
+	fmt.Printf("[MAC%x] RTS for %x sent, reserving %d samples\n", m.Address, address, duration)
+
+	timeout := time.After(m.ACKTimeout)
+	for {
+		select {
+		case source := <-m.receivedCTS:
+			if source == address {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// passes a fully received packet to the output channel, false if a packet to this node is not taken as the channel is full,
+// which its source sends again after the NACK, or after the ACK timeout with the compact header. The broadcasts are dropped instead.
+// A packet which is not taken is not recorded against the replays, so it is opened again when it is sent again
+func (m *ReliableDataLinkLayer) deliver(header ReliableDataLinkHeader, packet []byte) bool {
+	unicast := header.Destination != ReliableDataLinkBroadcast
+	if unicast && cap(m.outputChan) > 0 && len(m.outputChan) == cap(m.outputChan) {
+		// only the receiving loop fills the channel, so the packet is refused before it is opened
+		return false
+	}
+	sealed := packet
+	if m.Secure != nil {
+		if header.Source > 0xff {
+			fmt.Printf("[MAC%x] Dropping packet from %x: the secure mode needs 8 bit addresses\n", m.Address, header.Source)
+			return true
+		}
+		peer := byte(header.Source)
+		if header.Destination == ReliableDataLinkBroadcast {
+			peer = SecureGroupKey
+		}
+		sealed, err := header.sealed()
+		if err == nil {
+			packet, err = m.Secure.Peek(peer, byte(header.Source), byte(header.Destination), header.sequence(), sealed, packet)
+		}
+		if err != nil {
+			fmt.Printf("[MAC%x] Dropping packet from %x: %v\n", m.Address, header.Source, err)
+			return true
+		}
+	}
+	if m.Compression != CompressionNone {
+		var err error
+		packet, err = DecodeCompressed(packet, m.maxMessageSize())
+		if err != nil {
+			fmt.Printf("[MAC%x] Failed to decompress packet: %v\n", m.Address, err)
+			m.accept(header, sealed)
+			return true
+		}
+	}
+	select {
+	case m.outputChan <- packet:
+		fmt.Printf("[MAC%x] Packet of length %d received\n", m.Address, len(packet))
+	default:
+		if unicast {
+			// no application waits on the unbuffered channel
+			return false
+		}
+		fmt.Printf("[MAC%x] Output channel is full, dropping packet from %x\n", m.Address, header.Source)
+	}
+	m.accept(header, sealed)
+	return true
+}
+
+// records the sealed packet opened by deliver against the replays
+func (m *ReliableDataLinkLayer) accept(header ReliableDataLinkHeader, sealed []byte) {
+	if m.Secure != nil {
+		m.Secure.Accept(byte(header.Source), sealed)
+	}
+}
+
+// the packet being received from the source, the receiving loop only
+func (m *ReliableDataLinkLayer) reassembly(source ReliableDataLinkAddress) *reassembly {
+	if m.reassemblies == nil {
+		m.reassemblies = make(map[ReliableDataLinkAddress]*reassembly)
+	}
+	r, ok := m.reassemblies[source]
+	if !ok {
+		r = &reassembly{}
+		m.reassemblies[source] = r
+	}
+	return r
+}
+
 func (m *ReliableDataLinkLayer) handle(header ReliableDataLinkHeader, data []byte) {
 
+	mask := m.Header.indexMask()
+	if header.Flags&ReliableDataLinkFlagWindow != 0 {
+		m.updateWindow(header.Source, header.Window)
+	}
 	switch header.Type {
 	case ReliableDataLinkTypeData:
-		if header.Index == m.expectedIndex {
-			m.currentPacket = append(m.currentPacket, data...)
-			fmt.Printf("[MAC%x] Append packet %d, length %d, total %d\n", m.Address, header.Index, len(data), len(m.currentPacket))
-			m.expectedIndex++
+		if header.Flags&ReliableDataLinkFlagACK != 0 {
+			fmt.Printf("[MAC%x] ACK for packet %d piggybacked by %x\n", m.Address, header.ACK, header.Source)
+			m.receiveACK(receivedACK{source: header.Source, index: header.ACK})
+		}
+		// each source has its packet, and the versioned headers tell the packets of a source apart
+		r := m.reassembly(header.Source)
+		versioned := m.Header != ReliableDataLinkHeaderCompact
+		current := !versioned || header.MessageID == r.message
+		if versioned && !current && header.Index == 0 {
+			if len(r.packet) > 0 {
+				fmt.Printf("[MAC%x] Packet %d of %x restarts the reception, dropping %d bytes of packet %d\n", m.Address, header.MessageID, header.Source, len(r.packet), r.message)
+			}
+			r.expectedIndex, r.packet, r.message = 0, nil, header.MessageID
+			current = true
+		}
+
+		if current && header.Index == r.expectedIndex {
+			packet := append(r.packet, data...)
+			if header.IsLast && !m.deliver(header, packet) {
+				// the packet is not taken until the application reads, the source sends the frame again
+				fmt.Printf("[MAC%x] Output channel is full, packet %d from %x is not taken\n", m.Address, header.Index, header.Source)
+				m.windowMutex.Lock()
+				m.flowStats.Refused++
+				m.windowMutex.Unlock()
+				if versioned {
+					go m.sendNACK(header.Source, header.Index)
+				}
+				// otherwise the frame is not acknowledged and the source sends it again after the ACK timeout
+				break
+			}
+			r.packet = packet
+			fmt.Printf("[MAC%x] Append packet %d from %x, length %d, total %d\n", m.Address, header.Index, header.Source, len(data), len(r.packet))
+			r.expectedIndex = (r.expectedIndex + 1) & mask
 			if header.IsLast {
-				m.expectedIndex = 0
-				select {
-				case m.outputChan <- m.currentPacket:
-					fmt.Printf("[MAC%x] Packet %d received\n", m.Address, header.Index)
-				default:
-					fmt.Printf("[MAC%x] Output channel is full\n", m.Address)
-					panic("Output channel is full")
+				r.packet = nil
+				if !versioned {
+					r.expectedIndex = 0
 				}
-				m.currentPacket = nil
+				// otherwise a duplicate of the last frame is still acknowledged, and the next packet restarts the reception
 			}
-			go m.sendACK(header.Source, header.Index)
-		} else if header.Index == m.expectedIndex-1 {
+			m.acknowledge(header.Source, header.MessageID, header.Index, true)
+		} else if current && header.Index == (r.expectedIndex-1)&mask {
+			// the source missed the ACK, so it is not delayed again
 			fmt.Printf("[MAC%x] Packet %d is a duplicate, resending ACK\n", m.Address, header.Index)
-			go m.sendACK(header.Source, header.Index)
+			m.acknowledge(header.Source, header.MessageID, header.Index, false)
 		} else {
-			fmt.Printf("[MAC%x] Packet %d is not expected, expected %d\n", m.Address, header.Index, m.expectedIndex)
+			fmt.Printf("[MAC%x] Packet %d from %x is not expected, expected %d\n", m.Address, header.Index, header.Source, r.expectedIndex)
+			if versioned {
+				go m.sendNACK(header.Source, r.expectedIndex)
+			}
 		}
-	case ReliableDataLinkTypeACK:
-		// check the index with the current sending packet
+	case ReliableDataLinkTypeNACK:
 		select {
-		case m.receivedACK <- header.Index:
-			// Someone is waiting for the ACK
+		case m.receivedNACK <- receivedNACK{source: header.Source, expected: header.Index}:
 		default:
-			for i := 0; i < len(m.receivedACK); i++ {
-				index := <-m.receivedACK
-				fmt.Printf("[MAC%x] ACK channel is full, dropping packet %d\n", m.Address, index)
-				if index != header.Index {
-					m.receivedACK <- index
-				}
-			}
-			select {
-			case m.receivedACK <- header.Index:
-			default:
-				panic("ACK channel is full")
-			}
+			fmt.Printf("[MAC%x] NACK channel is full, dropping NACK from %x\n", m.Address, header.Source)
 		}
+	case ReliableDataLinkTypeACK:
+		m.receiveACK(receivedACK{source: header.Source, index: header.Index, message: header.MessageID, exact: m.Header != ReliableDataLinkHeaderCompact})
+	}
+}
 
+// passes an ACK to the Send waiting for it
+func (m *ReliableDataLinkLayer) receiveACK(ack receivedACK) {
+	// check the index with the current sending packet
+	select {
+	case m.receivedACK <- ack:
+		// Someone is waiting for the ACK
+	default:
+		for i := 0; i < len(m.receivedACK); i++ {
+			stale := <-m.receivedACK
+			fmt.Printf("[MAC%x] ACK channel is full, dropping packet %d from %x\n", m.Address, stale.index, stale.source)
+			if stale != ack {
+				m.receivedACK <- stale
+			}
+		}
+		select {
+		case m.receivedACK <- ack:
+		default:
+			panic("ACK channel is full")
+		}
 	}
 }
 
-func (m *ReliableDataLinkLayer) Send(address ReliableDataLinkAddress, data []byte) error {
-	// TODO: lock the sending process, so that only one sending process is allowed to call this function at a time
+// sets the default payload length and compresses and seals the data sent with the header
+func (m *ReliableDataLinkLayer) encode(header ReliableDataLinkHeader, data []byte) ([]byte, error) {
+	address := header.Destination
 	// packetLength := m.PhysicalLayer.Encoder.Modulator.BytePerFrame - MACHeader{}.NumBytes()
 	if m.BytePerFrame == 0 {
-		m.BytePerFrame = m.PhysicalLayer.Encoder.Modulator.BytePerFrame - ReliableDataLinkHeader{}.NumBytes()
+		m.BytePerFrame = m.PhysicalLayer.Encoder.Modulator.BytePerFrame - m.Header.NumBytes()
+		if m.ACKDelay > 0 && m.Header != ReliableDataLinkHeaderCompact {
+			// leaves room for a piggybacked ACK and the window
+			m.BytePerFrame -= 3
+		}
 		fmt.Printf("[MAC%x] Payload length is not set, using default value %d", m.Address, m.BytePerFrame)
 	}
 
-	// split the data into packets (do not use physical layer's packet splitting)
-	packets := make([][]byte, 0)
-	header := ReliableDataLinkHeader{
-		Source:      m.Address,
-		Destination: address,
-		Type:        ReliableDataLinkTypeData,
+	if m.Compression != CompressionNone {
+		compressed := m.Compression.Encode(data)
+		fmt.Printf("[MAC%x] Compressed %d bytes to %d bytes\n", m.Address, len(data), len(compressed))
+		data = compressed
+	}
+
+	if m.Secure != nil {
+		if m.Address > 0xff || address > 0xff && address != ReliableDataLinkBroadcast {
+			return nil, fmt.Errorf("the secure mode needs 8 bit addresses, got %x to %x", m.Address, address)
+		}
+		peer := byte(address)
+		if address == ReliableDataLinkBroadcast {
+			peer = SecureGroupKey
+		}
+		aad, err := header.sealed()
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := m.Secure.Seal(peer, byte(m.Address), byte(address), header.sequence(), aad, data)
+		if err != nil {
+			return nil, err
+		}
+		data = sealed
+	}
+	return data, nil
+}
+
+// the frame of a data header and its payload, the header takes the delayed ACK for its destination if the frame has room for it
+func (m *ReliableDataLinkLayer) piggyback(header *ReliableDataLinkHeader, payload []byte) ([]byte, error) {
+	withACK := *header
+	withACK.Flags |= ReliableDataLinkFlagACK
+	m.advertise(&withACK)
+	if m.ACKDelay > 0 && m.Header != ReliableDataLinkHeaderCompact && withACK.NumBytes()+len(payload) <= m.PhysicalLayer.Encoder.Modulator.BytePerFrame {
+		if index, ok := m.takeACK(header.Destination); ok {
+			withACK.ACK = index
+			*header = withACK
+		}
+	}
+	bytes, err := header.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes, payload...), nil
+}
+
+// sends the data to the nodes which joined the group in one frame without ACK, so it may be lost
+func (m *ReliableDataLinkLayer) Multicast(group Group, data []byte) error {
+	header := m.header(ReliableDataLinkBroadcast, ReliableDataLinkTypeData)
+	header.IsLast = true
+	header.Index = uint16(group)
+	header.MessageID = uint8(m.messageID.Add(1))
+	data, err := m.encode(header, data)
+	if err != nil {
+		return err
+	}
+	if len(data) > m.BytePerFrame {
+		return fmt.Errorf("broadcast packet of %d bytes does not fit in a frame of %d bytes", len(data), m.BytePerFrame)
+	}
+	packet, err := header.ToBytes()
+	if err != nil {
+		return err
+	}
+	packet = append(packet, data...)
+
+	var sent <-chan bool
+	if m.Token != nil {
+		m.Token.enter()
+		defer m.Token.leave()
+		m.Token.acquire()
+		sent = m.PhysicalLayer.ReplyAsync(packet)
+		defer m.Token.finish()
+	} else {
+		sent = m.PhysicalLayer.SendAsync(packet)
+	}
+	if !<-sent {
+		return fmt.Errorf("broadcast packet to group %d cancelled", group)
 	}
+	fmt.Printf("[MAC%x] Packet of length %d sent to group %d\n", m.Address, len(data), group)
+	return nil
+}
+
+// sends the data to the address and waits for the ACKs, or to every node like Multicast to GroupAll with ReliableDataLinkBroadcast
+func (m *ReliableDataLinkLayer) Send(address ReliableDataLinkAddress, data []byte) error {
+	// TODO: lock the sending process, so that only one sending process is allowed to call this function at a time
+	if address == ReliableDataLinkBroadcast {
+		return m.Multicast(GroupAll, data)
+	}
+
+	if len(data) > m.maxMessageSize() {
+		return fmt.Errorf("message of %d bytes is larger than the largest of %d bytes", len(data), m.maxMessageSize())
+	}
+	header := m.header(address, ReliableDataLinkTypeData)
+	header.MessageID = uint8(m.messageID.Add(1))
+	data, err := m.encode(header, data)
+	if err != nil {
+		return err
+	}
+
+	// split the data into packets (do not use physical layer's packet splitting)
+	headers := make([]ReliableDataLinkHeader, 0)
+	payloads := make([][]byte, 0)
 
 	for i := 0; i < len(data); i += m.BytePerFrame {
 		end := min(i+m.BytePerFrame, len(data))
 		if end == len(data) {
 			header.IsLast = true
 		}
-		packet := append(header.ToBytes(), data[i:end]...)
-		fmt.Printf("[MAC%x] Making packet %d, length %d\n", m.Address, header.Index, len(packet))
-		packets = append(packets, packet)
-		header.Index++ // NOTE: this is uint8, so it may overflow
+		if err := header.Validate(); err != nil {
+			return err
+		}
+		fmt.Printf("[MAC%x] Making packet %d, length %d\n", m.Address, header.Index, header.NumBytes()+end-i)
+		headers = append(headers, header)
+		payloads = append(payloads, data[i:end])
+		// wraps around at 256 in the compact header
+		header.Index = (header.Index + 1) & m.Header.indexMask()
 	}
 
-	// send the packets
-	for i, packet := range packets {
+	if m.Token != nil {
+		m.Token.enter()
+		defer m.Token.leave()
+	}
+
+	// the frames of a long packet do not hold back the short ones of the others
+	priority := PriorityInteractive
+	if len(payloads) > 1 {
+		priority = PriorityBulk
+	}
+
+	// send the packets, a NACK may take the sender back to an earlier one
+	rewinds := 0
+frames:
+	for i := 0; i < len(payloads); i++ {
+		payload, header := payloads[i], headers[i]
 		retries := 0
 		backoff := time.Duration(0)
 
@@ -214,16 +748,54 @@ func (m *ReliableDataLinkLayer) Send(address ReliableDataLinkAddress, data []byt
 			var ackReceived chan struct{}
 			var ackStopListening chan struct{}
 			var stopListening chan struct{}
+			var sent <-chan bool
+
+			// a destination without room slows the packets down
+			m.pace(address)
+
+			// carries the delayed ACK for the destination, which is kept for the retries
+			packet, err := m.piggyback(&header, payload)
+			if err != nil {
+				return err
+			}
 
 			// // wait for the physical layer to be not busy
 			// <-m.PowerFreeSignal()
 			m.PowerMonitor.Log()
 			fmt.Printf("[MAC%x] Sending packet %d\t\n", m.Address, i)
 
+			// a NACK of a previous attempt is stale
+			select {
+			case <-m.receivedNACK:
+			default:
+			}
+
+			if m.Token != nil {
+				m.Token.acquire()
+				// the holder of the token does not contend for the medium
+				sent = m.PhysicalLayer.ReplyAsync(packet)
+			} else if m.RTSThreshold > 0 && m.CSMA != nil && len(payload) >= m.RTSThreshold {
+				if !m.reserve(address, len(packet)) {
+					fmt.Printf("[MAC%x] CTS timeout for packet %d\n", m.Address, i)
+					goto retry
+				}
+				// the medium is reserved, the frame follows the CTS after SIFS
+				sent = m.PhysicalLayer.ReplyAsync(packet)
+			} else if sent, err = m.PhysicalLayer.SendPriorityAsync(packet, priority); err != nil {
+				return fmt.Errorf("packet %d: %w", i, err)
+			}
+
 			m.PhysicalLayer.Decoder.Demodulator.ClearErrorSignal()
 			select {
-			case status := <-m.PhysicalLayer.SendAsync(packet):
+			case status := <-sent:
 				fmt.Printf("[MAC%x] Packet %d sent to physical layer status %v\n", m.Address, i, status)
+				if !status {
+					// aborted on a collision heard through the echo canceller
+					if m.BackoffTimer != nil {
+						backoff = m.BackoffTimer.GetBackoffTime(retries)
+					}
+					goto retry
+				}
 
 			case err := <-m.PhysicalLayer.DecodeErrorSignal():
 				fmt.Printf("[MAC%x] Decode error %v while sending packet %d, possibly due to collision\n\n", m.Address, err, i)
@@ -233,7 +805,8 @@ func (m *ReliableDataLinkLayer) Send(address ReliableDataLinkAddress, data []byt
 				} else {
 					backoff = m.BackoffTimer.GetBackoffTime(retries)
 				}
-				m.PhysicalLayer.CancelSend()
+				// the replies queued by the receiving loop are kept
+				m.PhysicalLayer.Cancel(sent)
 				goto retry
 			}
 
@@ -247,13 +820,14 @@ func (m *ReliableDataLinkLayer) Send(address ReliableDataLinkAddress, data []byt
 			go func() {
 				for {
 					select {
-					case index := <-m.receivedACK:
-						if index == uint8(i) {
+					case ack := <-m.receivedACK:
+						if ack.acknowledges(header) {
 							ackReceived <- struct{}{}
 							close(ackStopListening)
 							return
 						} else {
-							fmt.Printf("[MAC%x] ACK for packet %d is not expected, expected %d\n", m.Address, index, i)
+							fmt.Printf("[MAC%x] ACK for packet %d of %d from %x is not expected, expected %d of %d from %x\n",
+								m.Address, ack.index, ack.message, ack.source, i, header.MessageID, address)
 						}
 					case ackStopListening <- struct{}{}:
 						return
@@ -270,6 +844,12 @@ func (m *ReliableDataLinkLayer) Send(address ReliableDataLinkAddress, data []byt
 				// ACK received
 				<-ackStopListening
 				fmt.Printf("[MAC%x] Packet %d ACK received\n", m.Address, i)
+				if m.CSMA != nil {
+					m.CSMA.Success()
+				}
+				if m.Token != nil {
+					m.Token.finish()
+				}
 				break resend
 			case <-time.After(m.ACKTimeout):
 				// ACK timeout
@@ -277,6 +857,39 @@ func (m *ReliableDataLinkLayer) Send(address ReliableDataLinkAddress, data []byt
 				fmt.Printf("[MAC%x] Packet %d ACK timeout retry %d\n", m.Address, i, retries)
 				goto retry
 
+			case nack := <-m.receivedNACK:
+				// the receiver does not take the packet, retry without waiting for the timeout
+				<-ackStopListening
+				if window, ok := m.PeerWindow(address); ok && window == 0 {
+					// the receiver is full, so the frame waits for its window or the probe instead of a backoff, as a retry still
+					fmt.Printf("[MAC%x] Packet %d not taken by the full %x retry %d\n", m.Address, i, nack.source, retries)
+					close(stopListening)
+					if m.Token != nil {
+						m.Token.finish()
+					}
+					if retries >= m.MaxRetries {
+						return fmt.Errorf("packet %d not taken by %x after %d retries", i, address, m.MaxRetries)
+					}
+					retries++
+					continue resend
+				}
+				if back := int((uint16(i) - nack.expected) & m.Header.indexMask()); nack.source == address && back > 0 && back <= i {
+					// the receiver lost the packets it acknowledged, so the frames go on from the one it expects
+					fmt.Printf("[MAC%x] Packet %d NACK from %x, going back to packet %d\n", m.Address, i, nack.source, i-back)
+					close(stopListening)
+					if m.Token != nil {
+						m.Token.finish()
+					}
+					if rewinds >= m.MaxRetries {
+						return fmt.Errorf("packet %d sent back to packet %d by %x after %d retries", i, i-back, address, m.MaxRetries)
+					}
+					rewinds++
+					i -= back + 1
+					continue frames
+				}
+				fmt.Printf("[MAC%x] Packet %d NACK from %x retry %d\n", m.Address, i, nack.source, retries)
+				goto retry
+
 			case err := <-m.PhysicalLayer.DecodeErrorSignal():
 				fmt.Printf("[MAC%x] Decode error %v while waiting for ack of %d, possibly due to collision\n\n", m.Address, err, i)
 				// Collision detected, resend the packet after a random backoff time
@@ -285,7 +898,7 @@ func (m *ReliableDataLinkLayer) Send(address ReliableDataLinkAddress, data []byt
 				} else {
 					backoff = m.BackoffTimer.GetBackoffTime(retries)
 				}
-				m.PhysicalLayer.CancelSend()
+				// the frame is sent already, and the replies queued by the receiving loop are kept
 				goto retry
 			}
 
@@ -295,6 +908,16 @@ func (m *ReliableDataLinkLayer) Send(address ReliableDataLinkAddress, data []byt
 				close(stopListening)
 			}
 
+			if m.Token != nil {
+				m.Token.finish()
+			}
+
+			if m.CSMA != nil {
+				// the physical layer backs off in slots of the doubled contention window instead
+				m.CSMA.Failure()
+				backoff = 0
+			}
+
 			{
 				if retries >= m.MaxRetries {
 					return fmt.Errorf("packet %d ACK timeout after %d retries", i, m.MaxRetries)
@@ -318,6 +941,22 @@ func (m *ReliableDataLinkLayer) Send(address ReliableDataLinkAddress, data []byt
 
 }
 
+func (m *ReliableDataLinkLayer) Close() {
+	if m.Token != nil {
+		close(m.Token.closed)
+	}
+	if m.Neighbors != nil {
+		close(m.Neighbors.closed)
+	}
+	m.ackMutex.Lock()
+	for address, pending := range m.delayedACKs {
+		pending.timer.Stop()
+		delete(m.delayedACKs, address)
+	}
+	m.ackMutex.Unlock()
+	m.PhysicalLayer.Close()
+}
+
 func (m *ReliableDataLinkLayer) SendAsync(address ReliableDataLinkAddress, data []byte) <-chan error {
 	return async.Promise(func() error { return m.Send(address, data) })
 }
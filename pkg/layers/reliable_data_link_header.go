@@ -0,0 +1,305 @@
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type ReliableDataLinkAddress uint16
+
+// the destination of the frames to every node of a group, sent as the largest address of the header which no node takes
+const ReliableDataLinkBroadcast ReliableDataLinkAddress = 0xffff
+
+type ReliableDataLinkType uint8
+
+const (
+	ReliableDataLinkTypeData ReliableDataLinkType = iota
+	ReliableDataLinkTypeACK
+	ReliableDataLinkTypeNACK    // the frame is not taken, the Index is the one expected instead
+	ReliableDataLinkTypeControl // followed by a ReliableDataLinkControlFrame
+	ReliableDataLinkTypeKeepalive
+)
+
+// ReliableDataLinkHeaderFormat is the layout of the header on air, all the nodes of a segment must use the same.
+// The versioned headers tell their version and address size apart, the compact one is kept for the nodes of the first version.
+type ReliableDataLinkHeaderFormat uint8
+
+const (
+	// Source (3 bit) | Destination (3 bit) | Type (1 bit) | IsLast (1 bit) | Index (8 bit)
+	// the index wraps around at 256. Two values the first version left free are reserved on top of its layout:
+	// the address 7 is the broadcast address which no node takes, and an ACK with IsLast, which the first version never sends,
+	// is a control frame (RTS, CTS, token or hello). The nodes of the first version take both for data and ACKs,
+	// so a segment with any of them must not use the broadcasts, RTS/CTS, the token ring or the neighbor discovery.
+	// There is no flow control either: a packet the receiver has no room for is not acknowledged until its source sends it again
+	ReliableDataLinkHeaderCompact ReliableDataLinkHeaderFormat = iota
+	// Version (4 bit) | Wide (1 bit) | Type (3 bit) | Flags (7 bit) | IsLast (1 bit) | Source (8 bit) | Destination (8 bit) | Index (16 bit) | MessageID (8 bit)
+	ReliableDataLinkHeaderV1
+	// the same as ReliableDataLinkHeaderV1 with Wide set and 16 bit addresses
+	ReliableDataLinkHeaderV1Wide
+)
+
+const reliableDataLinkVersion = 1
+
+// the flag of a versioned header which carries the ACK of a frame from the destination, i.e. ACK (16 bit) after the MessageID
+const ReliableDataLinkFlagACK uint8 = 0x1
+
+// the flag of a versioned header which advertises the packets the source can still take, i.e. Window (8 bit) after the ACK if any
+const ReliableDataLinkFlagWindow uint8 = 0x2
+
+var ErrReliableDataLinkHeader = errors.New("invalid reliable data link header")
+
+func (f ReliableDataLinkHeaderFormat) String() string {
+	switch f {
+	case ReliableDataLinkHeaderCompact:
+		return "compact"
+	case ReliableDataLinkHeaderV1:
+		return "v1"
+	case ReliableDataLinkHeaderV1Wide:
+		return "v1_wide"
+	default:
+		return fmt.Sprintf("ReliableDataLinkHeaderFormat(%d)", uint8(f))
+	}
+}
+
+func ParseReliableDataLinkHeaderFormat(name string) (ReliableDataLinkHeaderFormat, error) {
+	switch strings.ToLower(name) {
+	case "", "compact":
+		return ReliableDataLinkHeaderCompact, nil
+	case "v1":
+		return ReliableDataLinkHeaderV1, nil
+	case "v1_wide":
+		return ReliableDataLinkHeaderV1Wide, nil
+	default:
+		return ReliableDataLinkHeaderCompact, fmt.Errorf("unknown header %s, expected 'compact', 'v1' or 'v1_wide'", name)
+	}
+}
+
+func (f ReliableDataLinkHeaderFormat) NumBytes() int {
+	switch f {
+	case ReliableDataLinkHeaderV1:
+		return 7
+	case ReliableDataLinkHeaderV1Wide:
+		return 9
+	default:
+		return 2
+	}
+}
+
+// the largest address of a node, the next one is the broadcast address on air
+func (f ReliableDataLinkHeaderFormat) MaxAddress() ReliableDataLinkAddress {
+	return f.broadcast() - 1
+}
+
+func (f ReliableDataLinkHeaderFormat) broadcast() ReliableDataLinkAddress {
+	switch f {
+	case ReliableDataLinkHeaderV1:
+		return 0xff
+	case ReliableDataLinkHeaderV1Wide:
+		return 0xffff
+	default:
+		return 0x7
+	}
+}
+
+// the mask of the Index, which wraps around
+func (f ReliableDataLinkHeaderFormat) indexMask() uint16 {
+	if f == ReliableDataLinkHeaderCompact {
+		return 0xff
+	}
+	return 0xffff
+}
+
+// a data frame to ReliableDataLinkBroadcast is a whole packet which is not acknowledged and whose Index is the Group
+type ReliableDataLinkHeader struct {
+	Format      ReliableDataLinkHeaderFormat
+	Source      ReliableDataLinkAddress
+	Destination ReliableDataLinkAddress
+	Type        ReliableDataLinkType
+	IsLast      bool
+	Flags       uint8  // the flags of the versioned headers besides IsLast, reserved for the extensions
+	Index       uint16 // the sequence number of the frame in its packet
+	MessageID   uint8  // tells the packets of a source apart, not sent in the compact header
+	ACK         uint16 // the index of a frame from the destination acknowledged on the way, with ReliableDataLinkFlagACK
+	Window      uint8  // the packets the source has room for, with ReliableDataLinkFlagWindow
+}
+
+func (m ReliableDataLinkHeader) Validate() error {
+	if m.Format > ReliableDataLinkHeaderV1Wide {
+		return fmt.Errorf("%w: unknown format %d", ErrReliableDataLinkHeader, m.Format)
+	}
+	if m.Source > m.Format.MaxAddress() {
+		return fmt.Errorf("%w: source address %d does not fit in the %v header", ErrReliableDataLinkHeader, m.Source, m.Format)
+	}
+	if m.Destination > m.Format.MaxAddress() && m.Destination != ReliableDataLinkBroadcast {
+		return fmt.Errorf("%w: destination address %d does not fit in the %v header", ErrReliableDataLinkHeader, m.Destination, m.Format)
+	}
+	if m.Type > ReliableDataLinkTypeKeepalive {
+		return fmt.Errorf("%w: unknown type %d", ErrReliableDataLinkHeader, m.Type)
+	}
+	if m.Format == ReliableDataLinkHeaderCompact {
+		if m.Type != ReliableDataLinkTypeData && m.Type != ReliableDataLinkTypeACK && m.Type != ReliableDataLinkTypeControl {
+			return fmt.Errorf("%w: type %d needs a versioned header", ErrReliableDataLinkHeader, m.Type)
+		}
+		if m.Type == ReliableDataLinkTypeACK && m.IsLast {
+			return fmt.Errorf("%w: an ACK is never the last in the compact header", ErrReliableDataLinkHeader)
+		}
+		if m.Flags != 0 {
+			return fmt.Errorf("%w: flags %#x need a versioned header", ErrReliableDataLinkHeader, m.Flags)
+		}
+	} else if m.Flags&0x80 != 0 {
+		return fmt.Errorf("%w: flags %#x do not fit in 7 bits", ErrReliableDataLinkHeader, m.Flags)
+	}
+	if m.ACK != 0 && m.Flags&ReliableDataLinkFlagACK == 0 {
+		return fmt.Errorf("%w: ACK %d without its flag", ErrReliableDataLinkHeader, m.ACK)
+	}
+	if m.Window != 0 && m.Flags&ReliableDataLinkFlagWindow == 0 {
+		return fmt.Errorf("%w: window %d without its flag", ErrReliableDataLinkHeader, m.Window)
+	}
+	return nil
+}
+
+func (m ReliableDataLinkHeader) ToBytes() ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	destination := m.Destination
+	if destination == ReliableDataLinkBroadcast {
+		destination = m.Format.broadcast()
+	}
+	last := byte(0)
+	if m.IsLast {
+		last = 1
+	}
+
+	if m.Format == ReliableDataLinkHeaderCompact {
+		typ := m.Type
+		if typ == ReliableDataLinkTypeControl {
+			typ, last = ReliableDataLinkTypeACK, 1
+		}
+		return []byte{byte(m.Source)<<5 | byte(destination)<<2 | byte(typ)<<1 | last, byte(m.Index)}, nil
+	}
+
+	bytes := make([]byte, 0, m.NumBytes())
+	first := byte(reliableDataLinkVersion<<4) | byte(m.Type)
+	if m.Format == ReliableDataLinkHeaderV1Wide {
+		first |= 0x8
+		bytes = append(bytes, first, m.Flags<<1|last)
+		bytes = binary.BigEndian.AppendUint16(bytes, uint16(m.Source))
+		bytes = binary.BigEndian.AppendUint16(bytes, uint16(destination))
+	} else {
+		bytes = append(bytes, first, m.Flags<<1|last, byte(m.Source), byte(destination))
+	}
+	bytes = binary.BigEndian.AppendUint16(bytes, m.Index)
+	bytes = append(bytes, m.MessageID)
+	if m.Flags&ReliableDataLinkFlagACK != 0 {
+		bytes = binary.BigEndian.AppendUint16(bytes, m.ACK)
+	}
+	if m.Flags&ReliableDataLinkFlagWindow != 0 {
+		bytes = append(bytes, m.Window)
+	}
+	return bytes, nil
+}
+
+// reads the header in the Format, where either versioned format reads both address sizes
+func (m *ReliableDataLinkHeader) FromBytes(data []byte) error {
+	if m.Format == ReliableDataLinkHeaderCompact {
+		if len(data) < 2 {
+			return fmt.Errorf("%w: %d bytes are too short", ErrReliableDataLinkHeader, len(data))
+		}
+		*m = ReliableDataLinkHeader{
+			Source:      ReliableDataLinkAddress(data[0] >> 5),
+			Destination: ReliableDataLinkAddress((data[0] >> 2) & 0x7),
+			Type:        ReliableDataLinkType((data[0] >> 1) & 0x1),
+			IsLast:      (data[0] & 0x1) == 1,
+			Index:       uint16(data[1]),
+		}
+		if m.Type == ReliableDataLinkTypeACK && m.IsLast {
+			m.Type, m.IsLast = ReliableDataLinkTypeControl, false
+		}
+	} else {
+		if len(data) < 1 || data[0]>>4 != reliableDataLinkVersion {
+			return fmt.Errorf("%w: not a version %d header", ErrReliableDataLinkHeader, reliableDataLinkVersion)
+		}
+		format := ReliableDataLinkHeaderV1
+		if data[0]&0x8 != 0 {
+			format = ReliableDataLinkHeaderV1Wide
+		}
+		if len(data) < 2 {
+			return fmt.Errorf("%w: %d bytes are too short", ErrReliableDataLinkHeader, len(data))
+		}
+		*m = ReliableDataLinkHeader{
+			Format: format,
+			Type:   ReliableDataLinkType(data[0] & 0x7),
+			IsLast: data[1]&0x1 == 1,
+			Flags:  data[1] >> 1,
+		}
+		if len(data) < m.NumBytes() {
+			return fmt.Errorf("%w: %d bytes are too short", ErrReliableDataLinkHeader, len(data))
+		}
+		rest := data[2:]
+		if format == ReliableDataLinkHeaderV1Wide {
+			m.Source = ReliableDataLinkAddress(binary.BigEndian.Uint16(rest))
+			m.Destination = ReliableDataLinkAddress(binary.BigEndian.Uint16(rest[2:]))
+			rest = rest[4:]
+		} else {
+			m.Source, m.Destination = ReliableDataLinkAddress(rest[0]), ReliableDataLinkAddress(rest[1])
+			rest = rest[2:]
+		}
+		m.Index = binary.BigEndian.Uint16(rest)
+		m.MessageID = rest[2]
+		rest = rest[3:]
+		if m.Flags&ReliableDataLinkFlagACK != 0 {
+			m.ACK = binary.BigEndian.Uint16(rest)
+			rest = rest[2:]
+		}
+		if m.Flags&ReliableDataLinkFlagWindow != 0 {
+			m.Window = rest[0]
+		}
+	}
+	if m.Destination == m.Format.broadcast() {
+		m.Destination = ReliableDataLinkBroadcast
+	}
+	if m.Source == m.Format.broadcast() {
+		return fmt.Errorf("%w: broadcast source address", ErrReliableDataLinkHeader)
+	}
+	return m.Validate()
+}
+
+func (m ReliableDataLinkHeader) NumBytes() int {
+	n := m.Format.NumBytes()
+	if m.Format == ReliableDataLinkHeaderCompact {
+		return n
+	}
+	if m.Flags&ReliableDataLinkFlagACK != 0 {
+		n += 2
+	}
+	if m.Flags&ReliableDataLinkFlagWindow != 0 {
+		n++
+	}
+	return n
+}
+
+func (m ReliableDataLinkHeader) IsControl() bool {
+	return m.Type == ReliableDataLinkTypeControl
+}
+
+// the header a packet is sealed with by Secure. The index, IsLast, the ACK and the window change from frame to frame
+// and are left out, the order of the frames is covered by the tag of the whole packet. A broadcast is a single frame whose index is the group
+func (m ReliableDataLinkHeader) sealed() ([]byte, error) {
+	m.Flags &^= ReliableDataLinkFlagACK | ReliableDataLinkFlagWindow
+	m.ACK, m.Window = 0, 0
+	if m.Destination != ReliableDataLinkBroadcast {
+		m.Index, m.IsLast = 0, false
+	}
+	return m.ToBytes()
+}
+
+// the sequence number a packet is sealed with by Secure, the compact header sends no message ID
+func (m ReliableDataLinkHeader) sequence() uint8 {
+	if m.Format == ReliableDataLinkHeaderCompact {
+		return 0
+	}
+	return m.MessageID
+}
@@ -0,0 +1,97 @@
+package layers
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestReliableDataLinkHeader(t *testing.T) {
+
+	// the compact header is the one of the first version
+	compact := []struct {
+		header ReliableDataLinkHeader
+		bytes  []byte
+	}{
+		{ReliableDataLinkHeader{Source: 1, Destination: 2, Type: ReliableDataLinkTypeData, IsLast: true, Index: 5}, []byte{0x29, 5}},
+		{ReliableDataLinkHeader{Source: 6, Destination: 0, Type: ReliableDataLinkTypeACK, Index: 255}, []byte{0xc2, 255}},
+		{ReliableDataLinkHeader{Source: 3, Destination: 4, Type: ReliableDataLinkTypeControl}, []byte{0x73, 0}},
+		{ReliableDataLinkHeader{Source: 0, Destination: ReliableDataLinkBroadcast, Type: ReliableDataLinkTypeData, IsLast: true, Index: 9}, []byte{0x1d, 9}},
+	}
+	for _, c := range compact {
+		data, err := c.header.ToBytes()
+		if err != nil || !bytes.Equal(data, c.bytes) {
+			t.Errorf("%+v is %x %v, expected %x", c.header, data, err, c.bytes)
+		}
+		header := ReliableDataLinkHeader{}
+		if err := header.FromBytes(c.bytes); err != nil || header != c.header {
+			t.Errorf("%x is read as %+v %v, expected %+v", c.bytes, header, err, c.header)
+		}
+	}
+
+	for _, header := range []ReliableDataLinkHeader{
+		{Format: ReliableDataLinkHeaderV1, Source: 0xfe, Destination: 3, Type: ReliableDataLinkTypeNACK, Flags: 0x7f, Index: 0xabcd, MessageID: 7},
+		{Format: ReliableDataLinkHeaderV1, Source: 1, Destination: ReliableDataLinkBroadcast, Type: ReliableDataLinkTypeData, IsLast: true, Index: 5},
+		{Format: ReliableDataLinkHeaderV1Wide, Source: 0x1234, Destination: 0xfffe, Type: ReliableDataLinkTypeKeepalive, MessageID: 0xff},
+		{Format: ReliableDataLinkHeaderV1Wide, Source: 0x100, Destination: ReliableDataLinkBroadcast, Type: ReliableDataLinkTypeControl, IsLast: true},
+		{Format: ReliableDataLinkHeaderV1, Source: 2, Destination: 1, Type: ReliableDataLinkTypeData, Flags: ReliableDataLinkFlagACK, Index: 3, ACK: 0x1234},
+		{Format: ReliableDataLinkHeaderV1, Source: 2, Destination: 1, Type: ReliableDataLinkTypeACK, Flags: ReliableDataLinkFlagWindow, Index: 3, Window: 4},
+		{Format: ReliableDataLinkHeaderV1Wide, Source: 2, Destination: 1, Type: ReliableDataLinkTypeData, Flags: ReliableDataLinkFlagACK | ReliableDataLinkFlagWindow, ACK: 9, Window: 0xff},
+	} {
+		data, err := header.ToBytes()
+		if err != nil || len(data) != header.NumBytes() {
+			t.Fatalf("%+v is %x %v", header, data, err)
+		}
+		// a versioned header reads either address size
+		read := ReliableDataLinkHeader{Format: ReliableDataLinkHeaderV1}
+		if err := read.FromBytes(data); err != nil || read != header {
+			t.Errorf("%x is read as %+v %v, expected %+v", data, read, err, header)
+		}
+	}
+
+	for _, header := range []ReliableDataLinkHeader{
+		{Source: 7},
+		{Destination: 8},
+		{Type: ReliableDataLinkTypeNACK},
+		{Type: ReliableDataLinkTypeACK, IsLast: true},
+		{Flags: 1},
+		{Format: ReliableDataLinkHeaderV1, Source: 0x100},
+		{Format: ReliableDataLinkHeaderV1, Flags: 0x80},
+		{Format: ReliableDataLinkHeaderV1Wide, Type: 7},
+		{Format: ReliableDataLinkHeaderV1, ACK: 1},
+		{Format: ReliableDataLinkHeaderV1, Flags: ReliableDataLinkFlagACK, Window: 1},
+	} {
+		if _, err := header.ToBytes(); !errors.Is(err, ErrReliableDataLinkHeader) {
+			t.Errorf("%+v is not rejected: %v", header, err)
+		}
+	}
+
+	for _, c := range []struct {
+		format ReliableDataLinkHeaderFormat
+		data   []byte
+	}{
+		{ReliableDataLinkHeaderCompact, []byte{0x29}},
+		{ReliableDataLinkHeaderCompact, []byte{0xe0, 0}}, // from the broadcast address
+		{ReliableDataLinkHeaderV1, []byte{0x20, 0, 1, 2, 0, 0, 0}},
+		{ReliableDataLinkHeaderV1, []byte{0x18, 0, 1, 2, 0, 0, 0}},
+		{ReliableDataLinkHeaderV1, []byte{0x10, 0x2, 1, 2, 0, 0, 0, 0}},    // the ACK is cut
+		{ReliableDataLinkHeaderV1, []byte{0x10, 0x6, 1, 2, 0, 0, 0, 0, 0}}, // the window is cut
+	} {
+		header := ReliableDataLinkHeader{Format: c.format}
+		if err := header.FromBytes(c.data); !errors.Is(err, ErrReliableDataLinkHeader) {
+			t.Errorf("%x is not rejected as %v: %v", c.data, c.format, err)
+		}
+	}
+
+	// the noise is rejected with errors, not panics
+	random := rand.New(rand.NewSource(1))
+	for range 1000 {
+		data := make([]byte, random.Intn(12))
+		random.Read(data)
+		for _, format := range []ReliableDataLinkHeaderFormat{ReliableDataLinkHeaderCompact, ReliableDataLinkHeaderV1} {
+			header := ReliableDataLinkHeader{Format: format}
+			header.FromBytes(data)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"Aethernet/pkg/fixed"
 	"Aethernet/pkg/modem"
 	"crypto/rand"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -156,3 +157,333 @@ func TestReliableDataLinkLayer(t *testing.T) {
 	layers[0].Close()
 	layers[1].Close()
 }
+
+// the broadcasts reach the nodes of their group without ACK, and the ones too long for a frame are refused
+func TestReliableDataLinkBroadcast(t *testing.T) {
+	const GROUP = 5
+
+	network := device.Network[string]{
+		Config:     make(device.NetworkConfig[string], 3),
+		SampleRate: 48000 / device.BufferSize,
+	}
+	for i := range network.Config {
+		network.Config[i].In, network.Config[i].Out = "air", "air"
+	}
+	devices := network.Build()
+
+	nodes := make([]*ReliableDataLinkLayer, len(devices))
+	for i := range nodes {
+		nodes[i] = newCSMANode(devices[i], ReliableDataLinkAddress(i), 10)
+		nodes[i].Open()
+		defer nodes[i].Close()
+	}
+	nodes[1].Join(GROUP)
+
+	if err := nodes[0].Multicast(GROUP, []byte("multicast")); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[0].Send(ReliableDataLinkBroadcast, []byte("broadcast")); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[0].Send(ReliableDataLinkBroadcast, make([]byte, nodes[0].BytePerFrame+1)); err == nil {
+		t.Errorf("A broadcast longer than a frame is sent")
+	}
+
+	for i, expected := range [][]string{nil, {"multicast", "broadcast"}, {"broadcast"}} {
+		var received []string
+		for range expected {
+			select {
+			case packet := <-nodes[i].ReceiveAsync():
+				received = append(received, string(packet))
+			case <-time.After(2 * time.Second):
+			}
+		}
+		for len(nodes[i].ReceiveAsync()) > 0 {
+			received = append(received, string(<-nodes[i].ReceiveAsync()))
+		}
+		if !reflect.DeepEqual(received, expected) {
+			t.Errorf("Node %d received %q, expected %q", i, received, expected)
+		}
+	}
+	if len(nodes[0].receivedACK) != 0 {
+		t.Errorf("The broadcast is acknowledged")
+	}
+}
+
+// the versioned header tells the packets apart, so a frame of another packet is not appended to the current one
+func TestReliableDataLinkReassembly(t *testing.T) {
+	m := ReliableDataLinkLayer{Address: 1, Header: ReliableDataLinkHeaderV1, outputChan: make(chan []byte, 4)}
+	frame := func(source ReliableDataLinkAddress, message uint8, index uint16, last bool, data string) {
+		m.handle(ReliableDataLinkHeader{
+			Format:      m.Header,
+			Source:      source,
+			Destination: m.Address,
+			IsLast:      last,
+			Index:       index,
+			MessageID:   message,
+		}, []byte(data))
+	}
+
+	frame(2, 10, 0, false, "a")
+	frame(3, 20, 1, false, "x") // interleaved, out of order
+	frame(3, 20, 0, false, "x") // interleaved, kept apart from the packet of source 2
+	frame(2, 10, 1, true, "b")
+	frame(2, 10, 1, true, "b") // duplicate of the last frame
+	frame(2, 11, 0, false, "c")
+	frame(2, 12, 0, true, "d") // the packet 11 is given up, the next one restarts the reception of its source
+	frame(3, 20, 1, true, "y")
+
+	var received []string
+	for len(m.outputChan) > 0 {
+		received = append(received, string(<-m.outputChan))
+	}
+	if !reflect.DeepEqual(received, []string{"ab", "d", "xy"}) {
+		t.Errorf("Received %q, expected the packets of each source", received)
+	}
+}
+
+func TestReliableDataLinkACKMatching(t *testing.T) {
+	header := ReliableDataLinkHeader{Format: ReliableDataLinkHeaderV1, Source: 1, Destination: 2, Index: 3, MessageID: 10}
+	for _, c := range []struct {
+		ack      receivedACK
+		expected bool
+	}{
+		{receivedACK{source: 2, index: 3, message: 10, exact: true}, true},
+		{receivedACK{source: 2, index: 3}, true}, // piggybacked
+		{receivedACK{source: 4, index: 3, message: 10, exact: true}, false},
+		{receivedACK{source: 4, index: 3}, false},
+		{receivedACK{source: 2, index: 3, message: 9, exact: true}, false},
+		{receivedACK{source: 2, index: 2, message: 10, exact: true}, false},
+	} {
+		if c.ack.acknowledges(header) != c.expected {
+			t.Errorf("ACK %+v acknowledges the frame %v, expected %v", c.ack, !c.expected, c.expected)
+		}
+	}
+}
+
+func TestReliableDataLinkMaxMessageSize(t *testing.T) {
+	m := ReliableDataLinkLayer{Address: 1, Compression: CompressionDeflate, MaxMessageSize: 4 * MaxPacketSize, outputChan: make(chan []byte, 4)}
+	if err := m.Send(2, make([]byte, m.MaxMessageSize+1)); err == nil {
+		t.Errorf("A message over the largest of %d bytes should be refused", m.MaxMessageSize)
+	}
+
+	// a message larger than an IP packet fits the limit of the layer
+	header := ReliableDataLinkHeader{Source: 2, Destination: m.Address, IsLast: true}
+	m.deliver(header, m.Compression.Encode(make([]byte, m.MaxMessageSize)))
+	m.deliver(header, m.Compression.Encode(make([]byte, m.MaxMessageSize+1)))
+	if len(m.outputChan) != 1 || len(<-m.outputChan) != m.MaxMessageSize {
+		t.Errorf("Expected only the message of %d bytes to be received", m.MaxMessageSize)
+	}
+}
+
+func TestReliableDataLinkWideHeader(t *testing.T) {
+	network := device.Network[string]{
+		Config:     device.NetworkConfig[string]{{In: "air", Out: "air"}, {In: "air", Out: "air"}},
+		SampleRate: 48000 / device.BufferSize,
+	}
+	devices := network.Build()
+
+	addresses := []ReliableDataLinkAddress{0x1234, 0x100}
+	nodes := make([]*ReliableDataLinkLayer, len(devices))
+	for i := range nodes {
+		nodes[i] = newCSMANode(devices[i], addresses[i], 10)
+		nodes[i].Header = ReliableDataLinkHeaderV1Wide
+		nodes[i].Open()
+		defer nodes[i].Close()
+	}
+
+	payload := make([]byte, 300)
+	rand.Read(payload)
+	if err := nodes[0].Send(addresses[1], payload); err != nil {
+		t.Fatal(err)
+	}
+	received, err := nodes[1].ReceiveWithTimeout(time.Second)
+	if err != nil || !reflect.DeepEqual(received, payload) {
+		t.Errorf("Received %d bytes %v, expected the %d sent", len(received), err, len(payload))
+	}
+	if nodes[0].BytePerFrame != 125-9 {
+		t.Errorf("Payload length %d, expected the frame without the wide header", nodes[0].BytePerFrame)
+	}
+}
+
+// two nodes send to each other at once, and the ACKs ride on the data frames instead of their own
+func TestReliableDataLinkPiggyback(t *testing.T) {
+	network := device.Network[string]{
+		Config:     device.NetworkConfig[string]{{In: "air", Out: "air"}, {In: "air", Out: "air"}},
+		SampleRate: 48000 / device.BufferSize,
+	}
+	devices := network.Build()
+
+	nodes := make([]*ReliableDataLinkLayer, len(devices))
+	payloads := make([][]byte, len(devices))
+	for i := range nodes {
+		nodes[i] = newCSMANode(devices[i], ReliableDataLinkAddress(i), 10)
+		nodes[i].Header = ReliableDataLinkHeaderV1
+		nodes[i].ACKDelay = 150 * time.Millisecond
+		nodes[i].Open()
+		defer nodes[i].Close()
+		payloads[i] = make([]byte, 1000)
+		rand.Read(payloads[i])
+	}
+
+	errs := make([]<-chan error, len(nodes))
+	for i, node := range nodes {
+		errs[i] = node.SendAsync(nodes[1-i].Address, payloads[i])
+	}
+	for i, node := range nodes {
+		if err := <-errs[i]; err != nil {
+			t.Fatalf("Node %d: %v", i, err)
+		}
+		received, err := node.ReceiveWithTimeout(time.Second)
+		if err != nil || !reflect.DeepEqual(received, payloads[1-i]) {
+			t.Errorf("Node %d received %d bytes %v, expected the %d sent", i, len(received), err, len(payloads[1-i]))
+		}
+	}
+
+	piggybacked := 0
+	for i, node := range nodes {
+		stats := node.ACKStats()
+		t.Logf("Node %d: %+v", i, stats)
+		piggybacked += stats.Piggybacked
+	}
+	if piggybacked == 0 {
+		t.Errorf("No ACK is piggybacked")
+	}
+}
+
+// the receiver reads slowly from an output of one packet, so the sender is paused by the window instead of crashing the receiver
+func TestReliableDataLinkFlowControl(t *testing.T) {
+	if testing.Short() {
+		t.Skip("The nodes run in real time")
+	}
+
+	const (
+		PACKETS = 4
+		READ    = 300 * time.Millisecond
+	)
+
+	network := device.Network[string]{
+		Config:     device.NetworkConfig[string]{{In: "air", Out: "air"}, {In: "air", Out: "air"}},
+		SampleRate: 48000 / device.BufferSize,
+	}
+	devices := network.Build()
+
+	nodes := make([]*ReliableDataLinkLayer, len(devices))
+	for i := range nodes {
+		nodes[i] = newCSMANode(devices[i], ReliableDataLinkAddress(i), 1)
+		nodes[i].Header = ReliableDataLinkHeaderV1
+		nodes[i].WindowProbe = 100 * time.Millisecond
+		nodes[i].Open()
+		defer nodes[i].Close()
+	}
+
+	payloads := make([][]byte, PACKETS)
+	sent := make(chan error, 1)
+	go func() {
+		for i := range payloads {
+			payloads[i] = []byte{byte(i)}
+			if err := nodes[0].Send(1, payloads[i]); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+
+	start := time.Now()
+	for i := range payloads {
+		time.Sleep(READ)
+		received, err := nodes[1].ReceiveWithTimeout(time.Second)
+		if err != nil || len(received) != 1 || received[0] != byte(i) {
+			t.Fatalf("Packet %d is received as %v %v", i, received, err)
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+
+	stats := nodes[0].FlowStats()
+	t.Logf("Sender %+v, receiver %+v in %v", stats, nodes[1].FlowStats(), time.Since(start))
+	if stats.Pauses == 0 || stats.Paused < READ {
+		t.Errorf("The sender is not paced by the receiver: %+v", stats)
+	}
+	// the ACK of the last packet is sent once the packet fills the output
+	if window, ok := nodes[0].PeerWindow(1); !ok || window != 0 {
+		t.Errorf("The last window of the receiver is %d %v, expected 0", window, ok)
+	}
+}
+
+// a compact packet the full output has no room for is not acknowledged, and is taken when it is sent again
+func TestReliableDataLinkCompactRefused(t *testing.T) {
+	m := ReliableDataLinkLayer{Address: 1, Header: ReliableDataLinkHeaderCompact, outputChan: make(chan []byte, 1)}
+	frame := func(index uint16, last bool, data string) {
+		m.handle(ReliableDataLinkHeader{Format: m.Header, Source: 2, Destination: m.Address, IsLast: last, Index: index}, []byte(data))
+	}
+
+	m.outputChan <- []byte("full")
+	frame(0, false, "a")
+	done := make(chan struct{})
+	go func() {
+		frame(1, true, "b")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The receiving loop waits for the application")
+	}
+	if stats := m.FlowStats(); stats.Refused != 1 {
+		t.Errorf("Expected the packet to be refused, got %+v", stats)
+	}
+
+	<-m.outputChan
+	frame(1, true, "b")
+	if received := <-m.outputChan; string(received) != "ab" {
+		t.Errorf("Received %q, expected the packet sent again", received)
+	}
+}
+
+// an unbuffered output takes the packets while the application waits for them, whatever the header
+func TestReliableDataLinkUnbuffered(t *testing.T) {
+	if testing.Short() {
+		t.Skip("The nodes run in real time")
+	}
+
+	for _, format := range []ReliableDataLinkHeaderFormat{ReliableDataLinkHeaderCompact, ReliableDataLinkHeaderV1} {
+		t.Run(format.String(), func(t *testing.T) {
+			network := device.Network[string]{
+				Config:     device.NetworkConfig[string]{{In: "air", Out: "air"}, {In: "air", Out: "air"}},
+				SampleRate: 48000 / device.BufferSize,
+			}
+			devices := network.Build()
+
+			nodes := make([]*ReliableDataLinkLayer, len(devices))
+			for i := range nodes {
+				nodes[i] = newCSMANode(devices[i], ReliableDataLinkAddress(i), 0)
+				nodes[i].Header = format
+				nodes[i].Open()
+				defer nodes[i].Close()
+			}
+
+			sent := make(chan error, 1)
+			go func() {
+				for i := range 3 {
+					if err := nodes[0].Send(1, []byte{byte(i)}); err != nil {
+						sent <- err
+						return
+					}
+				}
+				sent <- nil
+			}()
+			for i := range 3 {
+				received, err := nodes[1].ReceiveWithTimeout(3 * time.Second)
+				if err != nil || len(received) != 1 || received[0] != byte(i) {
+					t.Fatalf("Packet %d is received as %v %v", i, received, err)
+				}
+			}
+			if err := <-sent; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package layers
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// the key shared by all the nodes of a segment, used by the layers without destination addresses
+	SecureGroupKey = 0xff
+
+	// number of out-of-order messages accepted behind the latest one from each source
+	ReplayWindow = 64
+
+	secureCounterSize = 8
+	SecureOverhead    = secureCounterSize + chacha20poly1305.Overhead
+)
+
+var (
+	ErrNoKey  = errors.New("no key for peer")
+	ErrReplay = errors.New("replayed message")
+)
+
+// Secure provides authenticated encryption with ChaCha20-Poly1305 for the messages of the data link layers.
+//
+// Counter (64 bit) | Ciphertext | Tag (128 bit)
+//
+// The nonce is made of the source address, the destination address and the counter. The counter is
+// the number of the message (56 bit), seeded from the current time so that it is not reused after a restart,
+// followed by the sequence number the link layer sends the message with (8 bit), which Open checks against
+// the one of the received header. The serialized header of the message is authenticated as associated data.
+type Secure struct {
+	Keys map[byte][]byte // pre-shared keys indexed by the address of the peer
+
+	mu     sync.Mutex
+	aeads  map[byte]cipher.AEAD
+	number uint64 // the number of the last message sealed
+	replay map[byte]*replayWindow
+}
+
+// the numbers of the messages received from a source, the sequence numbers of the link are left out
+type replayWindow struct {
+	latest uint64
+	seen   uint64 // bit i is set if latest-1-i has been received
+}
+
+func NewSecure(keys map[byte][]byte) (*Secure, error) {
+	for peer, key := range keys {
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("key for peer %d has %d bytes, expected %d", peer, len(key), chacha20poly1305.KeySize)
+		}
+	}
+	return &Secure{Keys: keys}, nil
+}
+
+func (s *Secure) aead(peer byte) (cipher.AEAD, error) {
+	if s.aeads == nil {
+		s.aeads = make(map[byte]cipher.AEAD)
+		s.replay = make(map[byte]*replayWindow)
+	}
+	if aead, ok := s.aeads[peer]; ok {
+		return aead, nil
+	}
+	key, ok := s.Keys[peer]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrNoKey, peer)
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	s.aeads[peer] = aead
+	return aead, nil
+}
+
+func secureNonce(src, dst byte, counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	nonce[0], nonce[1] = src, dst
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// encrypts a message from src to dst with the key of peer, sent with the sequence number and the header
+func (s *Secure) Seal(peer, src, dst byte, sequence uint8, header, plaintext []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	aead, err := s.aead(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.number == 0 {
+		s.number = uint64(time.Now().UnixMicro())
+	}
+	s.number++
+	counter := s.number<<8 | uint64(sequence)
+
+	out := make([]byte, secureCounterSize, SecureOverhead+len(plaintext))
+	binary.BigEndian.PutUint64(out, counter)
+	return aead.Seal(out, secureNonce(src, dst, counter), plaintext, header), nil
+}
+
+// authenticates and decrypts a message from src to dst with the key of peer, received with the sequence number and the header
+func (s *Secure) Open(peer, src, dst byte, sequence uint8, header, data []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plaintext, err := s.open(peer, src, dst, sequence, header, data)
+	if err != nil {
+		return nil, err
+	}
+	// only authentic messages move the window
+	s.replay[src].update(binary.BigEndian.Uint64(data) >> 8)
+	return plaintext, nil
+}
+
+// opens a message like Open without recording it against the replays, so a message which is not taken can be opened again
+// when its source sends it again. Accept records it once it is taken
+func (s *Secure) Peek(peer, src, dst byte, sequence uint8, header, data []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.open(peer, src, dst, sequence, header, data)
+}
+
+// records a message from src opened by Peek, unless it has been recorded since
+func (s *Secure) Accept(src byte, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	number := binary.BigEndian.Uint64(data) >> 8
+	if window, ok := s.replay[src]; ok && window.check(number) {
+		window.update(number)
+	}
+}
+
+func (s *Secure) open(peer, src, dst byte, sequence uint8, header, data []byte) ([]byte, error) {
+	if len(data) < SecureOverhead {
+		return nil, fmt.Errorf("secure message is too short")
+	}
+
+	aead, err := s.aead(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := binary.BigEndian.Uint64(data)
+	if uint8(counter) != sequence {
+		return nil, fmt.Errorf("secure message sealed with sequence %d is received with %d", uint8(counter), sequence)
+	}
+	number := counter >> 8
+	window, ok := s.replay[src]
+	if !ok {
+		window = &replayWindow{}
+		s.replay[src] = window
+	}
+	if !window.check(number) {
+		return nil, fmt.Errorf("%w %d from %d", ErrReplay, number, src)
+	}
+
+	return aead.Open(nil, secureNonce(src, dst, counter), data[secureCounterSize:], header)
+}
+
+func (w *replayWindow) check(number uint64) bool {
+	if number > w.latest {
+		return true
+	}
+	behind := w.latest - number
+	return behind != 0 && behind <= ReplayWindow && w.seen&(1<<(behind-1)) == 0
+}
+
+func (w *replayWindow) update(number uint64) {
+	if number <= w.latest {
+		w.seen |= 1 << (w.latest - number - 1)
+		return
+	}
+	// the old latest number becomes 1 behind
+	shift := number - w.latest
+	if shift > ReplayWindow {
+		w.seen = 0
+	} else {
+		w.seen = w.seen<<shift | 1<<(shift-1)
+	}
+	w.latest = number
+}
+
+// decodes the hex encoded keys of the configuration files
+func ParseSecureKeys(keys map[int]string) (map[byte][]byte, error) {
+	parsed := make(map[byte][]byte, len(keys))
+	for peer, key := range keys {
+		if peer < 0 || peer > 0xff {
+			return nil, fmt.Errorf("invalid peer address %d", peer)
+		}
+		decoded, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key for peer %d: %v", peer, err)
+		}
+		parsed[byte(peer)] = decoded
+	}
+	return parsed, nil
+}
@@ -0,0 +1,247 @@
+package layers
+
+import (
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/fixed"
+	"Aethernet/pkg/modem"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+func TestSecure(t *testing.T) {
+
+	key := randomKey()
+	alice, err := NewSecure(map[byte][]byte{2: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := NewSecure(map[byte][]byte{1: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := []byte("Hello, Aethernet!")
+	header := []byte{1, 2, 0x10}
+	sealed, err := alice.Seal(2, 1, 2, 7, header, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != len(message)+SecureOverhead {
+		t.Errorf("Sealed %d bytes to %d bytes, expected %d", len(message), len(sealed), len(message)+SecureOverhead)
+	}
+	if bytes.Contains(sealed, message) {
+		t.Errorf("Message is sent in clear")
+	}
+
+	// tampered ciphertext and addresses
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := bob.Open(1, 1, 2, 7, header, tampered); err == nil {
+		t.Errorf("Tampered message should be rejected")
+	}
+	if _, err := bob.Open(1, 3, 2, 7, header, sealed); err == nil {
+		t.Errorf("Message with a forged source should be rejected")
+	}
+	if _, err := bob.Open(1, 1, 2, 7, []byte{1, 2, 0x11}, sealed); err == nil {
+		t.Errorf("Message with a forged header should be rejected")
+	}
+	if _, err := bob.Open(1, 1, 2, 8, header, sealed); err == nil {
+		t.Errorf("Message received with another sequence number should be rejected")
+	}
+
+	opened, err := bob.Open(1, 1, 2, 7, header, sealed)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	if !bytes.Equal(opened, message) {
+		t.Errorf("Opened %q, expected %q", opened, message)
+	}
+
+	// replayed message
+	if _, err := bob.Open(1, 1, 2, 7, header, sealed); !errors.Is(err, ErrReplay) {
+		t.Errorf("Replayed message should be rejected, got %v", err)
+	}
+
+	// out of order messages are accepted once within the window
+	var window [ReplayWindow + 2][]byte
+	for i := range window {
+		window[i], _ = alice.Seal(2, 1, 2, 7, header, message)
+	}
+	if _, err := bob.Open(1, 1, 2, 7, header, window[len(window)-1]); err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	if _, err := bob.Open(1, 1, 2, 7, header, window[1]); err != nil {
+		t.Errorf("Message within the window should be accepted, got %v", err)
+	}
+	if _, err := bob.Open(1, 1, 2, 7, header, window[1]); !errors.Is(err, ErrReplay) {
+		t.Errorf("Replayed message within the window should be rejected, got %v", err)
+	}
+	if _, err := bob.Open(1, 1, 2, 7, header, window[0]); !errors.Is(err, ErrReplay) {
+		t.Errorf("Message behind the window should be rejected, got %v", err)
+	}
+
+	if _, err := alice.Seal(3, 1, 3, 7, header, message); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Sealing without a key should fail, got %v", err)
+	}
+	if _, err := NewSecure(map[byte][]byte{1: key[:16]}); err == nil {
+		t.Errorf("Short key should be rejected")
+	}
+}
+
+func TestSecureNaiveDataLinkLayer(t *testing.T) {
+
+	const (
+		SAMPLE_RATE = 48000
+
+		BYTE_PER_FRAME = 125
+		FRAME_INTERVAL = 256
+		CARRIER_SIZE   = 3
+
+		INPUT_BUFFER_SIZE  = 10000
+		OUTPUT_BUFFER_SIZE = 10
+
+		POWER_THRESHOLD = 30
+
+		POWER_MONITOR_THRESHOLD = 0.4
+		POWER_MONITOR_WINDOW    = 10
+	)
+
+	var preamble = modem.DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+
+	network := device.Network[string]{
+		Config: device.NetworkConfig[string]{
+			{In: "w", Out: "w"},
+			{In: "w", Out: "w"},
+			{In: "w", Out: "w"},
+		},
+		SampleRate: SAMPLE_RATE,
+	}
+	devices := network.Build()
+
+	key := randomKey()
+	var layers [3]NaiveDataLinkLayer
+	for i := range layers {
+		layers[i] = NaiveDataLinkLayer{
+			PhysicalLayer: PhysicalLayer{
+				Device: devices[i],
+				Decoder: Decoder{
+					Demodulator: modem.Demodulator{
+						Preamble:                 preamble,
+						CarrierSize:              CARRIER_SIZE,
+						DemodulatePowerThreshold: fixed.FromFloat(POWER_THRESHOLD),
+						BufferSize:               OUTPUT_BUFFER_SIZE,
+					},
+					BufferSize: INPUT_BUFFER_SIZE,
+				},
+				Encoder: Encoder{
+					Modulator: modem.Modulator{
+						Preamble:      preamble,
+						CarrierSize:   CARRIER_SIZE,
+						BytePerFrame:  BYTE_PER_FRAME,
+						FrameInterval: FRAME_INTERVAL,
+					},
+					BufferSize: OUTPUT_BUFFER_SIZE,
+				},
+				PowerMonitor: PowerMonitor{
+					Threshold:  fixed.FromFloat(POWER_MONITOR_THRESHOLD),
+					WindowSize: POWER_MONITOR_WINDOW,
+				},
+			},
+			Address:    byte(i + 1),
+			BufferSize: OUTPUT_BUFFER_SIZE,
+		}
+		if i < 2 {
+			// the third node does not know the group key
+			layers[i].Secure, _ = NewSecure(map[byte][]byte{SecureGroupKey: key})
+		}
+		layers[i].Open()
+		defer layers[i].Close()
+	}
+
+	message := []byte("secret")
+	layers[0].Send(message)
+	select {
+	case received := <-layers[1].ReceiveAsync():
+		if !bytes.Equal(received, message) {
+			t.Errorf("Received %q, expected %q", received, message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the message")
+	}
+	select {
+	case received := <-layers[2].ReceiveAsync():
+		if bytes.Contains(received, message) {
+			t.Errorf("Node without the key can read the message")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the ciphertext")
+	}
+
+	// the frame injected by the node without the key is dropped
+	layers[2].Send([]byte("forged"))
+	select {
+	case received := <-layers[1].ReceiveAsync():
+		t.Errorf("Forged message %q accepted", received)
+	case <-time.After(time.Second):
+	}
+}
+
+func TestSecureReliableDataLinkHeader(t *testing.T) {
+	key := randomKey()
+	alice, _ := NewSecure(map[byte][]byte{2: key})
+	bob, _ := NewSecure(map[byte][]byte{1: key})
+	sender := ReliableDataLinkLayer{Address: 1, Header: ReliableDataLinkHeaderV1, BytePerFrame: 100, Secure: alice}
+	receiver := ReliableDataLinkLayer{Address: 2, Header: ReliableDataLinkHeaderV1, Secure: bob, outputChan: make(chan []byte, 4)}
+
+	header := sender.header(2, ReliableDataLinkTypeData)
+	header.MessageID = 5
+	packet, err := sender.encode(header, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last frame of the packet, with an ACK on the way
+	last := header
+	last.Index, last.IsLast = 3, true
+	last.Flags |= ReliableDataLinkFlagACK
+	forged := last
+	forged.MessageID = 6
+	receiver.deliver(forged, packet)
+	if len(receiver.outputChan) != 0 {
+		t.Errorf("Packet with a forged message ID is accepted")
+	}
+	receiver.deliver(last, packet)
+	if len(receiver.outputChan) != 1 || string(<-receiver.outputChan) != "secret" {
+		t.Errorf("Packet is not received")
+	}
+
+	// a packet refused by the full output is opened again when it is sent again, and only once taken
+	header.MessageID = 6
+	packet, _ = sender.encode(header, []byte("again"))
+	last.MessageID = 6
+	receiver.outputChan = make(chan []byte)
+	if receiver.deliver(last, packet) {
+		t.Errorf("Packet is taken without an application waiting")
+	}
+	received := make(chan []byte)
+	go func() { received <- <-receiver.outputChan }()
+	for !receiver.deliver(last, packet) {
+		time.Sleep(time.Millisecond)
+	}
+	if data := <-received; string(data) != "again" {
+		t.Errorf("Received %q, expected the packet sent again", data)
+	}
+	receiver.outputChan = make(chan []byte, 1)
+	if receiver.deliver(last, packet); len(receiver.outputChan) != 0 {
+		t.Errorf("Replayed packet is received")
+	}
+}
@@ -0,0 +1,190 @@
+package layers
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TokenPassing is the deterministic access of the ReliableDataLinkLayer, only the holder of the token sends its frames.
+// The holder passes the token to the next address of the ring when it has nothing more to send or has held it for Hold.
+// A successor which is not heard after the token is passed twice is skipped, and a token lost with its holder is regenerated
+// by the first node of the ring hearing nothing for LostTimeout, as each node waits PassTimeout longer than its predecessor.
+type TokenPassing struct {
+	Ring        []ReliableDataLinkAddress // the addresses in the order the token is passed
+	Hold        time.Duration             // the longest time the token is held
+	PassTimeout time.Duration             // the time for the successor to be heard after the token is passed
+	LostTimeout time.Duration             // the silence after which the token is lost
+
+	pending  atomic.Int32  // the Sends of this node
+	holding  atomic.Bool   // whether this node holds or passes the token
+	grant    chan struct{} // a permit for one attempt of a frame while holding the token
+	release  chan struct{}
+	left     chan struct{} // a Send is done
+	received chan struct{} // the token is passed to this node
+	heard    chan ReliableDataLinkAddress
+	closed   chan struct{}
+
+	mutex sync.Mutex
+	stats TokenStats
+}
+
+type TokenStats struct {
+	Passes        int // the tokens passed to a successor which has been heard
+	Skips         int // the successors skipped as they were not heard
+	Regenerations int // the tokens regenerated after the silence
+}
+
+func (t *TokenPassing) Stats() TokenStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.stats
+}
+
+func (t *TokenPassing) init() {
+	t.grant = make(chan struct{})
+	t.release = make(chan struct{})
+	t.left = make(chan struct{}, 1)
+	t.received = make(chan struct{}, 1)
+	t.heard = make(chan ReliableDataLinkAddress, 16)
+	t.closed = make(chan struct{})
+}
+
+// a Send waits for the token
+func (t *TokenPassing) enter() {
+	t.pending.Add(1)
+}
+
+func (t *TokenPassing) leave() {
+	t.pending.Add(-1)
+	select {
+	case t.left <- struct{}{}:
+	default:
+	}
+}
+
+// blocks until the node holds the token, then the attempt of a frame must be followed by finish
+func (t *TokenPassing) acquire() {
+	<-t.grant
+}
+
+func (t *TokenPassing) finish() {
+	t.release <- struct{}{}
+}
+
+// notes a frame of another node, which shows that the token is alive
+func (t *TokenPassing) hear(source ReliableDataLinkAddress) {
+	select {
+	case t.heard <- source:
+	default:
+		// the loop has enough evidence of the others
+	}
+}
+
+// the token is passed to this node, a duplicate is dropped
+func (t *TokenPassing) receive() bool {
+	if t.holding.Load() {
+		return false
+	}
+	select {
+	case t.received <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (m *ReliableDataLinkLayer) tokenLoop() {
+	t := m.Token
+	position := slices.Index(t.Ring, m.Address)
+	lostTimeout := t.LostTimeout + time.Duration(position)*t.PassTimeout
+
+	lost := time.NewTimer(lostTimeout)
+	defer lost.Stop()
+	for {
+		select {
+		case <-t.closed:
+			return
+		case <-t.heard:
+			lost.Reset(lostTimeout)
+			continue
+		case <-t.received:
+			fmt.Printf("[MAC%x] Token received\n", m.Address)
+		case <-lost.C:
+			fmt.Printf("[MAC%x] Token lost, regenerating\n", m.Address)
+			t.mutex.Lock()
+			t.stats.Regenerations++
+			t.mutex.Unlock()
+		}
+
+		t.holding.Store(true)
+		m.holdToken()
+		m.passToken(position)
+		t.holding.Store(false)
+		lost.Reset(lostTimeout)
+	}
+}
+
+// lets the Sends of this node go one attempt after another until they are done or the token is held for Hold
+func (m *ReliableDataLinkLayer) holdToken() {
+	t := m.Token
+	deadline := time.After(t.Hold)
+	for t.pending.Load() > 0 {
+		select {
+		case t.grant <- struct{}{}:
+			<-t.release
+		case <-t.left:
+		case <-deadline:
+			return
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+func (m *ReliableDataLinkLayer) passToken(position int) {
+	t := m.Token
+	for i := 1; i < len(t.Ring); i++ {
+		next := t.Ring[(position+i)%len(t.Ring)]
+		for range 2 {
+			if m.passTokenTo(next) {
+				t.mutex.Lock()
+				t.stats.Passes++
+				t.mutex.Unlock()
+				return
+			}
+		}
+		fmt.Printf("[MAC%x] Successor %x is not heard, skipping\n", m.Address, next)
+		t.mutex.Lock()
+		t.stats.Skips++
+		t.mutex.Unlock()
+	}
+	// alone in the ring
+	t.holding.Store(false)
+	t.receive()
+}
+
+// returns whether the successor is heard after the token is passed
+func (m *ReliableDataLinkLayer) passTokenTo(next ReliableDataLinkAddress) bool {
+	t := m.Token
+	for len(t.heard) > 0 {
+		<-t.heard
+	}
+	<-m.sendControl(next, ReliableDataLinkControlFrame{Control: ReliableDataLinkControlToken})
+	fmt.Printf("[MAC%x] Token passed to %x\n", m.Address, next)
+
+	timeout := time.After(t.PassTimeout)
+	for {
+		select {
+		case source := <-t.heard:
+			if source == next {
+				return true
+			}
+		case <-timeout:
+			return false
+		case <-t.closed:
+			return false
+		}
+	}
+}
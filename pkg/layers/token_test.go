@@ -0,0 +1,98 @@
+package layers
+
+import (
+	"Aethernet/pkg/device"
+	"sync"
+	"testing"
+	"time"
+)
+
+// three nodes send to each other in turn, the fourth of the ring is dead and its turns are skipped
+func TestTokenPassing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("The nodes run in real time")
+	}
+
+	const (
+		NODES        = 3
+		PACKETS      = 3
+		PAYLOAD_SIZE = 30
+	)
+
+	ring := []ReliableDataLinkAddress{0, 1, 2, 3}
+
+	network := device.Network[string]{
+		Config:     make(device.NetworkConfig[string], NODES),
+		SampleRate: 48000 / device.BufferSize,
+	}
+	for i := range network.Config {
+		network.Config[i].In, network.Config[i].Out = "air", "air"
+	}
+	devices := network.Build()
+
+	nodes := make([]*ReliableDataLinkLayer, NODES)
+	for i := range nodes {
+		nodes[i] = newCSMANode(devices[i], ReliableDataLinkAddress(i), PACKETS*2)
+		nodes[i].Token = &TokenPassing{
+			Ring:        ring,
+			Hold:        300 * time.Millisecond,
+			PassTimeout: 200 * time.Millisecond,
+			LostTimeout: 500 * time.Millisecond,
+		}
+		nodes[i].Open()
+	}
+
+	var wg sync.WaitGroup
+	var latency [NODES]time.Duration
+	for i := range NODES {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range PACKETS {
+				payload := make([]byte, PAYLOAD_SIZE)
+				payload[0], payload[1] = byte(i), byte(k)
+				start := time.Now()
+				if err := nodes[i].Send(nodes[(i+1)%NODES].Address, payload); err != nil {
+					t.Errorf("Node %d: %v", i, err)
+					return
+				}
+				latency[i] = max(latency[i], time.Since(start))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, node := range nodes {
+		received := 0
+		for len(node.ReceiveAsync()) > 0 {
+			packet := <-node.ReceiveAsync()
+			if int(packet[0]) != (i+NODES-1)%NODES {
+				t.Errorf("Node %d received a packet of node %d", i, packet[0])
+			}
+			received++
+		}
+		stats := node.Token.Stats()
+		t.Logf("Node %d: %d packets received, longest send %v, %d collisions, %+v", i, received, latency[i], node.CSMA.Stats().Failures, stats)
+		if received != PACKETS {
+			t.Errorf("Node %d received %d packets, expected %d", i, received, PACKETS)
+		}
+		if node.CSMA.Stats().Failures != 0 {
+			t.Errorf("Node %d collided while holding the token", i)
+		}
+	}
+	if nodes[0].Token.Stats().Regenerations == 0 {
+		t.Errorf("The first node of the ring did not generate the token")
+	}
+	// the token goes on round the ring past the dead node
+	for deadline := time.Now().Add(2 * time.Second); nodes[NODES-1].Token.Stats().Skips == 0; {
+		if time.Now().After(deadline) {
+			t.Errorf("The dead node was not skipped")
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	for _, node := range nodes {
+		node.Close()
+	}
+}
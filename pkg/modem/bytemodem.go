@@ -4,6 +4,8 @@ import (
 	"Aethernet/pkg/async"
 	"Aethernet/pkg/fixed"
 	"fmt"
+	"math/cmplx"
+	"slices"
 	"sync"
 	"time"
 )
@@ -31,10 +33,30 @@ type Modulator struct {
 	BytePerFrame         int // number of bytes per frame
 	FrameInterval        int // number of ticks as interval between frames
 	Amplitude            int32
+	Passband             *Passband      // the carrier of the passband mode, nil for the baseband levels
+	Constellation        *Constellation // the symbols of the data, nil for the binary symbols of the header, only the real ones in baseband
+	Equalizer            *Equalizer     // sends the training sequence of the equalizer after the preamble, may be nil
 
 	crcChecker CRC8Checker
 }
 
+// the constellation of the header or the data symbols, nil for the baseband levels of one bit.
+// The header keeps the binary symbols, so the receiver measures the amplitude of the data on it.
+func symbolConstellation(passband *Passband, constellation *Constellation, header bool) *Constellation {
+	switch {
+	case header && passband != nil:
+		return passband.constellation()
+	case header && constellation != nil:
+		return bpskConstellation
+	case constellation != nil:
+		return constellation
+	case passband != nil:
+		return passband.constellation()
+	default:
+		return nil
+	}
+}
+
 type DemodulateStateEnum int
 
 const (
@@ -56,6 +78,13 @@ type Demodulator struct {
 	CarrierSizeForHeader     int // the size of the carrier for the header
 	BufferSize               int // the size of the buffer for the output channel
 	DemodulatePowerThreshold fixed.T
+	Passband                 *Passband      // the carrier of the passband mode, nil for the baseband levels
+	Constellation            *Constellation // the symbols of the data, must be the one of the Modulator
+	Equalizer                *Equalizer     // equalizes the frames on their training sequence, must be the one of the Modulator, may be nil
+	Detector                 *Detector      // detects the preamble with the normalised cross-correlation instead of the power threshold, may be nil
+
+	Trace func(report FrameReport) // called when a frame ends, successfully or not, may be nil
+	Soft  func(llrs []float64)     // called with the log-likelihood ratios of the bits of every data symbol for a soft decision decoder, may be nil
 
 	outputChan  chan []byte // demodulated data will be sent to this channel, the channel has no buffer, so the receiver must be ready to receive the data
 	errorSignal async.Signal[error]
@@ -75,7 +104,6 @@ type Demodulator struct {
 	resampler                  AdjustmentResampler
 	distanceFromStart          int
 	distanceFromPotentialStart int
-	powerHistory               []fixed.T
 
 	// data extraction
 	crcChecker  CRC8Checker
@@ -91,10 +119,20 @@ type Demodulator struct {
 	currentChunk  []byte
 	currentPacket []byte
 
+	// diagnostics
+	position      int // the number of samples received
+	peakPosition  int
+	currentReport *FrameReport
+
 	dataExtractionState DataExtractionStateEnum
 	carrierTick         int     // the current carrier tick [0, len(carrier)]
 	sum                 fixed.T // sum of the product of the current sample and the current carrier
-
+	passband            passbandReceiver
+	equalizer           equalizerReceiver
+	reference           struct { // the received header symbols, whose modulus is 1 when sent
+		sum, squares float64
+		count        int
+	}
 }
 
 type AdjustmentResampler struct {
@@ -127,7 +165,6 @@ func (d *Demodulator) Reset() {
 	d.localMaxPower = fixed.Zero
 	d.localMaxPowerPrev = fixed.Zero
 	d.distanceFromPotentialStart = -1
-	d.powerHistory = make([]fixed.T, 0)
 
 	d.crcChecker.Reset()
 	d.currentBits.data.Value = 0
@@ -139,6 +176,10 @@ func (d *Demodulator) Reset() {
 	d.dataExtractionState = receiveHeader
 	d.carrierTick = 0
 	d.sum = fixed.Zero
+	d.currentReport = nil
+	if d.Detector != nil {
+		d.Detector.Reset()
+	}
 }
 
 func (m Modulator) Modulate(inputBytes []byte) []int32 {
@@ -162,7 +203,33 @@ func (m Modulator) Modulate(inputBytes []byte) []int32 {
 	}
 
 	var samplePerBit int
+	var n int                        // number of samples since the end of the preamble, the phase of the passband carrier
+	var constellation *Constellation // nil for the baseband levels of one bit
+	var symbol []bool
+	appendSymbol := func() {
+		// the last symbol of a frame is padded with zeros
+		for len(symbol) < constellation.BitPerSymbol() {
+			symbol = append(symbol, false)
+		}
+		point := constellation.Map(symbol)
+		if m.Passband != nil {
+			modulatedData = m.Passband.appendSymbol(modulatedData, n, point, samplePerBit, m.Amplitude)
+		} else {
+			for range samplePerBit {
+				modulatedData = append(modulatedData, int32(float64(m.Amplitude)*real(point)))
+			}
+		}
+		n += samplePerBit
+		symbol = symbol[:0]
+	}
 	modulateBit := func(bit bool) {
+		if constellation != nil {
+			symbol = append(symbol, bit)
+			if len(symbol) == constellation.BitPerSymbol() {
+				appendSymbol()
+			}
+			return
+		}
 		for range samplePerBit {
 			if bit {
 				modulatedData = append(modulatedData, -m.Amplitude)
@@ -177,6 +244,15 @@ func (m Modulator) Modulate(inputBytes []byte) []int32 {
 
 		// add the preamble
 		modulatedData = append(modulatedData, m.Preamble...)
+		if m.Equalizer != nil {
+			modulatedData = m.Equalizer.appendTraining(modulatedData, m.Amplitude)
+		}
+
+		n = 0
+		if m.Passband != nil {
+			modulatedData = m.Passband.appendPilot(modulatedData, m.Amplitude)
+			n = m.Passband.pilotSize()
+		}
 
 		// add the header
 		if len(bytes) > 127 {
@@ -192,11 +268,13 @@ func (m Modulator) Modulate(inputBytes []byte) []int32 {
 		}
 		header[1] = byte(i)
 		samplePerBit = m.CarrierSizeForHeader
+		constellation = symbolConstellation(m.Passband, m.Constellation, true)
 		for _, b := range header {
 			BitSet(B8B10[b]).ForEach(modulateBit, 10)
 		}
 
 		samplePerBit = m.CarrierSize
+		constellation = symbolConstellation(m.Passband, m.Constellation, false)
 
 		// modulate the data
 		m.crcChecker.Reset()
@@ -208,6 +286,9 @@ func (m Modulator) Modulate(inputBytes []byte) []int32 {
 
 		// modulate the CRC8 byte
 		BitSet(B8B10[crcByte]).ForEach(modulateBit, 10)
+		if len(symbol) > 0 {
+			appendSymbol()
+		}
 
 		// add the interval
 		for j := 0; j < m.FrameInterval; j++ {
@@ -233,6 +314,7 @@ func (d *Demodulator) Demodulate(inputSignal []int32) (err error) {
 }
 
 func (d *Demodulator) Update(currentSample int32) (err error) {
+	d.position++
 
 	switch d.demodulateState {
 	case preambleDetection:
@@ -256,6 +338,10 @@ func estimateAdjustment(pl, pm, pr fixed.T) (adj fixed.T) {
 
 func (d *Demodulator) detectPreamble(currentSample int32) (err error) {
 
+	if d.Detector != nil {
+		return d.detectPreambleNormalised(currentSample)
+	}
+
 	d.currentWindow = append(d.currentWindow, currentSample)
 
 	if len(d.currentWindow) < len(d.Preamble) {
@@ -263,7 +349,6 @@ func (d *Demodulator) detectPreamble(currentSample int32) (err error) {
 	}
 
 	power := dotProduct(d.currentWindow, d.Preamble)
-	d.powerHistory = append(d.powerHistory, power)
 
 	poppedSample := d.currentWindow[0]
 	d.currentWindow = d.currentWindow[1:]
@@ -272,6 +357,7 @@ func (d *Demodulator) detectPreamble(currentSample int32) (err error) {
 	if power > d.localMaxPower && power > d.DemodulatePowerThreshold {
 		debugLog("[Demodulation] find a potential start of the signal where power: %.2f\n", fixed.T(power).Float())
 		d.localMaxPower = power
+		d.peakPosition = d.position - 1
 		d.localMaxPowerPrev = d.powerPrev - fixed.T((int64(d.lastPoppedSample)*int64(d.Preamble[0]))>>(31+fixed.N))
 		d.frameToDecode = d.frameToDecode[:0]
 		d.distanceFromPotentialStart = 0
@@ -293,12 +379,10 @@ func (d *Demodulator) detectPreamble(currentSample int32) (err error) {
 				d.adjustment = AJUST_THRESHOLD
 			}
 			if d.adjustment > 0 {
-				d.resampler.P = d.adjustment
+				d.alignData(d.adjustment)
 			} else {
-				d.resampler.P = -d.adjustment
+				d.alignData(-d.adjustment)
 			}
-			d.resampler.LastSample = fixed.T(d.frameToDecode[1] >> fixed.N)
-			d.frameToDecode = d.frameToDecode[2:]
 			if d.resampler.P < 0 || d.resampler.P > fixed.One {
 				panic("Invalid adjustment")
 			}
@@ -315,27 +399,80 @@ func (d *Demodulator) detectPreamble(currentSample int32) (err error) {
 		debugLog("[Demodulation] find the start of the signal where adjustment %.2f\n", d.adjustment.Float())
 
 		d.distanceFromStart = 0
+		if d.Trace != nil {
+			d.currentReport = &FrameReport{Position: d.peakPosition, Power: d.localMaxPower.Float()}
+		}
 
 		// determine whether to flip
 		d.localMaxPower = 0
 		d.currentWindow = d.currentWindow[:0]
 		d.distanceFromPotentialStart = -1
-		d.demodulateState = dataExtraction
-		d.currentBits.data.Value = 0
-		d.currentBits.count = 0
-		for _, sample := range d.frameToDecode {
-			if d.demodulateState == dataExtraction {
-				err = d.extractData(sample)
-				if err != nil {
-					return
-				}
-			} else {
-				break
+		if err = d.startDataExtraction(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (d *Demodulator) detectPreambleNormalised(currentSample int32) (err error) {
+	peak, detection := d.Detector.Update(currentSample)
+	if peak {
+		// this sample is the end of the preamble
+		d.peakPosition = d.position - 1
+		d.frameToDecode = append(d.frameToDecode[:0], currentSample)
+	} else if d.Detector.pending || detection != nil {
+		d.frameToDecode = append(d.frameToDecode, currentSample)
+	}
+	if detection == nil {
+		return
+	}
+
+	debugLog("[Demodulation] find the start of the signal where correlation %.2f, confidence %.2f\n", detection.Correlation, detection.Confidence)
+
+	if d.Trace != nil {
+		d.currentReport = &FrameReport{Position: d.peakPosition, Power: detection.Correlation, Confidence: detection.Confidence}
+	}
+	d.Detector.Reset()
+
+	// no adjustment, the data starts right after the end of the preamble
+	d.alignData(0)
+	return d.startDataExtraction()
+}
+
+// frameToDecode starts with the end of the preamble, the first data sample is kept in the resampler
+// which outputs it moved by the fraction p of a sample towards the next one
+func (d *Demodulator) alignData(p fixed.T) {
+	d.resampler.P = p
+	d.resampler.LastSample = fixed.T(d.frameToDecode[1] >> fixed.N)
+	d.frameToDecode = d.frameToDecode[2:]
+}
+
+// extracts the data from the samples buffered since the end of the preamble
+func (d *Demodulator) startDataExtraction() (err error) {
+	d.distanceFromStart = 0
+	if d.Equalizer != nil {
+		d.equalizer.Equalizer = d.Equalizer
+		d.equalizer.reset()
+	}
+	if d.Passband != nil {
+		d.passband.Passband = d.Passband
+		d.passband.reset()
+	}
+	d.reference.sum, d.reference.squares, d.reference.count = 0, 0, 0
+	d.demodulateState = dataExtraction
+	d.currentBits.data.Value = 0
+	d.currentBits.count = 0
+	for _, sample := range d.frameToDecode {
+		if d.demodulateState == dataExtraction {
+			err = d.extractData(sample)
+			if err != nil {
+				return
 			}
+		} else {
+			break
 		}
-		d.frameToDecode = d.frameToDecode[:0]
-		d.powerHistory = d.powerHistory[:0]
 	}
+	d.frameToDecode = d.frameToDecode[:0]
 	return
 }
 
@@ -350,13 +487,17 @@ func (d *Demodulator) extractData(currentSample int32) (err error) {
 	}
 
 	cur := d.resampler.Update(fixed.T(currentSample >> fixed.N))
+	if d.Equalizer != nil {
+		equalized, ok := d.equalizer.update(cur.Float())
+		if !ok {
+			return
+		}
+		cur = fixed.FromFloat(equalized)
+	}
 
 	// expectLength := ((d.currentHeader.size+1)*d.CarrierSize + 1*d.CarrierSizeForHeader) * 10
 	// fmt.Printf("Extract data %d/%d: %f\n", d.distanceFromStart, expectLength, cur.Float())
 
-	d.sum += cur
-	d.carrierTick += 1
-
 	var samplePerBit int
 	switch d.dataExtractionState {
 	case receiveHeader:
@@ -367,6 +508,13 @@ func (d *Demodulator) extractData(currentSample int32) (err error) {
 		samplePerBit = d.CarrierSize
 	}
 
+	if constellation := symbolConstellation(d.Passband, d.Constellation, d.dataExtractionState == receiveHeader); constellation != nil {
+		return d.extractSymbol(cur, samplePerBit, constellation, currentSample)
+	}
+
+	d.sum += cur
+	d.carrierTick += 1
+
 	if d.carrierTick%samplePerBit > 0 {
 		return
 	}
@@ -386,14 +534,88 @@ func (d *Demodulator) extractData(currentSample int32) (err error) {
 	if d.currentBits.count < 10 {
 		return
 	}
+	return d.receiveWord(currentSample)
+}
+
+// receives the sample of a symbol of the constellation, whose bits may span two 10-bit words
+func (d *Demodulator) extractSymbol(cur fixed.T, samplePerBit int, constellation *Constellation, currentSample int32) (err error) {
+	var z complex128
+	if d.Passband != nil {
+		var ok bool
+		if z, ok = d.passband.update(cur.Float(), samplePerBit); !ok {
+			return
+		}
+	} else {
+		d.sum += cur
+		d.carrierTick += 1
+		if d.carrierTick%samplePerBit > 0 {
+			return
+		}
+		z = complex(d.sum.Float(), 0)
+		d.sum = 0
+		d.carrierTick = 0
+	}
 
+	for _, bit := range d.demap(z, constellation) {
+		if bit {
+			d.currentBits.data.Set(d.currentBits.count)
+		}
+		d.currentBits.count += 1
+		if d.currentBits.count < 10 {
+			continue
+		}
+		// the rest of the symbol after the end of the frame is padding
+		if err = d.receiveWord(currentSample); err != nil || d.demodulateState != dataExtraction {
+			return
+		}
+	}
+	return
+}
+
+// decides the bits of a received symbol, scaled by the amplitude of the header symbols
+func (d *Demodulator) demap(z complex128, constellation *Constellation) []bool {
+	header := d.dataExtractionState == receiveHeader
+	if header {
+		a := cmplx.Abs(z)
+		d.reference.sum += a
+		d.reference.squares += a * a
+		d.reference.count++
+	}
+
+	amplitude := d.reference.sum / float64(d.reference.count)
+	if amplitude == 0 {
+		amplitude = 1
+	}
+	normalised := z / complex(amplitude, 0)
+	bits := constellation.Demap(normalised)
+	if d.Passband != nil {
+		d.passband.track(z, constellation.Map(bits))
+	}
+
+	if d.Soft != nil && !header {
+		// the noise variance is estimated from the spread of the header symbols
+		n := float64(d.reference.count)
+		noise := max(d.reference.squares/n/(amplitude*amplitude)-1, 1e-3)
+		d.Soft(constellation.SoftDemap(normalised, noise))
+	}
+	return bits
+}
+
+// decodes the 10 received bits and passes the byte to the current state
+func (d *Demodulator) receiveWord(currentSample int32) (err error) {
 	currentByte, exists := B10B8[d.currentBits.data.Value]
 	if !exists {
 		err = fmt.Errorf("B10B8 does not contain key %v", d.currentBits.data.Value)
+		d.trace(err)
 		d.currentBits.data.Value = 0
 		d.currentBits.count = 0
 		d.demodulateState = preambleDetection
 		d.currentPacket = []byte{}
+		// the next frame starts with its header, not with the rest of this one
+		d.dataExtractionState = receiveHeader
+		d.currentChunk = d.currentChunk[:0]
+		d.currentHeader.done = false
+		d.currentHeader.size = 0
 		return
 	}
 	d.currentBits.data.Value = 0
@@ -431,11 +653,15 @@ func (d *Demodulator) receiveHeader(currentSample byte) (err error) {
 		// the current packet is not following the previous packet
 		err = fmt.Errorf("current index %d is not the expected index %d", currentIndex, d.currentHeader.index+1)
 		d.demodulateState = preambleDetection
+		d.trace(err)
 		return
 	}
 	d.currentHeader.done = d.currentChunk[0]&0b10000000 != 0
 	d.currentHeader.size = int(d.currentChunk[0] & 0b01111111)
 	d.currentHeader.index = int(d.currentChunk[1])
+	if d.currentReport != nil {
+		d.currentReport.Header = FrameHeader{Size: d.currentHeader.size, Index: d.currentHeader.index, IsLast: d.currentHeader.done}
+	}
 	if d.currentHeader.done {
 		debugLog("[Demodulation] Last packet got\n")
 	}
@@ -443,6 +669,7 @@ func (d *Demodulator) receiveHeader(currentSample byte) (err error) {
 	if d.currentHeader.size == 0 { // invalid packet
 		err = fmt.Errorf("header.size is 0, invalid packet")
 		d.demodulateState = preambleDetection
+		d.trace(err)
 		return
 	}
 
@@ -463,6 +690,17 @@ func (d *Demodulator) receiveData(currentSample byte) (err error) {
 
 func (d *Demodulator) receiveCRC(currentSample byte) (err error) {
 	crcOK := d.crcChecker.Get() == currentSample
+	if d.currentReport != nil {
+		d.currentReport.HeaderOK = true
+		d.currentReport.CRCOK = crcOK
+		d.currentReport.Data = slices.Clone(d.currentChunk)
+	}
+	if !crcOK {
+		err = fmt.Errorf("CRC8 check failed")
+	}
+	// the report goes ahead of its packet
+	d.trace(err)
+
 	if crcOK {
 		d.currentPacket = append(d.currentPacket, d.currentChunk...)
 		debugLog("[Demodulation] CRC8 check passed length %d\n", len(d.currentPacket))
@@ -478,8 +716,6 @@ func (d *Demodulator) receiveCRC(currentSample byte) (err error) {
 			}
 			d.currentPacket = []byte{}
 		}
-	} else {
-		err = fmt.Errorf("CRC8 check failed")
 	}
 
 	d.currentChunk = d.currentChunk[:0]
@@ -490,6 +726,16 @@ func (d *Demodulator) receiveCRC(currentSample byte) (err error) {
 	return
 }
 
+// reports the current frame to the trace hook
+func (d *Demodulator) trace(err error) {
+	if d.currentReport == nil {
+		return
+	}
+	d.currentReport.Err = err
+	d.Trace(*d.currentReport)
+	d.currentReport = nil
+}
+
 func (d *Demodulator) signalError(err error) {
 	if d.errorSignal == nil {
 		panic("errorSignal is nil")
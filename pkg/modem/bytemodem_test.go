@@ -39,6 +39,7 @@ func TestNaiveByteModem(t *testing.T) {
 	rand.Read(inputBytes)
 
 	modulatedData := modem.Modulate(inputBytes)
+	modem.Demodulator.Init()
 	go modem.Demodulate(modulatedData)
 	outputBytes := <-modem.Demodulator.ReceiveAsync()
 
@@ -46,3 +47,35 @@ func TestNaiveByteModem(t *testing.T) {
 		t.Errorf("inputBytes and outputBytes are different")
 	}
 }
+
+// a frame broken in the middle of its data does not spoil the header of the next one
+func TestDemodulateAfterBrokenFrame(t *testing.T) {
+
+	const CARRIER_SIZE = 3
+
+	var preamble = DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+
+	modulator := Modulator{Preamble: preamble, CarrierSize: CARRIER_SIZE, BytePerFrame: 125, FrameInterval: 10}
+	demodulator := Demodulator{Preamble: preamble, CarrierSize: CARRIER_SIZE, DemodulatePowerThreshold: fixed.FromFloat(10), BufferSize: 2}
+	demodulator.Init()
+
+	broken := modulator.Modulate(make([]byte, 100))
+	// silence is not a valid 10 bit word
+	start := len(preamble) + 20*10*CARRIER_SIZE
+	clear(broken[start : start+2*10*CARRIER_SIZE])
+
+	inputBytes := []byte("the next frame")
+	signal := append(broken, make([]int32, 1000)...)
+	signal = append(signal, modulator.Modulate(inputBytes)...)
+	signal = append(signal, make([]int32, 1000)...)
+	demodulator.Demodulate(signal)
+
+	select {
+	case outputBytes := <-demodulator.ReceiveAsync():
+		if !reflect.DeepEqual(inputBytes, outputBytes) {
+			t.Errorf("Received %q, expected %q", outputBytes, inputBytes)
+		}
+	default:
+		t.Errorf("The frame after the broken one is lost")
+	}
+}
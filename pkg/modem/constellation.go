@@ -0,0 +1,141 @@
+package modem
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"strings"
+)
+
+// Constellation maps groups of bits to symbols with Gray coding, so that the nearest symbols differ by one bit.
+// The symbols are scaled so that the largest has a modulus of 1, i.e. the peak is the amplitude of the modulator.
+type Constellation struct {
+	Name   string
+	Points []complex128 // the symbol of every label, the first bit is the most significant bit of the label
+
+	bits int
+}
+
+// pulse amplitude modulation on the real axis, 2 levels is BPSK
+func PAM(levels int) *Constellation {
+	n := bits.Len(uint(levels)) - 1
+	if levels < 2 || 1<<n != levels {
+		panic(fmt.Sprintf("PAM needs a power of 2 levels, got %d", levels))
+	}
+	c := &Constellation{Name: fmt.Sprintf("pam%d", levels), Points: make([]complex128, levels), bits: n}
+	for i, level := range pamLevels(levels) {
+		c.Points[i] = complex(level, 0)
+	}
+	if levels == 2 {
+		c.Name = "bpsk"
+	}
+	return c
+}
+
+// square quadrature amplitude modulation, the first half of the bits on the real axis, 4 points is QPSK
+func QAM(order int) *Constellation {
+	n := bits.Len(uint(order)) - 1
+	if order < 4 || 1<<n != order || n%2 != 0 {
+		panic(fmt.Sprintf("QAM needs an even power of 2 points, got %d", order))
+	}
+	side := 1 << (n / 2)
+	levels := pamLevels(side)
+	c := &Constellation{Name: fmt.Sprintf("qam%d", order), Points: make([]complex128, order), bits: n}
+	for i := range levels {
+		for q := range levels {
+			c.Points[i<<(n/2)|q] = complex(levels[i], levels[q]) / math.Sqrt2
+		}
+	}
+	if order == 4 {
+		c.Name = "qpsk"
+	}
+	return c
+}
+
+// the level of every Gray label, from +1 for the label 0 down to -1
+func pamLevels(levels int) []float64 {
+	values := make([]float64, levels)
+	for i := range levels {
+		gray := i ^ (i >> 1)
+		values[gray] = float64(levels-1-2*i) / float64(levels-1)
+	}
+	return values
+}
+
+func ParseConstellation(s string) (*Constellation, error) {
+	switch strings.ToLower(s) {
+	case "bpsk":
+		return PAM(2), nil
+	case "pam4":
+		return PAM(4), nil
+	case "pam8":
+		return PAM(8), nil
+	case "qpsk":
+		return QAM(4), nil
+	case "qam16":
+		return QAM(16), nil
+	default:
+		return nil, fmt.Errorf("unknown constellation %s, expected 'bpsk', 'pam4', 'pam8', 'qpsk' or 'qam16'", s)
+	}
+}
+
+func (c *Constellation) BitPerSymbol() int {
+	return c.bits
+}
+
+// whether every symbol is on the real axis, which is required in baseband
+func (c *Constellation) IsReal() bool {
+	for _, p := range c.Points {
+		if imag(p) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Constellation) Map(bits []bool) complex128 {
+	label := 0
+	for _, bit := range bits[:c.bits] {
+		label <<= 1
+		if bit {
+			label |= 1
+		}
+	}
+	return c.Points[label]
+}
+
+func (c *Constellation) label(i int) []bool {
+	bits := make([]bool, c.bits)
+	for k := range bits {
+		bits[k] = i&(1<<(c.bits-1-k)) != 0
+	}
+	return bits
+}
+
+// the bits of the nearest symbol
+func (c *Constellation) Demap(z complex128) []bool {
+	best, bestDistance := 0, math.Inf(1)
+	for i, p := range c.Points {
+		if d := cmplx.Abs(z - p); d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	return c.label(best)
+}
+
+// the max-log log-likelihood ratio log(P(0)/P(1)) of every bit for a symbol received with the noise variance,
+// positive for a 0 and the larger the more reliable
+func (c *Constellation) SoftDemap(z complex128, noiseVariance float64) []float64 {
+	llrs := make([]float64, c.bits)
+	for k := range llrs {
+		nearest := [2]float64{math.Inf(1), math.Inf(1)}
+		for i, p := range c.Points {
+			bit := (i >> (c.bits - 1 - k)) & 1
+			d := z - p
+			nearest[bit] = min(nearest[bit], real(d)*real(d)+imag(d)*imag(d))
+		}
+		llrs[k] = (nearest[1] - nearest[0]) / noiseVariance
+	}
+	return llrs
+}
@@ -0,0 +1,144 @@
+package modem
+
+import (
+	"Aethernet/pkg/fixed"
+	"bytes"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestConstellation(t *testing.T) {
+	for _, name := range []string{"bpsk", "pam4", "pam8", "qpsk", "qam16"} {
+		c, err := ParseConstellation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.Points) != 1<<c.BitPerSymbol() {
+			t.Fatalf("%s has %d points for %d bits", name, len(c.Points), c.BitPerSymbol())
+		}
+
+		peak := 0.0
+		for i, p := range c.Points {
+			peak = max(peak, cmplx.Abs(p))
+
+			// every label is mapped to its point and back
+			bits := c.label(i)
+			if c.Map(bits) != p || !slices.Equal(c.Demap(p), bits) {
+				t.Errorf("%s: label %v is not mapped to %v", name, bits, p)
+			}
+			for k, llr := range c.SoftDemap(p+complex(0.01, 0.01), 0.1) {
+				if llr > 0 == bits[k] {
+					t.Errorf("%s: the LLR %v of bit %d of %v has the wrong sign", name, llr, k, bits)
+				}
+			}
+
+			// Gray coding, the nearest points differ by one bit
+			nearest := math.Inf(1)
+			for j, q := range c.Points {
+				if j != i {
+					nearest = min(nearest, cmplx.Abs(p-q))
+				}
+			}
+			for j, q := range c.Points {
+				if j != i && cmplx.Abs(p-q) < nearest+1e-9 {
+					if differ := bitsDiffer(c.label(i), c.label(j)); differ != 1 {
+						t.Errorf("%s: the neighbours %v and %v differ by %d bits", name, p, q, differ)
+					}
+				}
+			}
+		}
+		if math.Abs(peak-1) > 1e-9 {
+			t.Errorf("%s has a peak of %v, expected 1", name, peak)
+		}
+	}
+
+	if _, err := ParseConstellation("qam8"); err == nil {
+		t.Errorf("Expected an error for an unknown constellation")
+	}
+
+	// a noisy symbol is less reliable than a clean one
+	qam := QAM(16)
+	clean := qam.SoftDemap(qam.Points[5], 0.1)
+	noisy := qam.SoftDemap(qam.Points[5]-0.1, 0.1) // towards the boundary of the first bit
+	if math.Abs(noisy[0]) >= math.Abs(clean[0]) {
+		t.Errorf("The LLR %v of a noisy symbol is not below the LLR %v of the clean one", noisy[0], clean[0])
+	}
+}
+
+func bitsDiffer(a, b []bool) (count int) {
+	for i := range a {
+		if a[i] != b[i] {
+			count++
+		}
+	}
+	return
+}
+
+// frames with several bits per symbol in baseband and passband, with the soft decisions of every data symbol
+func TestModulateConstellation(t *testing.T) {
+	preamble := DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+	passband := &Passband{Scheme: QPSK, Freq: 4000, SampleRate: 48000}
+	cases := []struct {
+		constellation *Constellation
+		passband      *Passband
+		carrierSize   int
+	}{
+		{PAM(4), nil, 3},
+		{PAM(8), nil, 3},
+		{QAM(16), passband, 12},
+		{PAM(2), passband, 12},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	payload := make([]byte, 250)
+	r.Read(payload)
+
+	for _, c := range cases {
+		t.Run(c.constellation.Name, func(t *testing.T) {
+			modulator := Modulator{
+				Preamble:      preamble,
+				CarrierSize:   c.carrierSize,
+				BytePerFrame:  100,
+				FrameInterval: 256,
+				Amplitude:     0x7fffffff / 2,
+				Passband:      c.passband,
+				Constellation: c.constellation,
+			}
+			signal := append(make([]int32, 1000), modulator.Modulate(payload)...)
+			for i, sample := range signal {
+				x := float64(sample)/0x7fffffff + 0.005*r.NormFloat64()
+				signal[i] = int32(max(min(x, 1), -1) * 0x7fffffff)
+			}
+
+			var llrs int
+			demodulator := Demodulator{
+				Preamble:                 preamble,
+				CarrierSize:              c.carrierSize,
+				DemodulatePowerThreshold: fixed.FromFloat(5),
+				Passband:                 c.passband,
+				Constellation:            c.constellation,
+				Soft:                     func(symbol []float64) { llrs += len(symbol) },
+			}
+			packets, reports := demodulator.DemodulateAll(signal)
+			if len(packets) != 1 || !bytes.Equal(packets[0], payload) {
+				for _, report := range reports {
+					t.Log(report)
+				}
+				t.Fatalf("Got %d packets, expected the payload", len(packets))
+			}
+
+			// the data and the CRC of every frame, the last symbol of a frame padded
+			bits := c.constellation.BitPerSymbol()
+			expected := 0
+			for _, size := range []int{100, 100, 50} {
+				expected += (10*(size+1) + bits - 1) / bits * bits
+			}
+			if llrs != expected {
+				t.Errorf("Got %d soft decisions, expected %d", llrs, expected)
+			}
+		})
+	}
+}
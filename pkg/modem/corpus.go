@@ -0,0 +1,147 @@
+package modem
+
+import (
+	"Aethernet/internel/utils"
+	"Aethernet/pkg/fixed"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Capture is a recorded signal with the payload that was sent, described by a JSON manifest like
+//
+//	{
+//	  "capture": "office.wav",
+//	  "payload": "office.payload",
+//	  "modem": {"carrier_size": 3, "byte_per_frame": 100, "preamble_n": 4, "threshold": 30},
+//	  "max_ber": 0.001,
+//	  "max_frame_loss": 0
+//	}
+//
+// The capture is a WAV file or the raw int32 samples written from a callbacks.Recorder.
+type Capture struct {
+	Name        string `json:"-"`
+	File        string `json:"capture"`
+	Payload     string `json:"payload"`
+	Synthetic   bool   `json:"synthetic"` // generated instead of recorded, must be set for any capture that is not real audio
+	Description string `json:"description"`
+
+	Modem struct {
+		CarrierSize          int     `json:"carrier_size"`
+		CarrierSizeForHeader int     `json:"carrier_size_for_header"`
+		BytePerFrame         int     `json:"byte_per_frame"`
+		PreambleN            int     `json:"preamble_n"`
+		Threshold            float64 `json:"threshold"`
+	} `json:"modem"`
+
+	MaxBER       float64 `json:"max_ber"`
+	MaxFrameLoss float64 `json:"max_frame_loss"`
+}
+
+type CaptureResult struct {
+	BER         float64 // wrong or missing payload bits over the payload bits
+	FrameLoss   float64 // frames not received with a valid CRC over the frames sent
+	Frames      int     // frames sent
+	FramesFound int     // frames whose preamble was detected, including the corrupted ones
+	Received    []byte
+	Reports     []FrameReport
+}
+
+func (r CaptureResult) String() string {
+	return fmt.Sprintf("BER %.2e, frame loss %.2f%% (%d sent, %d found), %d bytes received",
+		r.BER, r.FrameLoss*100, r.Frames, r.FramesFound, len(r.Received))
+}
+
+// loads the manifests (*.json) of a corpus directory, the file paths are relative to the directory
+func LoadCorpus(dir string) ([]Capture, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	captures := make([]Capture, 0, len(manifests))
+	for _, manifest := range manifests {
+		data, err := os.ReadFile(manifest)
+		if err != nil {
+			return nil, err
+		}
+		var capture Capture
+		if err := json.Unmarshal(data, &capture); err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %v", manifest, err)
+		}
+		capture.Name = strings.TrimSuffix(filepath.Base(manifest), ".json")
+		capture.File = filepath.Join(dir, capture.File)
+		capture.Payload = filepath.Join(dir, capture.Payload)
+		captures = append(captures, capture)
+	}
+	return captures, nil
+}
+
+func (c Capture) Demodulator() Demodulator {
+	preamble := DigitalChripConfig{N: c.Modem.PreambleN, Amplitude: 0x7fffffff}.New()
+	return Demodulator{
+		Preamble:                 preamble,
+		CarrierSize:              c.Modem.CarrierSize,
+		CarrierSizeForHeader:     c.Modem.CarrierSizeForHeader,
+		DemodulatePowerThreshold: fixed.FromFloat(c.Modem.Threshold),
+	}
+}
+
+// demodulates the capture and compares the result with the payload
+func (c Capture) Evaluate() (CaptureResult, error) {
+	var signal []int32
+	var err error
+	if strings.EqualFold(filepath.Ext(c.File), ".wav") {
+		signal, _, err = utils.ReadWAV(c.File)
+	} else {
+		signal, err = utils.ReadBinary[int32](c.File)
+	}
+	if err != nil {
+		return CaptureResult{}, err
+	}
+	payload, err := os.ReadFile(c.Payload)
+	if err != nil {
+		return CaptureResult{}, err
+	}
+	if c.Modem.BytePerFrame <= 0 {
+		return CaptureResult{}, fmt.Errorf("modem.byte_per_frame must be positive")
+	}
+
+	demodulator := c.Demodulator()
+	packets, reports := demodulator.DemodulateAll(signal)
+
+	result := CaptureResult{
+		Frames:      (len(payload) + c.Modem.BytePerFrame - 1) / c.Modem.BytePerFrame,
+		FramesFound: len(reports),
+		Reports:     reports,
+	}
+	for _, packet := range packets {
+		result.Received = append(result.Received, packet...)
+	}
+
+	received := 0
+	for _, report := range reports {
+		if report.CRCOK {
+			received++
+		}
+	}
+	if result.Frames > 0 {
+		result.FrameLoss = float64(max(result.Frames-received, 0)) / float64(result.Frames)
+	}
+	if len(payload) > 0 {
+		result.BER = float64(BitErrors(payload, result.Received)) / float64(8*len(payload))
+	}
+	return result, nil
+}
+
+// counts the differing bits, the missing or extra bytes count as 8 errors each
+func BitErrors(expected, actual []byte) int {
+	n := min(len(expected), len(actual))
+	errors := 8 * (max(len(expected), len(actual)) - n)
+	for i := range n {
+		errors += bits.OnesCount8(expected[i] ^ actual[i])
+	}
+	return errors
+}
@@ -0,0 +1,115 @@
+package modem
+
+import (
+	"Aethernet/internel/utils"
+	"encoding/json"
+	"flag"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const corpusDir = "testdata/corpus"
+
+var generateCorpus = flag.Bool("corpus.generate", false, "regenerate the synthetic captures of the corpus")
+
+// runs every capture of the corpus through the demodulator, the recordings of real audio can be dropped in the corpus directory with their manifests
+func TestCorpus(t *testing.T) {
+	captures, err := LoadCorpus(corpusDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) == 0 {
+		t.Skip("No capture in the corpus")
+	}
+
+	for _, capture := range captures {
+		t.Run(capture.Name, func(t *testing.T) {
+			result, err := capture.Evaluate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			kind := "recorded"
+			if capture.Synthetic {
+				kind = "synthetic"
+			}
+			t.Logf("[%s, %s] %v", capture.Name, kind, result)
+			for i, report := range result.Reports {
+				if report.Err != nil {
+					t.Logf("[%s] Frame %d: %v", capture.Name, i, report)
+				}
+			}
+
+			if result.BER > capture.MaxBER {
+				t.Errorf("BER %.2e exceeds %.2e", result.BER, capture.MaxBER)
+			}
+			if result.FrameLoss > capture.MaxFrameLoss {
+				t.Errorf("Frame loss %.2f exceeds %.2f", result.FrameLoss, capture.MaxFrameLoss)
+			}
+		})
+	}
+}
+
+func TestBitErrors(t *testing.T) {
+	if n := BitErrors([]byte{0xff, 0x00}, []byte{0xfe, 0x00}); n != 1 {
+		t.Errorf("Got %d errors, expected 1", n)
+	}
+	if n := BitErrors([]byte{0xff, 0x00, 0x01}, []byte{0xff}); n != 16 {
+		t.Errorf("Got %d errors, expected 16 for the missing bytes", n)
+	}
+}
+
+// regenerates the synthetic captures with go test ./pkg/modem -run TestGenerateSyntheticCorpus -corpus.generate
+func TestGenerateSyntheticCorpus(t *testing.T) {
+	if !*generateCorpus {
+		t.Skip("Run with -corpus.generate to regenerate the synthetic captures")
+	}
+
+	const name = "synthetic_awgn"
+
+	var capture Capture
+	capture.File = name + ".bin"
+	capture.Payload = name + ".payload"
+	capture.Synthetic = true
+	capture.Description = "SYNTHETIC, not a recording: 400 random bytes modulated with the byte modem, " +
+		"attenuated to 30%, preceded by 2000 samples of silence and with white Gaussian noise at 20 dB SNR"
+	capture.Modem.CarrierSize = 3
+	capture.Modem.BytePerFrame = 100
+	capture.Modem.PreambleN = 4
+	capture.Modem.Threshold = 10
+
+	r := rand.New(rand.NewSource(1))
+	payload := make([]byte, 400)
+	r.Read(payload)
+
+	modulator := Modulator{
+		Preamble:      DigitalChripConfig{N: capture.Modem.PreambleN, Amplitude: 0x7fffffff}.New(),
+		CarrierSize:   capture.Modem.CarrierSize,
+		BytePerFrame:  capture.Modem.BytePerFrame,
+		FrameInterval: 256,
+		Amplitude:     0x7fffffff,
+	}
+	signal := append(make([]int32, 2000), modulator.Modulate(payload)...)
+	signal = append(signal, make([]int32, 2000)...)
+
+	sigma := 0.3 * 0x7fffffff / math.Pow(10, 20.0/20)
+	for i := range signal {
+		signal[i] = int32(max(min(0.3*float64(signal[i])+r.NormFloat64()*sigma, math.MaxInt32), math.MinInt32))
+	}
+
+	if err := os.MkdirAll(corpusDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.WriteBinary(filepath.Join(corpusDir, capture.File), signal); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(corpusDir, capture.Payload), payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := json.MarshalIndent(capture, "", "  ")
+	if err := os.WriteFile(filepath.Join(corpusDir, name+".json"), append(manifest, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,141 @@
+package modem
+
+import "math"
+
+// Detection is a preamble found by the Detector
+type Detection struct {
+	Position    int     // the index of the last sample of the preamble among the samples given to the detector
+	Correlation float64 // the normalised cross-correlation at the peak, in [-1, 1]
+	Confidence  float64 // 1 - the largest sidelobe after the peak over the peak, in [0, 1]
+}
+
+// Detector finds the preamble in the streaming samples with the normalised cross-correlation.
+// The correlation does not depend on the level of the signal, so the threshold holds whatever the gain of the recording,
+// and the window and the preamble have their mean removed, so a DC offset does not matter either.
+// Any preamble family can be used, e.g. DigitalChripConfig, ChripConfig, BarkerConfig or ZadoffChuConfig.
+type Detector struct {
+	Preamble  []int32
+	Threshold float64 // the minimum normalised cross-correlation of a detection, in (0, 1]
+	Hold      int     // number of samples without a larger peak to confirm a detection, 0 means the length of the preamble
+
+	template []float64 // the preamble with zero mean and unit norm
+	window   []float64 // the latest samples as a ring buffer
+	head     int       // the index of the oldest sample in the window
+	filled   int
+	sum      float64 // the running sum of the window
+	energy   float64 // the running sum of squares of the window
+	position int     // number of samples given to the detector
+
+	pending  bool
+	peak     Detection
+	sidelobe float64
+	previous [2]float64 // the last two correlations, the latest first
+}
+
+func (d *Detector) init() {
+	n := float64(len(d.Preamble))
+	mean := 0.0
+	for _, v := range d.Preamble {
+		mean += float64(v)
+	}
+	mean /= n
+
+	d.template = make([]float64, len(d.Preamble))
+	norm := 0.0
+	for i, v := range d.Preamble {
+		d.template[i] = float64(v) - mean
+		norm += d.template[i] * d.template[i]
+	}
+	norm = math.Sqrt(norm)
+	for i := range d.template {
+		d.template[i] /= norm
+	}
+
+	if d.Hold == 0 {
+		d.Hold = len(d.Preamble)
+	}
+	d.window = make([]float64, len(d.Preamble))
+}
+
+// forgets the samples and the pending peak, the positions keep counting
+func (d *Detector) Reset() {
+	if d.template == nil {
+		d.init()
+	}
+	clear(d.window)
+	d.head = 0
+	d.filled = 0
+	d.sum = 0
+	d.energy = 0
+	d.pending = false
+	d.sidelobe = 0
+	d.previous = [2]float64{}
+}
+
+// the normalised cross-correlation of the window ending at the latest sample, 0 until the window is filled
+func (d *Detector) correlate() float64 {
+	n := len(d.window)
+	if d.filled < n {
+		return 0
+	}
+	variance := d.energy - d.sum*d.sum/float64(n)
+	if variance <= 1e-18 {
+		return 0
+	}
+	dot := 0.0
+	for i, t := range d.template {
+		dot += d.window[(d.head+i)%n] * t
+	}
+	return dot / math.Sqrt(variance)
+}
+
+// feeds one sample, peak is true if the sample ends the best candidate so far
+// and detection is set when a candidate has not been exceeded for Hold samples
+func (d *Detector) Update(sample int32) (peak bool, detection *Detection) {
+	if d.template == nil {
+		d.Reset()
+	}
+
+	x := float64(sample) / 0x7fffffff
+	n := len(d.window)
+	old := d.window[d.head]
+	d.window[d.head] = x
+	d.head = (d.head + 1) % n
+	d.filled = min(d.filled+1, n)
+	d.position++
+
+	// recompute the running sums once per window so that the rounding errors do not accumulate
+	if d.position%n == 0 {
+		d.sum, d.energy = 0, 0
+		for _, v := range d.window {
+			d.sum += v
+			d.energy += v * v
+		}
+	} else {
+		d.sum += x - old
+		d.energy += x*x - old*old
+	}
+
+	correlation := d.correlate()
+
+	if correlation >= d.Threshold && (!d.pending || correlation > d.peak.Correlation) {
+		d.pending = true
+		d.peak = Detection{Position: d.position - 1, Correlation: correlation}
+		d.sidelobe = 0
+		peak = true
+	} else if d.pending {
+		// a local maximum of the magnitude other than the peak is a sidelobe
+		last := math.Abs(d.previous[0])
+		if d.position-2 != d.peak.Position && last > math.Abs(d.previous[1]) && last >= math.Abs(correlation) {
+			d.sidelobe = max(d.sidelobe, last)
+		}
+		if d.position-1-d.peak.Position >= d.Hold {
+			found := d.peak
+			found.Confidence = max(0, 1-d.sidelobe/found.Correlation)
+			detection = &found
+			d.pending = false
+		}
+	}
+	d.previous = [2]float64{correlation, d.previous[0]}
+	return
+}
@@ -0,0 +1,126 @@
+package modem
+
+import (
+	"Aethernet/pkg/fixed"
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDetector(t *testing.T) {
+
+	families := map[string][]int32{
+		"digital chirp": DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New(),
+		"chirp":         Float64ToInt32(ChripConfig{MinFreq: 1000, MaxFreq: 8000, Length: 96, SampleRate: 48000}.New()),
+		"barker":        BarkerConfig{Length: 13, ChipSize: 4, Amplitude: 0x7fffffff}.New(),
+		"zadoff-chu":    ZadoffChuConfig{Root: 5, Length: 31, ChipSize: 2, Amplitude: 0x7fffffff}.New(),
+	}
+
+	const start = 1000
+	for name, preamble := range families {
+		for _, gain := range []float64{1, 0.01} {
+			t.Run(fmt.Sprintf("%s gain %v", name, gain), func(t *testing.T) {
+				// the preamble in noise at 20 dB SNR with a DC offset
+				r := rand.New(rand.NewSource(1))
+				signal := make([]int32, start+len(preamble)+2000)
+				for i := range signal {
+					x := 0.1*r.NormFloat64() + 0.05
+					if j := i - start; j >= 0 && j < len(preamble) {
+						x += float64(preamble[j]) / 0x7fffffff
+					}
+					signal[i] = int32(max(min(gain*x*0x7fffffff, math.MaxInt32), math.MinInt32))
+				}
+
+				detector := Detector{Preamble: preamble, Threshold: 0.7}
+				var detections []Detection
+				for _, sample := range signal {
+					if _, detection := detector.Update(sample); detection != nil {
+						detections = append(detections, *detection)
+					}
+				}
+
+				if len(detections) != 1 {
+					t.Fatalf("Got %d detections, expected 1: %v", len(detections), detections)
+				}
+				d := detections[0]
+				t.Logf("%+v", d)
+				if d.Position != start+len(preamble)-1 {
+					t.Errorf("Detected at %d, expected %d", d.Position, start+len(preamble)-1)
+				}
+				if d.Correlation < 0.9 || d.Confidence <= 0 || d.Confidence > 1 {
+					t.Errorf("Unexpected detection %+v", d)
+				}
+			})
+		}
+	}
+}
+
+// the same threshold works whatever the level of the recording, unlike the power threshold
+func TestDemodulateAllNormalised(t *testing.T) {
+	preamble := BarkerConfig{Length: 13, ChipSize: 4, Amplitude: 0x7fffffff}.New()
+	modulator := Modulator{
+		Preamble:      preamble,
+		CarrierSize:   3,
+		BytePerFrame:  100,
+		FrameInterval: 256,
+		Amplitude:     0x7fffffff,
+	}
+
+	payload := make([]byte, 250)
+	rand.New(rand.NewSource(1)).Read(payload)
+	signal := append(make([]int32, 1000), modulator.Modulate(payload)...)
+
+	for _, gain := range []float64{1, 0.05} {
+		scaled := make([]int32, len(signal))
+		for i, sample := range signal {
+			scaled[i] = int32(gain * float64(sample))
+		}
+
+		demodulator := Demodulator{
+			Preamble:    preamble,
+			CarrierSize: 3,
+			Detector:    &Detector{Preamble: preamble, Threshold: 0.7},
+		}
+		packets, reports := demodulator.DemodulateAll(scaled)
+		if len(packets) != 1 || !bytes.Equal(packets[0], payload) {
+			t.Errorf("Gain %v: got %d packets, expected the payload", gain, len(packets))
+		}
+		for i, report := range reports {
+			if report.Err != nil || report.Power < 0.9 {
+				t.Errorf("Gain %v: frame %d %v", gain, i, report)
+			}
+		}
+	}
+}
+
+// both detectors hand the samples after the preamble to the data extraction the same way,
+// with two samples per carrier period a frame shifted by one sample does not decode
+func TestDetectorsDecodeTheSameFrame(t *testing.T) {
+	preamble := DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+	modulator := Modulator{Preamble: preamble, CarrierSize: 2, BytePerFrame: 100, FrameInterval: 256}
+
+	payload := make([]byte, 100)
+	rand.New(rand.NewSource(2)).Read(payload)
+	signal := append(make([]int32, 1000), modulator.Modulate(payload)...)
+
+	demodulators := map[string]*Demodulator{
+		"power":      {Preamble: preamble, CarrierSize: 2, DemodulatePowerThreshold: fixed.FromFloat(10)},
+		"normalised": {Preamble: preamble, CarrierSize: 2, Detector: &Detector{Preamble: preamble, Threshold: 0.7}},
+	}
+	positions := map[string]int{}
+	for name, demodulator := range demodulators {
+		packets, reports := demodulator.DemodulateAll(signal)
+		if len(packets) != 1 || !bytes.Equal(packets[0], payload) {
+			t.Errorf("%s: got %d packets, expected the payload", name, len(packets))
+		}
+		if len(reports) != 1 || reports[0].Err != nil {
+			t.Fatalf("%s: unexpected reports %v", name, reports)
+		}
+		positions[name] = reports[0].Position
+	}
+	if positions["power"] != positions["normalised"] {
+		t.Errorf("The preamble ends at %d with the power and at %d normalised", positions["power"], positions["normalised"])
+	}
+}
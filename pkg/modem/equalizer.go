@@ -0,0 +1,150 @@
+package modem
+
+import "math"
+
+const defaultTrainingSize = 256
+
+// Equalizer sends a known training sequence after the preamble, on which the receiver fits a FIR filter
+// inverting the impulse response of the channel, with the least squares solution the RLS would reach at the end of the training.
+// The weights are fitted again on every frame and then applied to the rest of it,
+// so the symbols smeared by the multipath of the room are recovered with shorter carriers.
+// The equalized samples lag the received ones by Delay samples, so the frame interval should be at least Delay.
+type Equalizer struct {
+	Taps     int // length of the filter, about the length of the impulse response of the channel
+	Delay    int // the delay of the filter in samples, 0 means Taps/2
+	Training int // number of samples of the training sequence, 0 means 256
+}
+
+func (e *Equalizer) delay() int {
+	if e.Delay == 0 {
+		return e.Taps / 2
+	}
+	return e.Delay
+}
+
+func (e *Equalizer) trainingSize() int {
+	if e.Training == 0 {
+		return defaultTrainingSize
+	}
+	return e.Training
+}
+
+// the ±1 training sequence, the output of the maximal length LFSR x^9 + x^5 + 1
+func (e *Equalizer) sequence() []float64 {
+	sequence := make([]float64, e.trainingSize())
+	state := uint16(0x1ff)
+	for i := range sequence {
+		bit := (state ^ state>>4) & 1
+		state = state>>1 | bit<<8
+		sequence[i] = 1 - 2*float64(bit)
+	}
+	return sequence
+}
+
+// appends the training sequence to a frame whose preamble ends the signal
+func (e *Equalizer) appendTraining(signal []int32, amplitude int32) []int32 {
+	for _, x := range e.sequence() {
+		signal = append(signal, int32(x*float64(amplitude)))
+	}
+	return signal
+}
+
+// the equalizer of a frame, the equalized samples are normalised to the training sequence of ±1
+type equalizerReceiver struct {
+	*Equalizer
+
+	n        int       // number of samples since the end of the preamble
+	sequence []float64 // the expected training sequence
+	received []float64 // the samples during the training
+	weights  []float64
+	line     []float64 // the latest Taps samples, the latest first
+}
+
+func (r *equalizerReceiver) reset() {
+	r.n = 0
+	r.sequence = r.Equalizer.sequence()
+	r.received = r.received[:0]
+	r.weights = make([]float64, r.Taps)
+	r.line = make([]float64, r.Taps)
+}
+
+// feeds one sample and returns the equalized sample once the training is over
+func (r *equalizerReceiver) update(x float64) (y float64, ok bool) {
+	n := r.n
+	r.n++
+	copy(r.line[1:], r.line)
+	r.line[0] = x
+
+	end := len(r.sequence) + r.delay()
+	if n < end {
+		r.received = append(r.received, x)
+		if n == end-1 {
+			r.train()
+		}
+		return
+	}
+
+	for i, w := range r.weights {
+		y += w * r.line[i]
+	}
+	return y, true
+}
+
+// solves the normal equations R w = p over the samples of the training, the output at the k-th sample is the symbol k-Delay
+func (r *equalizerReceiver) train() {
+	taps := r.Taps
+	// the augmented matrix [R p]
+	a := make([][]float64, taps)
+	for i := range a {
+		a[i] = make([]float64, taps+1)
+	}
+	window := make([]float64, taps)
+	for k := r.delay(); k < len(r.received); k++ {
+		for i := range window {
+			window[i] = 0
+			if k-i >= 0 {
+				window[i] = r.received[k-i]
+			}
+		}
+		d := r.sequence[k-r.delay()]
+		for i := range taps {
+			for j := range taps {
+				a[i][j] += window[i] * window[j]
+			}
+			a[i][taps] += d * window[i]
+		}
+	}
+
+	// a little regularisation keeps the silence and the ill-conditioned channels solvable
+	trace := 0.0
+	for i := range taps {
+		trace += a[i][i]
+	}
+	for i := range taps {
+		a[i][i] += 1e-6*trace/float64(taps) + 1e-12
+	}
+
+	// the Gaussian elimination with partial pivoting
+	for col := range taps {
+		pivot := col
+		for row := col + 1; row < taps; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for row := col + 1; row < taps; row++ {
+			f := a[row][col] / a[col][col]
+			for j := col; j <= taps; j++ {
+				a[row][j] -= f * a[col][j]
+			}
+		}
+	}
+	for i := taps - 1; i >= 0; i-- {
+		sum := a[i][taps]
+		for j := i + 1; j < taps; j++ {
+			sum -= a[i][j] * r.weights[j]
+		}
+		r.weights[i] = sum / a[i][i]
+	}
+}
@@ -0,0 +1,73 @@
+package modem
+
+import (
+	"Aethernet/pkg/fixed"
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// a reverberant channel smears the short symbols, which only the equalized receiver recovers
+func TestEqualizer(t *testing.T) {
+	const CARRIER_SIZE = 1
+
+	preamble := DigitalChripConfig{N: 4, Amplitude: 0x7fffffff / 2}.New()
+	echos := map[int]float64{1: 0.6, 3: -0.5, 6: 0.3}
+
+	r := rand.New(rand.NewSource(1))
+	payload := make([]byte, 250)
+	r.Read(payload)
+
+	for _, equalizer := range []*Equalizer{nil, {Taps: 32}} {
+		modulator := Modulator{
+			Preamble:      preamble,
+			CarrierSize:   CARRIER_SIZE,
+			BytePerFrame:  100,
+			FrameInterval: 256,
+			Amplitude:     0x7fffffff / 4,
+			Equalizer:     equalizer,
+		}
+		sent := append(make([]int32, 1000), modulator.Modulate(payload)...)
+		signal := make([]int32, len(sent))
+		for i := range sent {
+			y := float64(sent[i])
+			for delay, gain := range echos {
+				if i >= delay {
+					y += gain * float64(sent[i-delay])
+				}
+			}
+			signal[i] = int32(y + 0.002*0x7fffffff*r.NormFloat64())
+		}
+
+		demodulator := Demodulator{
+			Preamble:                 preamble,
+			CarrierSize:              CARRIER_SIZE,
+			DemodulatePowerThreshold: fixed.FromFloat(5),
+			Equalizer:                equalizer,
+		}
+		packets, reports := demodulator.DemodulateAll(signal)
+		received := len(packets) == 1 && bytes.Equal(packets[0], payload)
+		if equalizer == nil && received {
+			t.Errorf("Received without the equalizer, the channel is too mild for the test")
+		}
+		if equalizer != nil && !received {
+			for _, report := range reports {
+				t.Log(report)
+			}
+			t.Errorf("Got %d packets with the equalizer, expected the payload", len(packets))
+		}
+	}
+}
+
+func TestEqualizerSequence(t *testing.T) {
+	sequence := (&Equalizer{Training: 511}).sequence()
+	// the maximal length sequence is balanced and nearly uncorrelated with its shifts
+	sum, shifted := 0.0, 0.0
+	for i, x := range sequence {
+		sum += x
+		shifted += x * sequence[(i+7)%len(sequence)]
+	}
+	if sum != 1 && sum != -1 || shifted != -1 {
+		t.Errorf("Not a maximal length sequence: sum %v, correlation with a shift %v", sum, shifted)
+	}
+}
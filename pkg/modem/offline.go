@@ -0,0 +1,70 @@
+package modem
+
+import "fmt"
+
+type FrameHeader struct {
+	Size   int // number of payload bytes in the frame
+	Index  int // index of the frame in the packet
+	IsLast bool
+}
+
+// FrameReport describes how a frame was demodulated
+type FrameReport struct {
+	Position   int     // the index of the sample where the correlation with the preamble peaks, i.e. the end of the preamble
+	Power      float64 // the peak correlation with the preamble, normalised to [-1, 1] with a Detector
+	Confidence float64 // the confidence of the Detector, 0 with the power threshold
+	Header     FrameHeader
+	HeaderOK   bool // whether a valid header was received
+	CRCOK      bool
+	Data       []byte // the data of the frame once its CRC is checked, whether it is valid or not
+	Err        error  // why the frame was dropped, nil if it was received
+}
+
+func (r FrameReport) String() string {
+	status := "ok"
+	if r.Err != nil {
+		status = r.Err.Error()
+	}
+	confidence := ""
+	if r.Confidence != 0 {
+		confidence = fmt.Sprintf(" confidence %.2f", r.Confidence)
+	}
+	return fmt.Sprintf("position %d power %.2f%s size %d index %d last %v crc %v: %s",
+		r.Position, r.Power, confidence, r.Header.Size, r.Header.Index, r.Header.IsLast, r.CRCOK, status)
+}
+
+// demodulates a whole recorded signal and returns the packets and the report of every frame found
+func (d *Demodulator) DemodulateAll(signal []int32) (packets [][]byte, reports []FrameReport) {
+	trace := d.Trace
+	d.Trace = func(report FrameReport) {
+		reports = append(reports, report)
+		if trace != nil {
+			trace(report)
+		}
+	}
+	defer func() { d.Trace = trace }()
+
+	if d.outputChan == nil {
+		d.Init()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.Demodulate(signal)
+		close(done)
+	}()
+
+	for running := true; running; {
+		select {
+		case packet := <-d.ReceiveAsync():
+			packets = append(packets, packet)
+		case <-done:
+			running = false
+		}
+	}
+	// the packets completed by the last samples
+	for len(d.ReceiveAsync()) > 0 {
+		packets = append(packets, <-d.ReceiveAsync())
+	}
+	return
+}
@@ -0,0 +1,95 @@
+package modem
+
+import (
+	"Aethernet/pkg/fixed"
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestDemodulateAll(t *testing.T) {
+
+	const (
+		BYTE_PER_FRAME = 100
+		FRAME_INTERVAL = 256
+		CARRIER_SIZE   = 3
+
+		POWER_THRESHOLD = 30
+	)
+
+	var preamble = DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+
+	modulator := Modulator{
+		Preamble:      preamble,
+		CarrierSize:   CARRIER_SIZE,
+		BytePerFrame:  BYTE_PER_FRAME,
+		FrameInterval: FRAME_INTERVAL,
+	}
+
+	first := make([]byte, 250)
+	second := make([]byte, 50)
+	rand.Read(first)
+	rand.Read(second)
+
+	silence := make([]int32, 1000)
+	firstSignal := modulator.Modulate(first)
+	secondSignal := modulator.Modulate(second)
+	signal := append(append(append(append([]int32{}, silence...), firstSignal...), silence...), secondSignal...)
+
+	demodulator := Demodulator{
+		Preamble:                 preamble,
+		CarrierSize:              CARRIER_SIZE,
+		DemodulatePowerThreshold: fixed.FromFloat(POWER_THRESHOLD),
+	}
+	packets, reports := demodulator.DemodulateAll(signal)
+
+	if len(packets) != 2 || !bytes.Equal(packets[0], first) || !bytes.Equal(packets[1], second) {
+		t.Fatalf("Got %d packets, expected the 2 sent", len(packets))
+	}
+	if len(reports) != 4 {
+		t.Fatalf("Got %d reports, expected one per frame", len(reports))
+	}
+
+	expected := []FrameHeader{{100, 0, false}, {100, 1, false}, {50, 2, true}, {50, 0, true}}
+	for i, report := range reports {
+		if report.Err != nil || !report.CRCOK || !report.HeaderOK {
+			t.Errorf("Frame %d failed: %v", i, report)
+		}
+		if report.Header != expected[i] {
+			t.Errorf("Frame %d header %+v, expected %+v", i, report.Header, expected[i])
+		}
+	}
+	// the first preamble ends after the silence
+	if end := len(silence) + len(preamble) - 1; reports[0].Position < end-CARRIER_SIZE || reports[0].Position > end+CARRIER_SIZE {
+		t.Errorf("First preamble at %d, expected around %d", reports[0].Position, end)
+	}
+	if reports[3].Position <= len(silence)*2+len(firstSignal) {
+		t.Errorf("Last preamble at %d, expected after the first packet", reports[3].Position)
+	}
+
+	// corrupt one bit of the data of the first frame
+	corrupted := append([]int32{}, signal...)
+	start := len(silence) + len(preamble) + 10*HEADER_SIZE*max(CARRIER_SIZE, 2)
+	for i := start; i < start+CARRIER_SIZE; i++ {
+		corrupted[i] = -corrupted[i]
+	}
+	demodulator = Demodulator{
+		Preamble:                 preamble,
+		CarrierSize:              CARRIER_SIZE,
+		DemodulatePowerThreshold: fixed.FromFloat(POWER_THRESHOLD),
+	}
+	_, corruptedReports := demodulator.DemodulateAll(corrupted)
+	if len(corruptedReports) == 0 || corruptedReports[0].Err == nil || corruptedReports[0].CRCOK {
+		t.Fatalf("Corrupted frame reported as %v", corruptedReports)
+	}
+	// the following frames are still found at the same positions
+	for _, report := range reports[1:] {
+		found := false
+		for _, r := range corruptedReports {
+			found = found || r.Position == report.Position
+		}
+		if !found {
+			t.Errorf("Frame at %d not found after the corrupted frame", report.Position)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package modem
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+)
+
+type PassbandScheme int
+
+const (
+	BPSK PassbandScheme = iota
+	QPSK                // two Gray coded bits per symbol
+	FSK                 // a 0 on Freq and a 1 on Freq + Shift
+)
+
+func ParsePassbandScheme(s string) (PassbandScheme, error) {
+	switch strings.ToLower(s) {
+	case "bpsk":
+		return BPSK, nil
+	case "qpsk":
+		return QPSK, nil
+	case "fsk":
+		return FSK, nil
+	default:
+		return 0, fmt.Errorf("unknown passband scheme %s, expected 'bpsk', 'qpsk' or 'fsk'", s)
+	}
+}
+
+func (s PassbandScheme) String() string {
+	switch s {
+	case BPSK:
+		return "bpsk"
+	case QPSK:
+		return "qpsk"
+	case FSK:
+		return "fsk"
+	default:
+		return fmt.Sprintf("PassbandScheme(%d)", int(s))
+	}
+}
+
+const (
+	defaultPilotSize  = 64
+	phaseTrackingGain = 0.1
+)
+
+// Passband sends the bits on a sinusoidal carrier instead of the baseband levels.
+// The carrier phase starts from 0 at the end of every preamble, or of the training sequence of the Equalizer,
+// so the receiver knows the reference from the detected preamble.
+// The PSK frames begin with an unmodulated pilot to measure the phase shift of the channel, which is then tracked on the decisions,
+// while FSK compares the energy of the two tones and needs no phase.
+// The carrier size of the Modulator and the Demodulator is the number of samples per symbol.
+type Passband struct {
+	Scheme     PassbandScheme
+	Freq       float64 // the carrier frequency in Hz
+	Shift      float64 // the distance to the tone of a 1 in Hz, FSK only
+	SampleRate float64
+	PilotSize  int // number of samples of the pilot of PSK, 0 means 64
+}
+
+var (
+	bpskConstellation = PAM(2)
+	qpskConstellation = QAM(4)
+)
+
+func (p *Passband) BitPerSymbol() int {
+	return p.constellation().BitPerSymbol()
+}
+
+// the symbols of the scheme, FSK sends the tone of a 1 for the negative symbol
+func (p *Passband) constellation() *Constellation {
+	if p.Scheme == QPSK {
+		return qpskConstellation
+	}
+	return bpskConstellation
+}
+
+func (p *Passband) pilotSize() int {
+	if p.Scheme == FSK {
+		return 0
+	}
+	if p.PilotSize == 0 {
+		return defaultPilotSize
+	}
+	return p.PilotSize
+}
+
+// the phase of the tone at the n-th sample after the preamble
+func (p *Passband) phase(freq float64, n int) float64 {
+	return 2 * math.Pi * freq * float64(n) / p.SampleRate
+}
+
+// appends the pilot to a frame whose preamble ends the signal
+func (p *Passband) appendPilot(signal []int32, amplitude int32) []int32 {
+	for n := range p.pilotSize() {
+		signal = append(signal, int32(float64(amplitude)*math.Cos(p.phase(p.Freq, n))))
+	}
+	return signal
+}
+
+// appends a symbol of the constellation, n is the number of samples since the end of the preamble
+func (p *Passband) appendSymbol(signal []int32, n int, symbol complex128, size int, amplitude int32) []int32 {
+	a := float64(amplitude)
+	for i := range size {
+		var v float64
+		if p.Scheme == FSK {
+			freq := p.Freq
+			if real(symbol) < 0 {
+				freq += p.Shift
+			}
+			v = math.Cos(p.phase(freq, n+i))
+		} else {
+			// Re{symbol * e^(jwn)}, the symbols of modulus at most 1 keep the peak at the amplitude
+			v = real(symbol * cmplx.Rect(1, p.phase(p.Freq, n+i)))
+		}
+		signal = append(signal, int32(a*v))
+	}
+	return signal
+}
+
+// the coherent receiver of a frame
+type passbandReceiver struct {
+	*Passband
+
+	n        int        // number of samples since the end of the preamble or the training
+	pilot    complex128 // the correlation with the carrier during the pilot
+	rotation complex128 // the estimated phase shift of the channel, of modulus 1
+	sum      [2]complex128
+	ticks    int
+}
+
+func (r *passbandReceiver) reset() {
+	r.n = 0
+	r.pilot = 0
+	r.rotation = 1
+	r.sum = [2]complex128{}
+	r.ticks = 0
+}
+
+// feeds one sample and returns the received symbol once size samples are complete,
+// which is size/2 times the sent symbol and the attenuation of the channel.
+// FSK returns the difference of the energy of the two tones on the real axis, positive for a 0 like the other schemes.
+func (r *passbandReceiver) update(x float64, size int) (z complex128, ok bool) {
+	n := r.n
+	r.n++
+
+	if n < r.pilotSize() {
+		r.pilot += complex(x, 0) * cmplx.Rect(1, -r.phase(r.Freq, n))
+		if n == r.pilotSize()-1 && r.pilot != 0 {
+			r.rotation = r.pilot / complex(cmplx.Abs(r.pilot), 0)
+		}
+		return
+	}
+
+	r.sum[0] += complex(x, 0) * cmplx.Rect(1, -r.phase(r.Freq, n))
+	if r.Scheme == FSK {
+		r.sum[1] += complex(x, 0) * cmplx.Rect(1, -r.phase(r.Freq+r.Shift, n))
+	}
+	r.ticks++
+	if r.ticks < size {
+		return
+	}
+
+	if r.Scheme == FSK {
+		z = complex(cmplx.Abs(r.sum[0])-cmplx.Abs(r.sum[1]), 0)
+	} else {
+		z = r.sum[0] * cmplx.Conj(r.rotation)
+	}
+	r.sum = [2]complex128{}
+	r.ticks = 0
+	return z, true
+}
+
+// follows the drift of the phase with the decided symbol of the received one
+func (r *passbandReceiver) track(z, decision complex128) {
+	if r.Scheme == FSK || decision == 0 || z == 0 {
+		return
+	}
+	r.rotation *= cmplx.Rect(1, phaseTrackingGain*cmplx.Phase(z*cmplx.Conj(decision)))
+}
@@ -0,0 +1,137 @@
+package modem
+
+import (
+	"Aethernet/pkg/fixed"
+	"bytes"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestPassband(t *testing.T) {
+
+	const (
+		SAMPLE_RATE  = 48000
+		CARRIER_SIZE = 12 // one period of the 4 kHz carrier per symbol
+	)
+
+	preamble := DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+	schemes := map[string]Passband{
+		"bpsk": {Scheme: BPSK, Freq: 4000, SampleRate: SAMPLE_RATE},
+		"qpsk": {Scheme: QPSK, Freq: 4000, SampleRate: SAMPLE_RATE},
+		"fsk":  {Scheme: FSK, Freq: 4000, Shift: 4000, SampleRate: SAMPLE_RATE},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	payload := make([]byte, 300)
+	r.Read(payload)
+
+	for name, passband := range schemes {
+		t.Run(name, func(t *testing.T) {
+			modulator := Modulator{
+				Preamble:      preamble,
+				CarrierSize:   CARRIER_SIZE,
+				BytePerFrame:  100,
+				FrameInterval: 256,
+				Amplitude:     0x7fffffff,
+				Passband:      &passband,
+			}
+			signal := append(make([]int32, 1000), modulator.Modulate(payload)...)
+
+			// a channel that attenuates, shifts the phase of the carrier with a two-tap filter and adds noise
+			previous := 0.0
+			for i, sample := range signal {
+				x := float64(sample) / 0x7fffffff
+				y := 0.3*x + 0.2*previous + 0.01*r.NormFloat64()
+				previous = x
+				signal[i] = int32(y * 0x7fffffff)
+			}
+
+			demodulator := Demodulator{
+				Preamble:                 preamble,
+				CarrierSize:              CARRIER_SIZE,
+				DemodulatePowerThreshold: fixed.FromFloat(5),
+				Passband:                 &passband,
+			}
+			packets, reports := demodulator.DemodulateAll(signal)
+			if len(packets) != 1 || !bytes.Equal(packets[0], payload) {
+				for _, report := range reports {
+					t.Log(report)
+				}
+				t.Fatalf("Got %d packets, expected the payload", len(packets))
+			}
+		})
+	}
+}
+
+// the passband signal keeps much less power at the low frequencies than the baseband levels
+func TestPassbandSpectrum(t *testing.T) {
+	payload := make([]byte, 100)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	// the power of the moving average over a carrier period, i.e. below 6 kHz, over the total power
+	lowPowerRatio := func(passband *Passband) float64 {
+		modulator := Modulator{CarrierSize: 8, BytePerFrame: 100, Amplitude: 0x7fffffff, Passband: passband}
+		signal := Int32ToFloat64(modulator.Modulate(payload))
+		var low, total float64
+		for i := 8; i < len(signal); i++ {
+			mean := 0.0
+			for _, x := range signal[i-8 : i] {
+				mean += x / 8
+			}
+			low += mean * mean
+			total += signal[i] * signal[i]
+		}
+		return low / total
+	}
+
+	baseband := lowPowerRatio(nil)
+	passband := lowPowerRatio(&Passband{Scheme: BPSK, Freq: 6000, SampleRate: 48000})
+	t.Logf("Power below the carrier: %.1f%% in baseband, %.1f%% in passband", baseband*100, passband*100)
+	if passband > baseband/4 {
+		t.Errorf("The passband signal is not above the baseband")
+	}
+}
+
+// the pilot recovers any phase shift of the carrier
+func TestPassbandPhase(t *testing.T) {
+	const size = 12
+
+	r := rand.New(rand.NewSource(1))
+	for _, shift := range []float64{0, 100, 200, 300} {
+		for _, scheme := range []PassbandScheme{BPSK, QPSK} {
+			passband := Passband{Scheme: scheme, Freq: 4000, SampleRate: 48000}
+			receiver := passbandReceiver{Passband: &passband}
+			receiver.reset()
+
+			phi := shift * math.Pi / 180
+			carrier := func(n int) complex128 { return cmplx.Rect(1, passband.phase(passband.Freq, n)+phi) }
+			for n := range passband.pilotSize() {
+				receiver.update(real(carrier(n)), size)
+			}
+
+			n := passband.pilotSize()
+			constellation := passband.constellation()
+			for range 100 {
+				sent := make([]bool, constellation.BitPerSymbol())
+				for k := range sent {
+					sent[k] = r.Intn(2) == 1
+				}
+				symbol := constellation.Map(sent)
+				var z complex128
+				var ok bool
+				for range size {
+					z, ok = receiver.update(real(symbol*carrier(n)), size)
+					n++
+				}
+				received := constellation.Demap(z / (size / 2))
+				receiver.track(z, constellation.Map(received))
+				if !ok || !slices.Equal(received, sent) {
+					t.Fatalf("%v shifted by %v degrees: sent %v, got %v", scheme, shift, sent, received)
+				}
+			}
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package modem
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
 
 func chirp(out *[]float64, startFreq, endFreq float64, length int, sampleRate float64) {
 	c := (endFreq - startFreq) / (float64(length) / sampleRate)
@@ -55,3 +58,54 @@ func (p DigitalChripConfig) New() []int32 {
 
 	return preamble
 }
+
+// the Barker codes by length, their aperiodic autocorrelation sidelobes are at most 1
+var barkerCodes = map[int][]int8{
+	2:  {1, -1},
+	3:  {1, 1, -1},
+	4:  {1, 1, -1, 1},
+	5:  {1, 1, 1, -1, 1},
+	7:  {1, 1, 1, -1, -1, 1, -1},
+	11: {1, 1, 1, -1, -1, -1, 1, -1, -1, 1, -1},
+	13: {1, 1, 1, 1, 1, -1, -1, 1, 1, -1, 1, -1, 1},
+}
+
+type BarkerConfig struct {
+	Length    int // one of 2, 3, 4, 5, 7, 11 and 13
+	ChipSize  int // number of samples per chip
+	Amplitude int32
+}
+
+func (p BarkerConfig) New() []int32 {
+	code, ok := barkerCodes[p.Length]
+	if !ok {
+		panic(fmt.Sprintf("No Barker code of length %d", p.Length))
+	}
+	preamble := make([]int32, 0, len(code)*p.ChipSize)
+	for _, chip := range code {
+		for range p.ChipSize {
+			preamble = append(preamble, int32(chip)*p.Amplitude)
+		}
+	}
+	return preamble
+}
+
+// ZadoffChuConfig is the real part of a Zadoff-Chu sequence, the root must be coprime with the length
+type ZadoffChuConfig struct {
+	Root      int
+	Length    int // an odd prime gives the best autocorrelation
+	ChipSize  int // number of samples per element
+	Amplitude int32
+}
+
+func (p ZadoffChuConfig) New() []int32 {
+	preamble := make([]int32, 0, p.Length*p.ChipSize)
+	for n := range p.Length {
+		phase := math.Pi * float64(p.Root) * float64(n) * float64(n+p.Length%2) / float64(p.Length)
+		v := int32(math.Cos(phase) * float64(p.Amplitude))
+		for range p.ChipSize {
+			preamble = append(preamble, v)
+		}
+	}
+	return preamble
+}
@@ -0,0 +1,41 @@
+package router
+
+import (
+	"Aethernet/pkg/layers"
+	"net/netip"
+)
+
+var limitedBroadcast = netip.AddrFrom4([4]byte{255, 255, 255, 255})
+
+// LinkGroup maps an IPv4 destination to the group of a data link broadcast, false for a unicast destination.
+// The limited broadcast and the directed broadcast of the subnet, which may be zero, go to every node, and a multicast
+// group goes to the link group of its last byte, which several IP groups share like the multicast MAC addresses of Ethernet.
+func LinkGroup(dst netip.Addr, subnet netip.Prefix) (layers.Group, bool) {
+	if !dst.Is4() {
+		return 0, false
+	}
+	if dst == limitedBroadcast || isDirectedBroadcast(dst, subnet) {
+		return layers.GroupAll, true
+	}
+	if dst.IsMulticast() {
+		return layers.Group(dst.As4()[3]), true
+	}
+	return 0, false
+}
+
+func isDirectedBroadcast(dst netip.Addr, subnet netip.Prefix) bool {
+	if !subnet.IsValid() || !subnet.Addr().Is4() || subnet.Bits() >= 31 || !subnet.Contains(dst) {
+		return false
+	}
+	host := dst.As4()
+	mask := ^uint32(0) >> subnet.Bits()
+	return (uint32(host[0])<<24|uint32(host[1])<<16|uint32(host[2])<<8|uint32(host[3]))&mask == mask
+}
+
+// the destination of an IPv4 packet, invalid if the packet is too short
+func destination(packet []byte) netip.Addr {
+	if len(packet) < 20 {
+		return netip.Addr{}
+	}
+	return netip.AddrFrom4([4]byte(packet[16:20]))
+}
@@ -0,0 +1,84 @@
+package router
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const icmpTTL = 64
+
+// builds an ICMP error message in response to the original IPv4 packet
+func makeICMPError(src netip.Addr, original []byte, typ, code uint8) ([]byte, error) {
+	ihl := int(original[0]&0x0f) * 4
+	quoted := original[:min(len(original), ihl+8)]
+
+	ipv4 := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      icmpTTL,
+		Protocol: layers.IPProtocolICMPv4,
+		SrcIP:    src.AsSlice(),
+		DstIP:    original[12:16],
+	}
+	icmp := &layers.ICMPv4{
+		TypeCode: layers.CreateICMPv4TypeCode(typ, code),
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(
+		buffer,
+		gopacket.SerializeOptions{
+			FixLengths:       true,
+			ComputeChecksums: true,
+		},
+		ipv4,
+		icmp,
+		gopacket.Payload(quoted),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// ICMP errors must not be sent in response to other ICMP errors
+func isICMPError(packet []byte) bool {
+	ihl := int(packet[0]&0x0f) * 4
+	if packet[9] != uint8(layers.IPProtocolICMPv4) || len(packet) <= ihl {
+		return false
+	}
+	switch packet[ihl] {
+	case layers.ICMPv4TypeDestinationUnreachable,
+		layers.ICMPv4TypeSourceQuench,
+		layers.ICMPv4TypeRedirect,
+		layers.ICMPv4TypeTimeExceeded,
+		layers.ICMPv4TypeParameterProblem:
+		return true
+	}
+	return false
+}
+
+// decrements the TTL of the IPv4 packet in place and updates the header checksum
+func decrementTTL(packet []byte) {
+	ihl := int(packet[0]&0x0f) * 4
+	packet[8]--
+	packet[10], packet[11] = 0, 0
+	binary.BigEndian.PutUint16(packet[10:12], checksum(packet[:ihl]))
+}
+
+func checksum(data []byte) uint16 {
+	sum := uint32(0)
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
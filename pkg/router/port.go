@@ -0,0 +1,58 @@
+package router
+
+import (
+	"Aethernet/pkg/layers"
+	"fmt"
+	"net/netip"
+)
+
+// Port is an interface of the router, either an Aethernet segment or a host interface
+type Port interface {
+	// sends an IP packet to the next hop
+	Send(nextHop layers.ReliableDataLinkAddress, packet []byte) error
+	// the IPv4 address of the router on this port, used as the source of ICMP messages
+	Addr() netip.Addr
+}
+
+// LinkPort attaches the router to an Aethernet segment
+type LinkPort struct {
+	Layer   *layers.ReliableDataLinkLayer
+	Address netip.Addr
+	Subnet  netip.Prefix // the prefix of the segment for its directed broadcast, may be zero
+}
+
+// sends the broadcast and multicast packets to their link group without ACK instead of the next hop,
+// and fails at once for a next hop which the neighbor discovery of the layer does not hear
+func (p *LinkPort) Send(nextHop layers.ReliableDataLinkAddress, packet []byte) error {
+	if group, ok := LinkGroup(destination(packet), p.Subnet); ok {
+		return p.Layer.Multicast(group, packet)
+	}
+	if p.Layer.Neighbors != nil && !p.Layer.Neighbors.IsReachable(nextHop) {
+		return fmt.Errorf("next hop %x is not a neighbor", nextHop)
+	}
+	return p.Layer.Send(nextHop, packet)
+}
+
+func (p *LinkPort) Addr() netip.Addr {
+	return p.Address
+}
+
+func (p *LinkPort) Packets() <-chan []byte {
+	return p.Layer.ReceiveAsync()
+}
+
+// HostPort attaches the router to a host interface such as a TUN device
+type HostPort struct {
+	Writer interface {
+		Write(data []byte) error
+	}
+	Address netip.Addr
+}
+
+func (p *HostPort) Send(_ layers.ReliableDataLinkAddress, packet []byte) error {
+	return p.Writer.Write(packet)
+}
+
+func (p *HostPort) Addr() netip.Addr {
+	return p.Address
+}
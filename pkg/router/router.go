@@ -0,0 +1,132 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	ErrNotIPv4     = errors.New("not an IPv4 packet")
+	ErrTTLExceeded = errors.New("TTL exceeded")
+	ErrNoRoute     = errors.New("no route to destination")
+	ErrUnknownPort = errors.New("unknown port")
+)
+
+// Router forwards IPv4 packets between its ports according to the routing table
+type Router struct {
+	Table Table
+	Ports map[string]Port
+
+	Deliver func(packet []byte) // called with the packets addressed to the router itself, may be nil
+}
+
+// forwards the packets from the channel until it is closed
+func (r *Router) Listen(port string, packets <-chan []byte) {
+	go func() {
+		for packet := range packets {
+			err := r.Forward(port, packet)
+			if err != nil {
+				fmt.Printf("[Router] Packet from %s dropped: %v\n", port, err)
+			}
+		}
+	}()
+}
+
+// forwards a packet received from the port named in
+func (r *Router) Forward(in string, packet []byte) error {
+
+	if len(packet) < 20 || packet[0]>>4 != 4 || len(packet) < int(packet[0]&0x0f)*4 {
+		return ErrNotIPv4
+	}
+
+	dst := destination(packet)
+
+	// the broadcasts and, without multicast routing, the multicasts stay on their segment
+	if r.isLocal(dst) || dst == limitedBroadcast || dst.IsMulticast() {
+		if r.Deliver != nil {
+			r.Deliver(packet)
+		}
+		return nil
+	}
+
+	if packet[8] <= 1 {
+		r.sendICMPError(in, packet, layers.ICMPv4TypeTimeExceeded, layers.ICMPv4CodeTTLExceeded)
+		return ErrTTLExceeded
+	}
+
+	route, ok := r.Table.Lookup(dst)
+	if !ok {
+		r.sendICMPError(in, packet, layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodeNet)
+		return fmt.Errorf("%w %v", ErrNoRoute, dst)
+	}
+
+	port, ok := r.Ports[route.Port]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownPort, route.Port)
+	}
+
+	out := make([]byte, len(packet))
+	copy(out, packet)
+	decrementTTL(out)
+
+	err := port.Send(route.NextHop, out)
+	if err != nil {
+		r.sendICMPError(in, packet, layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodeHost)
+		return fmt.Errorf("failed to forward to %v: %w", route, err)
+	}
+	return nil
+}
+
+func (r *Router) isLocal(addr netip.Addr) bool {
+	for _, port := range r.Ports {
+		if port.Addr() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// sends an ICMP error back to the source of the packet
+func (r *Router) sendICMPError(in string, packet []byte, typ, code uint8) {
+
+	if isICMPError(packet) {
+		return
+	}
+
+	src := netip.AddrFrom4([4]byte(packet[12:16]))
+	if !src.IsValid() || src.IsUnspecified() || src.IsMulticast() || src == limitedBroadcast {
+		return
+	}
+
+	route, ok := r.Table.Lookup(src)
+	if !ok {
+		fmt.Printf("[Router] No route back to %v for ICMP error\n", src)
+		return
+	}
+	port, ok := r.Ports[route.Port]
+	if !ok {
+		return
+	}
+
+	// the error is sent from the address of the receiving port if known
+	from := port.Addr()
+	if inPort, ok := r.Ports[in]; ok {
+		from = inPort.Addr()
+	}
+
+	message, err := makeICMPError(from, packet, typ, code)
+	if err != nil {
+		fmt.Printf("[Router] Failed to make ICMP error: %v\n", err)
+		return
+	}
+
+	go func() {
+		err := port.Send(route.NextHop, message)
+		if err != nil {
+			fmt.Printf("[Router] Failed to send ICMP error to %v: %v\n", src, err)
+		}
+	}()
+}
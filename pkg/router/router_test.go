@@ -0,0 +1,256 @@
+package router
+
+import (
+	"Aethernet/pkg/device"
+	"Aethernet/pkg/fixed"
+	aelayers "Aethernet/pkg/layers"
+	"Aethernet/pkg/modem"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type fakePort struct {
+	address netip.Addr
+	sent    chan []byte
+}
+
+func (p *fakePort) Send(_ aelayers.ReliableDataLinkAddress, packet []byte) error {
+	p.sent <- packet
+	return nil
+}
+
+func (p *fakePort) Addr() netip.Addr {
+	return p.address
+}
+
+func makePacket(t *testing.T, src, dst string, ttl uint8) []byte {
+	buffer := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(
+		buffer,
+		gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		&layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			TTL:      ttl,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    netip.MustParseAddr(src).AsSlice(),
+			DstIP:    netip.MustParseAddr(dst).AsSlice(),
+		},
+		gopacket.Payload("hello"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func receive(t *testing.T, c <-chan []byte) []byte {
+	select {
+	case packet := <-c:
+		return packet
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for packet")
+		return nil
+	}
+}
+
+func TestRouterForward(t *testing.T) {
+
+	ports := map[string]*fakePort{
+		"lan": {address: netip.MustParseAddr("10.0.1.1"), sent: make(chan []byte, 1)},
+		"wan": {address: netip.MustParseAddr("10.0.2.1"), sent: make(chan []byte, 1)},
+	}
+
+	r := Router{Ports: map[string]Port{"lan": ports["lan"], "wan": ports["wan"]}}
+	r.Table.Add(Route{Prefix: netip.MustParsePrefix("10.0.1.0/24"), Port: "lan", NextHop: 1})
+	r.Table.Add(Route{Prefix: netip.MustParsePrefix("10.0.2.0/24"), Port: "wan", NextHop: 2})
+
+	// normal forwarding
+	if err := r.Forward("lan", makePacket(t, "10.0.1.2", "10.0.2.2", 64)); err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	packet := gopacket.NewPacket(receive(t, ports["wan"].sent), layers.LayerTypeIPv4, gopacket.Default)
+	ipv4 := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if ipv4.TTL != 63 {
+		t.Errorf("Expected TTL 63, got %d", ipv4.TTL)
+	}
+	if checksum(packet.Data()[:20]) != 0 {
+		t.Errorf("Invalid header checksum after forwarding")
+	}
+
+	// TTL exceeded
+	if err := r.Forward("lan", makePacket(t, "10.0.1.2", "10.0.2.2", 1)); err != ErrTTLExceeded {
+		t.Errorf("Expected ErrTTLExceeded, got %v", err)
+	}
+	packet = gopacket.NewPacket(receive(t, ports["lan"].sent), layers.LayerTypeIPv4, gopacket.Default)
+	icmp, ok := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+	if !ok || icmp.TypeCode.Type() != layers.ICMPv4TypeTimeExceeded {
+		t.Errorf("Expected ICMP time exceeded, got %v", packet)
+	}
+	if src := packet.NetworkLayer().NetworkFlow().Src().String(); src != "10.0.1.1" {
+		t.Errorf("Expected ICMP from 10.0.1.1, got %s", src)
+	}
+
+	// no route
+	if err := r.Forward("lan", makePacket(t, "10.0.1.2", "192.168.0.1", 64)); err == nil {
+		t.Errorf("Expected an error for unroutable packet")
+	}
+	packet = gopacket.NewPacket(receive(t, ports["lan"].sent), layers.LayerTypeIPv4, gopacket.Default)
+	icmp, ok = packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+	if !ok || icmp.TypeCode != layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodeNet) {
+		t.Errorf("Expected ICMP net unreachable, got %v", packet)
+	}
+
+	// local delivery
+	delivered := make(chan []byte, 1)
+	r.Deliver = func(packet []byte) { delivered <- packet }
+	r.Forward("lan", makePacket(t, "10.0.1.2", "10.0.2.1", 64))
+	receive(t, delivered)
+
+	// the broadcasts and multicasts are not forwarded
+	r.Table.Add(Route{Prefix: netip.MustParsePrefix("0.0.0.0/0"), Port: "wan", NextHop: 2})
+	for _, dst := range []string{"255.255.255.255", "224.0.0.251"} {
+		r.Forward("lan", makePacket(t, "10.0.1.2", dst, 64))
+		receive(t, delivered)
+		if len(ports["wan"].sent) > 0 {
+			t.Errorf("Packet to %s forwarded", dst)
+			<-ports["wan"].sent
+		}
+	}
+}
+
+func TestLinkGroup(t *testing.T) {
+	subnet := netip.MustParsePrefix("10.0.1.0/24")
+	for _, c := range []struct {
+		dst       string
+		group     aelayers.Group
+		broadcast bool
+	}{
+		{"255.255.255.255", aelayers.GroupAll, true},
+		{"10.0.1.255", aelayers.GroupAll, true},
+		{"10.0.2.255", 0, false},
+		{"10.0.1.2", 0, false},
+		{"224.0.0.251", 251, true},
+		{"239.1.2.3", 3, true},
+	} {
+		group, ok := LinkGroup(netip.MustParseAddr(c.dst), subnet)
+		if group != c.group || ok != c.broadcast {
+			t.Errorf("%s is mapped to group %d %v, expected %d %v", c.dst, group, ok, c.group, c.broadcast)
+		}
+	}
+}
+
+func TestLinkPortNeighbor(t *testing.T) {
+	// the layer is not opened, so nothing is heard and no frame can be sent
+	port := &LinkPort{Layer: &aelayers.ReliableDataLinkLayer{Neighbors: &aelayers.NeighborDiscovery{}}}
+	if err := port.Send(1, makePacket(t, "10.0.1.2", "10.0.2.2", 64)); err == nil {
+		t.Error("Packet sent to a next hop which is not a neighbor")
+	}
+}
+
+func TestRouterMultiHop(t *testing.T) {
+
+	const (
+		SAMPLE_RATE = 48000
+
+		BYTE_PER_FRAME = 125
+		FRAME_INTERVAL = 256
+		CARRIER_SIZE   = 3
+
+		INPUT_BUFFER_SIZE  = 10000
+		OUTPUT_BUFFER_SIZE = 1
+		RECEIVE_BUFFER     = 10
+
+		POWER_THRESHOLD = 30
+
+		POWER_MONITOR_THRESHOLD = 0.4
+		POWER_MONITOR_WINDOW    = 10
+
+		ACK_TIMEOUT        = 1000 * time.Millisecond
+		MAX_RETRY_ATTEMPTS = 5
+	)
+
+	var preamble = modem.DigitalChripConfig{N: 4, Amplitude: 0x7fffffff}.New()
+
+	// A <-> B1 on segment ab, B2 <-> C on segment bc, B1 and B2 belong to the router node
+	network := device.Network[string]{
+		Config: device.NetworkConfig[string]{
+			{In: "ab", Out: "ab"},
+			{In: "ab", Out: "ab"},
+			{In: "bc", Out: "bc"},
+			{In: "bc", Out: "bc"},
+		},
+		SampleRate: SAMPLE_RATE,
+	}
+	devices := network.Build()
+	addresses := [4]aelayers.ReliableDataLinkAddress{1, 2, 3, 4}
+
+	var links [4]aelayers.ReliableDataLinkLayer
+	for i := range links {
+		links[i] = aelayers.ReliableDataLinkLayer{
+			PhysicalLayer: aelayers.PhysicalLayer{
+				Device: devices[i],
+				Decoder: aelayers.Decoder{
+					Demodulator: modem.Demodulator{
+						Preamble:                 preamble,
+						CarrierSize:              CARRIER_SIZE,
+						DemodulatePowerThreshold: fixed.FromFloat(POWER_THRESHOLD),
+						BufferSize:               RECEIVE_BUFFER,
+					},
+					BufferSize: INPUT_BUFFER_SIZE,
+				},
+				Encoder: aelayers.Encoder{
+					Modulator: modem.Modulator{
+						Preamble:      preamble,
+						CarrierSize:   CARRIER_SIZE,
+						BytePerFrame:  BYTE_PER_FRAME,
+						FrameInterval: FRAME_INTERVAL,
+					},
+					BufferSize: OUTPUT_BUFFER_SIZE,
+				},
+				PowerMonitor: aelayers.PowerMonitor{
+					Threshold:  fixed.FromFloat(POWER_MONITOR_THRESHOLD),
+					WindowSize: POWER_MONITOR_WINDOW,
+				},
+			},
+			Address:    addresses[i],
+			ACKTimeout: ACK_TIMEOUT,
+			MaxRetries: MAX_RETRY_ATTEMPTS,
+			BufferSize: RECEIVE_BUFFER,
+		}
+		links[i].Open()
+		defer links[i].Close()
+	}
+
+	ab := &LinkPort{Layer: &links[1], Address: netip.MustParseAddr("10.0.1.1")}
+	bc := &LinkPort{Layer: &links[2], Address: netip.MustParseAddr("10.0.2.1")}
+
+	r := Router{Ports: map[string]Port{"ab": ab, "bc": bc}}
+	r.Table.Add(Route{Prefix: netip.MustParsePrefix("10.0.1.0/24"), Port: "ab", NextHop: addresses[0]})
+	r.Table.Add(Route{Prefix: netip.MustParsePrefix("10.0.2.0/24"), Port: "bc", NextHop: addresses[3]})
+	r.Listen("ab", ab.Packets())
+	r.Listen("bc", bc.Packets())
+
+	// A sends to C through its gateway B
+	sent := makePacket(t, "10.0.1.2", "10.0.2.2", 64)
+	if err := links[0].Send(addresses[1], sent); err != nil {
+		t.Fatalf("Error sending packet: %v", err)
+	}
+
+	received, err := links[3].ReceiveWithTimeout(5 * time.Second)
+	if err != nil {
+		t.Fatalf("Error receiving packet: %v", err)
+	}
+	packet := gopacket.NewPacket(received, layers.LayerTypeIPv4, gopacket.Default)
+	ipv4, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if !ok {
+		t.Fatalf("Received packet is not IPv4: %v", packet)
+	}
+	if ipv4.TTL != 63 || string(ipv4.Payload) != "hello" {
+		t.Errorf("Unexpected packet after two hops: %v", packet)
+	}
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"Aethernet/pkg/layers"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+)
+
+// Route describes where packets matching Prefix are sent
+type Route struct {
+	Prefix  netip.Prefix
+	Port    string                         // the name of the outgoing port
+	NextHop layers.ReliableDataLinkAddress // the Aethernet address of the next hop, ignored by host ports
+}
+
+func (r Route) String() string {
+	return fmt.Sprintf("%v via %s/%d", r.Prefix, r.Port, r.NextHop)
+}
+
+// Table is a routing table which resolves addresses by longest prefix match
+type Table struct {
+	mu     sync.RWMutex
+	routes []Route // sorted by prefix length in descending order
+}
+
+func (t *Table) Add(route Route) error {
+	if !route.Prefix.IsValid() {
+		return fmt.Errorf("invalid prefix %v", route.Prefix)
+	}
+	route.Prefix = route.Prefix.Masked()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, r := range t.routes {
+		if r.Prefix == route.Prefix {
+			// replace the existing route
+			t.routes[i] = route
+			return nil
+		}
+	}
+	t.routes = append(t.routes, route)
+	sort.SliceStable(t.routes, func(i, j int) bool {
+		return t.routes[i].Prefix.Bits() > t.routes[j].Prefix.Bits()
+	})
+	return nil
+}
+
+func (t *Table) Remove(prefix netip.Prefix) bool {
+	prefix = prefix.Masked()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, r := range t.routes {
+		if r.Prefix == prefix {
+			t.routes = append(t.routes[:i], t.routes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Table) Lookup(addr netip.Addr) (Route, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, r := range t.routes {
+		if r.Prefix.Contains(addr) {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+func (t *Table) Routes() []Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	routes := make([]Route, len(t.routes))
+	copy(routes, t.routes)
+	return routes
+}
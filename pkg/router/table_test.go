@@ -0,0 +1,42 @@
+package router
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestTableLookup(t *testing.T) {
+
+	var table Table
+	table.Add(Route{Prefix: netip.MustParsePrefix("0.0.0.0/0"), Port: "default"})
+	table.Add(Route{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Port: "wide"})
+	table.Add(Route{Prefix: netip.MustParsePrefix("10.0.2.0/24"), Port: "narrow", NextHop: 2})
+	table.Add(Route{Prefix: netip.MustParsePrefix("10.0.2.7/32"), Port: "host", NextHop: 3})
+
+	cases := []struct {
+		addr string
+		port string
+	}{
+		{"10.0.2.7", "host"},
+		{"10.0.2.8", "narrow"},
+		{"10.1.0.1", "wide"},
+		{"1.1.1.1", "default"},
+	}
+
+	for _, c := range cases {
+		route, ok := table.Lookup(netip.MustParseAddr(c.addr))
+		if !ok || route.Port != c.port {
+			t.Errorf("Lookup(%s) = %v, %v, expected port %s", c.addr, route, ok, c.port)
+		}
+	}
+
+	table.Remove(netip.MustParsePrefix("10.0.2.0/24"))
+	if route, _ := table.Lookup(netip.MustParseAddr("10.0.2.8")); route.Port != "wide" {
+		t.Errorf("Expected the route to fall back to wide, got %v", route)
+	}
+
+	table.Remove(netip.MustParsePrefix("0.0.0.0/0"))
+	if route, ok := table.Lookup(netip.MustParseAddr("1.1.1.1")); ok {
+		t.Errorf("Expected no route, got %v", route)
+	}
+}